@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/foldy-project/foldy/cli/pkg/installer"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/client-go/util/homedir"
+)
+
+var backupOutput string
+
+var restoreNamespaces []string
+
+var restoreConflict string
+
+func init() {
+	backupCmd.PersistentFlags().StringVarP(&backupOutput, "output", "o", "foldy-backup.tar.gz", "path of the backup to write")
+
+	restoreCmd.PersistentFlags().StringSliceVar(&restoreNamespaces, "namespace-map", nil, "restore the resources of a namespace to another one, e.g. old=new")
+	restoreCmd.PersistentFlags().StringVar(&restoreConflict, "on-conflict", installer.ConflictSkip, "what to do with resources that already exist (skip, overwrite or fail)")
+	restoreCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "print the commands that would be run without changing the cluster")
+	viper.BindPFlag("dryRun", restoreCmd.PersistentFlags().Lookup("dry-run"))
+
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
+}
+
+// defaultBackupDir is where uninstall backs up to
+func defaultBackupDir() string {
+	return filepath.Join(homedir.HomeDir(), ".foldy", "backups")
+}
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Exports the foldy resources of every namespace",
+	Long: `Exports every backend, dataset, experiment, model and transform of every namespace, including their status, into a tarball. The resources are lost when foldy is uninstalled, which backs them up to ~/.foldy/backups first unless --no-backup is given.
+
+  # Back up, reinstall and restore
+  foldy backup -o foldy-backup.tar.gz
+  foldy uninstall && foldy install
+  foldy restore foldy-backup.tar.gz`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		install, err := newInstaller("")
+		if err != nil {
+			return err
+		}
+		defer install.Close()
+		ctx, cancel := commandContext()
+		defer cancel()
+		manifest, err := install.BackupToFile(ctx, backupOutput)
+		if err != nil {
+			return err
+		}
+		log.Printf("Backed up %d resources to %s", manifest.Total(), backupOutput)
+		return nil
+	},
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Re-creates the foldy resources of a backup",
+	Long: `Re-creates the resources of a backup created with 'foldy backup', along with their status. Foldy has to be installed. Missing namespaces are created. Resources that already exist are skipped unless --on-conflict says otherwise.
+
+  # Restore the resources of team-a to team-b instead
+  foldy restore foldy-backup.tar.gz --namespace-map team-a=team-b
+
+  # Replace resources that were recreated since the backup
+  foldy restore foldy-backup.tar.gz --on-conflict overwrite`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		namespaces := make(map[string]string)
+		for _, mapping := range restoreNamespaces {
+			parts := strings.SplitN(mapping, "=", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return fmt.Errorf("invalid namespace mapping '%s' (expected old=new)", mapping)
+			}
+			namespaces[parts[0]] = parts[1]
+		}
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		install, err := newInstaller("")
+		if err != nil {
+			return err
+		}
+		defer install.Close()
+		install.Events.Subscribe(installer.LogRenderer(install.Verbose))
+		ctx, cancel := commandContext()
+		defer cancel()
+		results, err := install.Restore(ctx, f, &installer.RestoreOptions{
+			Namespaces: namespaces,
+			Conflict:   restoreConflict,
+		})
+		if install.DryRun {
+			install.Plan.Print(os.Stdout)
+			return err
+		}
+		if len(results) > 0 {
+			if err := installer.PrintRestoreResults(os.Stdout, results); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			if result.Action == installer.RestoreFailed {
+				return fmt.Errorf("some resources could not be restored")
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+
+	"github.com/foldy-project/foldy/cli/pkg/installer"
+	"github.com/spf13/cobra"
+)
+
+var bundleOutput string
+
+var bundleArgoCDVersion string
+
+func init() {
+	bundleCreateCmd.PersistentFlags().StringVarP(&bundleOutput, "output", "o", "foldy-bundle.tar.gz", "path of the bundle to write")
+	bundleCreateCmd.PersistentFlags().StringVar(&bundleArgoCDVersion, "argocd-version", "v1.5.0", "Argo CD release to pin the bundle to")
+
+	bundleCmd.AddCommand(bundleCreateCmd)
+	rootCmd.AddCommand(bundleCmd)
+}
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Manages bundles for installing without internet access",
+}
+
+var bundleCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Packages everything needed to install foldy into a tarball",
+	Long: `Packages a pinned Argo CD manifest and mirrors of every repository the components are deployed from into a tarball. Container images are not included and have to be made available to the cluster separately.
+
+  # On a machine with internet access
+  foldy bundle create -o foldy-bundle.tar.gz
+
+  # On a machine that can only reach the cluster
+  foldy install --bundle foldy-bundle.tar.gz`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		install := installer.NewInstaller(nil)
+		install.Version = version
+		ctx, cancel := commandContext()
+		defer cancel()
+		if err := install.CreateBundle(ctx, bundleOutput, bundleArgoCDVersion); err != nil {
+			return err
+		}
+		log.Printf("Wrote %s", bundleOutput)
+		return nil
+	},
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/foldy-project/foldy/cli/pkg/installer"
+)
+
+// clusterResult is the outcome of installing to one cluster
+type clusterResult struct {
+	Context  string
+	Duration time.Duration
+	Plan     *installer.Plan
+	Err      error
+}
+
+// installClusters installs to every kubeconfig context in
+// parallel and prints a summary once all of them are done.
+// An error is returned if any of the clusters failed.
+func installClusters(contexts []string, args []string) error {
+	ctx, cancel := commandContext()
+	defer cancel()
+	results := make([]*clusterResult, len(contexts))
+	var wg sync.WaitGroup
+	for i, kubeContext := range contexts {
+		wg.Add(1)
+		go func(i int, kubeContext string) {
+			defer wg.Done()
+			result := &clusterResult{Context: kubeContext}
+			results[i] = result
+			start := time.Now()
+			defer func() {
+				result.Duration = time.Since(start)
+			}()
+			install, err := newInstaller(kubeContext)
+			if err != nil {
+				result.Err = err
+				return
+			}
+			defer install.Close()
+			if err := renderProgress(install); err != nil {
+				result.Err = err
+				return
+			}
+			result.Plan = install.Plan
+			result.Err = runInstall(ctx, install, args)
+		}(i, kubeContext)
+	}
+	wg.Wait()
+	if jsonlOutput() {
+		// Every event already names its cluster
+		return clustersError(results)
+	}
+	if dryRun {
+		for _, result := range results {
+			if result.Err == nil {
+				fmt.Printf("### %s\n", result.Context)
+				result.Plan.Print(os.Stdout)
+				fmt.Println()
+			}
+		}
+	}
+	if err := printClusterResults(os.Stdout, results); err != nil {
+		return err
+	}
+	return clustersError(results)
+}
+
+// printClusterResults writes a table with a row per cluster
+func printClusterResults(w io.Writer, results []*clusterResult) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTEXT\tRESULT\tDURATION\tERROR")
+	for _, result := range results {
+		status := "Healthy"
+		message := "-"
+		if result.Err != nil {
+			status = "Failed"
+			// Keep each cluster on a single row
+			message = strings.Join(strings.Fields(result.Err.Error()), " ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%v\t%s\n", result.Context, status, result.Duration.Round(time.Second), message)
+	}
+	return tw.Flush()
+}
+
+// clustersError returns an error naming the failed clusters,
+// or nil if every cluster succeeded
+func clustersError(results []*clusterResult) error {
+	var failed []string
+	for _, result := range results {
+		if result.Err != nil {
+			failed = append(failed, result.Context)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("failed to install to %d of %d clusters: %s", len(failed), len(results), strings.Join(failed, ", "))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/foldy-project/foldy/cli/pkg/installer"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+var configOutput string
+
+func init() {
+	configViewCmd.Flags().StringVarP(&configOutput, "output", "o", "table", "output format (table, or yaml for the merged config only)")
+
+	configCmd.AddCommand(configViewCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Shows and edits config.yaml",
+	Long: `Shows and edits config.yaml. Every key can also be set with an environment variable, e.g. FOLDY_INGRESS_API_HOST for ingress.api.host, and some with flags. Flags take precedence over the environment, which takes precedence over the file.
+
+Secrets are kept in credentials.yaml instead and are not shown.`,
+}
+
+var configViewCmd = &cobra.Command{
+	Use:          "view",
+	Short:        "Shows the effective config and where each value came from",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch configOutput {
+		case "yaml":
+			data, err := yaml.Marshal(installer.EffectiveConfig())
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(data)
+			return err
+		case "table":
+		default:
+			return fmt.Errorf("unknown output format '%s'", configOutput)
+		}
+		changed := changedFlagKeys(cmd)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+		for _, key := range installer.ConfigKeys() {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", key.Name, formatConfigValue(key.Value()), key.Source(changed[strings.ToLower(key.Name)]))
+		}
+		fmt.Fprintf(tw, "\nFile: %s\n", installer.DefaultConfigFile())
+		return tw.Flush()
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:          "validate [file]",
+	Short:        "Checks config.yaml for unknown keys and invalid values",
+	Args:         cobra.MaximumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file := installer.DefaultConfigFile()
+		if len(args) > 0 {
+			file = args[0]
+		}
+		if _, err := os.Stat(file); err != nil {
+			return err
+		}
+		if _, err := installer.LoadConfigFile(file); err != nil {
+			return err
+		}
+		fmt.Printf("%s is valid\n", file)
+		return nil
+	},
+}
+
+var configGetCmd = &cobra.Command{
+	Use:          "get <key>",
+	Short:        "Prints the effective value of a key",
+	Example:      "  foldy config get ingress.api.host",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := installer.LookupConfigKey(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println(formatConfigValue(key.Value()))
+		return nil
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Sets a key in config.yaml",
+	Long: `Sets a key in config.yaml, creating the file if needed. The result is validated before it is saved.
+
+Comments in the file are not preserved.`,
+	Example: `  foldy config set ingress.api.host api.example.com
+  foldy config set componentTimeout 15m`,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file := installer.DefaultConfigFile()
+		if err := installer.SetConfigValue(file, args[0], args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Set %s in %s\n", args[0], file)
+		return nil
+	},
+}
+
+// changedFlagKeys returns the lowercased config keys of the
+// flags given on the command line, e.g. showsecrets for
+// --show-secrets
+func changedFlagKeys(cmd *cobra.Command) map[string]bool {
+	changed := make(map[string]bool)
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		changed[strings.ToLower(strings.Replace(f.Name, "-", "", -1))] = true
+	})
+	return changed
+}
+
+// formatConfigValue prints a value on a single line
+func formatConfigValue(value interface{}) string {
+	switch v := value.(type) {
+	case installer.Duration:
+		return time.Duration(v).String()
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return "-"
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	case string:
+		if v == "" {
+			return "-"
+		}
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+// validateConfig rejects a config.yaml with unknown keys or
+// invalid values before any command uses it
+func validateConfig(cmd *cobra.Command) error {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c == configCmd {
+			// Leave it to 'foldy config' to show and fix it
+			return nil
+		}
+	}
+	if _, err := installer.LoadConfigFile(installer.DefaultConfigFile()); err != nil {
+		cmd.SilenceUsage = true
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/viper"
+)
+
+// commandContext returns a context that is canceled when the
+// user interrupts the CLI or the --timeout elapses. A second
+// interrupt exits immediately.
+func commandContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout := viper.GetDuration("timeout"); timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		parentCancel := cancel
+		cancel = func() {
+			cancelTimeout()
+			parentCancel()
+		}
+	}
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-sig:
+			log.Printf("Interrupted. Aborting... (interrupt again to exit immediately)")
+			cancel()
+		case <-stopped:
+			return
+		}
+		select {
+		case <-sig:
+			os.Exit(1)
+		case <-stopped:
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sig)
+		close(stopped)
+		cancel()
+	}
+}
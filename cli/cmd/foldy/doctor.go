@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/foldy-project/foldy/cli/pkg/installer"
+	"github.com/spf13/cobra"
+)
+
+var doctorOutput string
+
+func init() {
+	doctorCmd.PersistentFlags().StringVarP(&doctorOutput, "output", "o", "table", "output format (table or json)")
+
+	rootCmd.AddCommand(doctorCmd)
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Checks whether foldy can be installed",
+	Long: `Checks that the Kubernetes version is supported, your permissions for everything the installer changes in the current mode, the default storage class, the allocatable CPU and memory, and the presence of bash and kubectl. Each check passes, warns or fails. The exit code is non-zero if any check failed.
+
+The same checks run at the start of 'foldy install' without components, which stops if any of them fails.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		install, err := newInstaller("")
+		if err != nil {
+			return err
+		}
+		defer install.Close()
+		ctx, cancel := commandContext()
+		defer cancel()
+		checks, err := install.Doctor(ctx)
+		if err != nil {
+			return err
+		}
+		switch doctorOutput {
+		case "table":
+			err = installer.PrintChecks(os.Stdout, checks)
+		case "json":
+			err = installer.PrintChecksJSON(os.Stdout, checks)
+		default:
+			return fmt.Errorf("unknown output format '%s'", doctorOutput)
+		}
+		if err != nil {
+			return err
+		}
+		for _, check := range checks {
+			if check.Result == installer.CheckFail {
+				return fmt.Errorf("some checks failed")
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/foldy-project/foldy/cli/pkg/installer"
+	"github.com/spf13/cobra"
+)
+
+var graphFormat string
+
+func init() {
+	graphCmd.PersistentFlags().StringVar(&graphFormat, "format", "text", "output format (text or dot)")
+
+	rootCmd.AddCommand(graphCmd)
+}
+
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Prints the component dependency graph",
+	Long: `Prints the component dependency graph in the order components are installed. Unknown dependencies and dependency cycles are reported as errors.
+
+  # Render the graph with Graphviz
+  foldy graph --format dot | dot -Tpng > graph.png`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		g, err := installer.NewInstaller(nil).Graph()
+		if err != nil {
+			return err
+		}
+		switch graphFormat {
+		case "text":
+			g.WriteText(os.Stdout)
+		case "dot":
+			g.WriteDOT(os.Stdout)
+		default:
+			return fmt.Errorf("unknown format '%s'", graphFormat)
+		}
+		return nil
+	},
+}
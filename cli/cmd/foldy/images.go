@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/foldy-project/foldy/cli/pkg/installer"
+	"github.com/spf13/cobra"
+)
+
+var imagesOutput string
+
+func init() {
+	imagesListCmd.PersistentFlags().StringVarP(&imagesOutput, "output", "o", "", "output format (empty for one image per line, or wide)")
+
+	imagesCmd.AddCommand(imagesListCmd)
+	rootCmd.AddCommand(imagesCmd)
+}
+
+var imagesCmd = &cobra.Command{
+	Use:   "images",
+	Short: "Inspects the container images used by foldy",
+}
+
+var imagesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists every container image used by the components",
+	Long: `Lists every container image used by the components, including the Argo CD image configured with argocd.image and images that are only pulled at runtime. Images of installed components are discovered from their workloads.
+
+Set images.registry in config.yaml to pull every image from a private registry instead. The wide output shows where each image has to be mirrored to.
+
+  # Mirror every image into a private registry
+  foldy images list -o wide`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if imagesOutput != "" && imagesOutput != "wide" {
+			return fmt.Errorf("unknown output format '%s'", imagesOutput)
+		}
+		install, err := newInstaller("")
+		if err != nil {
+			return err
+		}
+		defer install.Close()
+		ctx, cancel := commandContext()
+		defer cancel()
+		uses, err := install.Images(ctx)
+		if err != nil {
+			return err
+		}
+		return installer.PrintImages(os.Stdout, uses, imagesOutput == "wide")
+	},
+}
@@ -1,28 +1,64 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"path/filepath"
-
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
+	"os"
+	"time"
 
 	"github.com/foldy-project/foldy/cli/pkg/installer"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 var skipDependencies bool
 
+var dryRun bool
+
+var parallelism int
+
+var atomic bool
+
+var componentTimeout time.Duration
+
+var applicationTimeout time.Duration
+
+var bundlePath string
+
+var installContexts []string
+
 func init() {
 	installCmd.PersistentFlags().BoolVar(&skipDependencies, "skip-dependencies", false, "only install the specified components without installing dependencies")
 	viper.BindPFlag("skipDependencies", installCmd.PersistentFlags().Lookup("skip-dependencies"))
 
-	installCmd.PersistentFlags().StringP("password", "p", "", "installation password")
+	installCmd.PersistentFlags().IntVar(&parallelism, "parallelism", 4, "maximum number of components handled concurrently")
+	viper.BindPFlag("parallelism", installCmd.PersistentFlags().Lookup("parallelism"))
+
+	installCmd.PersistentFlags().DurationVar(&componentTimeout, "component-timeout", 10*time.Minute, "abort a component if installing or uninstalling it takes longer than this")
+	viper.BindPFlag("componentTimeout", installCmd.PersistentFlags().Lookup("component-timeout"))
+
+	installCmd.PersistentFlags().DurationVar(&applicationTimeout, "application-timeout", 5*time.Minute, "wait at most this long for each Argo CD Application to become Synced and Healthy (0 to not wait)")
+	viper.BindPFlag("applicationTimeout", installCmd.PersistentFlags().Lookup("application-timeout"))
+
+	installCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "print the commands that would be run without changing the cluster")
+	viper.BindPFlag("dryRun", installCmd.PersistentFlags().Lookup("dry-run"))
+
+	installCmd.PersistentFlags().BoolVar(&atomic, "atomic", false, "uninstall components created by this run if any component fails")
+	viper.BindPFlag("atomic", installCmd.PersistentFlags().Lookup("atomic"))
+
+	installCmd.PersistentFlags().Bool("skip-doctor", false, "don't run the preflight checks of 'foldy doctor' before installing everything")
+	viper.BindPFlag("skipDoctor", installCmd.PersistentFlags().Lookup("skip-doctor"))
+
+	installCmd.PersistentFlags().StringVar(&bundlePath, "bundle", "", "install from a bundle created with 'foldy bundle create' instead of the internet")
+
+	installCmd.PersistentFlags().StringSliceVar(&installContexts, "contexts", nil, "install to the clusters of these kubeconfig contexts in parallel")
+
+	installCmd.PersistentFlags().StringP("password", "p", "", "Argo CD admin password (generated and saved to credentials.yaml on first install if empty)")
 	viper.BindPFlag("password", installCmd.PersistentFlags().Lookup("password"))
 
+	addOutputFlag(installCmd)
+
 	rootCmd.AddCommand(installCmd)
 }
 
@@ -38,39 +74,97 @@ var installCmd = &cobra.Command{
   foldy install foldy
 
   # Install only specified components
-  foldy install argocd cert-manager argo-events`,
-	Args: cobra.ArbitraryArgs,
-	RunE: func(cmd *cobra.Command, args []string) error {
-		fmt.Println("Installing...")
+  foldy install argocd cert-manager argo-events
 
-		kubeconfig := filepath.Join(homedir.HomeDir(), ".kube", "config")
-		config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
-		if err != nil {
+  # Review what would be changed without touching the cluster
+  foldy install --dry-run
+
+  # Install only the foldy controller with Helm, without Argo CD,
+  # from the charts of a checkout of the foldy repository
+  FOLDY_HELM_CHARTS_DIR=~/foldy/charts foldy install --mode helm
+
+  # Install without internet access
+  foldy install --bundle foldy-bundle.tar.gz
+
+  # Report progress as JSON lines for another program to consume
+  foldy install --output jsonl
+
+  # Install to several clusters at once
+  foldy install --contexts staging,prod-us,prod-eu`,
+	Args:         cobra.ArbitraryArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := ensurePassword(); err != nil {
 			return err
 		}
-		cl, err := client.New(config, client.Options{})
+		if len(installContexts) > 0 {
+			if viper.GetString("context") != "" {
+				return fmt.Errorf("--context and --contexts are mutually exclusive")
+			}
+			return installClusters(installContexts, args)
+		}
+		install, err := newInstallerOrSession()
 		if err != nil {
 			return err
 		}
-		install := installer.NewInstaller(cl)
-		if len(args) == 0 {
-			log.Printf("Installing everything...")
-			if err := install.InstallAll(); err != nil {
-				return err
+		defer install.Close()
+		if err := renderProgress(install); err != nil {
+			return err
+		}
+		ctx, cancel := commandContext()
+		defer cancel()
+		if err := runInstall(ctx, install, args); err != nil {
+			return err
+		}
+		if install.DryRun {
+			if !jsonlOutput() {
+				install.Plan.Print(os.Stdout)
 			}
+			return nil
+		}
+		if len(args) == 0 {
 			log.Printf("all components appear to be healthy")
+		} else if len(args) == 1 {
+			log.Printf("component '%s' appears to be healthy", args[0])
 		} else {
-			log.Printf("Installing %v", args)
-			if err := install.InstallComponentsByName(args); err != nil {
-				return err
-			}
-			if len(args) == 1 {
-				log.Printf("component '%s' appears to be healthy", args[0])
-			} else {
-				log.Printf("components %v appear to be healthy", args)
-			}
+			log.Printf("components %v appear to be healthy", args)
 		}
-
 		return nil
 	},
 }
+
+// ensurePassword generates the Argo CD admin password on
+// first install, unless one was given with --password or
+// FOLDY_PASSWORD, and saves it to the credentials file
+func ensurePassword() error {
+	if viper.GetString("password") != "" {
+		return nil
+	}
+	file := installer.DefaultCredentialsFile()
+	password, generated, err := installer.EnsurePassword(file, dryRun)
+	if err != nil {
+		return err
+	}
+	if generated && !dryRun {
+		log.Printf("Generated a new Argo CD admin password and saved it to %s", file)
+	}
+	viper.Set("password", password)
+	return nil
+}
+
+// runInstall installs the components, or everything if none
+// are given, from the --bundle if one was specified
+func runInstall(ctx context.Context, install *installer.Installer, args []string) error {
+	if bundlePath != "" {
+		bundle, err := installer.OpenBundle(bundlePath)
+		if err != nil {
+			return err
+		}
+		defer bundle.Close()
+		install.Bundle = bundle
+	}
+	if len(args) == 0 {
+		return install.InstallAll(ctx)
+	}
+	return install.InstallComponentsByName(ctx, args)
+}
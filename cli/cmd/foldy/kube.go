@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/foldy-project/foldy/cli/pkg/installer"
+	"github.com/spf13/viper"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// inCluster returns true if the CLI runs in a pod and no
+// kubeconfig was given or found at the default locations
+func inCluster() bool {
+	if viper.GetString("kubeconfig") != "" || viper.GetString("context") != "" {
+		return false
+	}
+	if _, ok := os.LookupEnv("KUBECONFIG"); ok {
+		return false
+	}
+	if _, err := os.Stat(clientcmd.RecommendedHomeFile); err == nil {
+		return false
+	}
+	_, ok := os.LookupEnv("KUBERNETES_SERVICE_HOST")
+	return ok
+}
+
+// restConfig returns the config for the kubeconfig context,
+// or the current context if it is empty. The service account
+// of the pod is used when running in a cluster.
+func restConfig(kubeContext string) (*rest.Config, error) {
+	if kubeContext == "" && inCluster() {
+		return rest.InClusterConfig()
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = viper.GetString("kubeconfig")
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
+
+// newInstaller returns an installer for the kubeconfig
+// context, or the one selected with --context if it is empty.
+// The caller has to Close it.
+func newInstaller(kubeContext string) (*installer.Installer, error) {
+	if kubeContext == "" {
+		kubeContext = viper.GetString("context")
+	}
+	config, err := restConfig(kubeContext)
+	if err != nil {
+		if session, _ := installer.ActiveSession(installer.DefaultCredentialsFile()); session != nil {
+			return nil, fmt.Errorf("%v: the session for %s only grants access to Argo CD, which is enough for status, install and upgrade but not for this command", err, session.Endpoint)
+		}
+		return nil, err
+	}
+	cl, err := client.New(config, client.Options{})
+	if err != nil {
+		return nil, err
+	}
+	install := installer.NewInstaller(cl)
+	install.Version = version
+	install.RestConfig = config
+	install.InCluster = kubeContext == "" && inCluster()
+	if kubeContext == "" {
+		// The session of 'foldy login' is for the default
+		// cluster, not whichever context was selected. The
+		// installer checks that the cluster exposes it.
+		session, err := installer.ActiveSession(installer.DefaultCredentialsFile())
+		if _, ok := err.(*installer.SessionExpiredError); ok {
+			// Argo CD can still be reached by port-forwarding
+			log.Printf("Warning: %v", err)
+		} else if err != nil {
+			return nil, err
+		}
+		install.Session = session
+	}
+	if install.Context != kubeContext {
+		install.Context = kubeContext
+		install.Helm = installer.NewHelmClient(install.Kubeconfig, kubeContext, install.Verbose)
+	}
+	return install, nil
+}
+
+// newInstallerOrSession returns an installer for the cluster
+// of the selected context. Without a kubeconfig, it falls
+// back to the session of 'foldy login', through which only
+// the Argo CD API can be reached.
+func newInstallerOrSession() (*installer.Installer, error) {
+	kubeContext := viper.GetString("context")
+	if _, err := restConfig(kubeContext); err != nil && kubeContext == "" {
+		if session, _ := installer.ActiveSession(installer.DefaultCredentialsFile()); session != nil {
+			log.Printf("No access to the cluster (%v), using the session for %s instead", err, session.Endpoint)
+			install := installer.NewSessionInstaller(session)
+			install.Version = version
+			return install, nil
+		}
+	}
+	return newInstaller(kubeContext)
+}
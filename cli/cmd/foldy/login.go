@@ -1,19 +1,120 @@
 package main
 
 import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/foldy-project/foldy/cli/pkg/installer"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
+var loginUsername string
+
+var loginPassword string
+
 func init() {
+	loginCmd.PersistentFlags().StringVarP(&loginUsername, "username", "u", "admin", "user to log in as")
+	loginCmd.PersistentFlags().StringVarP(&loginPassword, "password", "p", "", "password of the user (defaults to FOLDY_PASSWORD or credentials.yaml, prompts if unset)")
+
 	rootCmd.AddCommand(loginCmd)
+	rootCmd.AddCommand(logoutCmd)
 }
 
 var loginCmd = &cobra.Command{
-	Use:   "login",
+	Use:   "login <endpoint>",
 	Short: "Login to an existing foldy deployment",
-	Long:  ``,
-	Args:  cobra.ArbitraryArgs,
+	Long: `Logs in to an existing foldy deployment through its Argo CD API and stores the session token in credentials.yaml. Other commands then reach Argo CD at the endpoint instead of port-forwarding to it, until the token expires or is rejected. With a kubeconfig, the session is only used for the current context, and only if that cluster routes the endpoint to its Argo CD with ingress.argocd, so that it can't be used against the wrong cluster.
+
+Without a kubeconfig, status, install and upgrade work through the session alone. They only see and manage the Argo CD Applications of the components: hooks that need the cluster, such as creating namespaces or configuring ingress, are skipped and nothing is recorded in the install ledger. Every other command needs access to the cluster.
+
+  # Log in as admin, prompting for the password
+  foldy login argocd.example.com
+
+  # Then upgrade foldy without a kubeconfig
+  foldy upgrade foldy`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password := loginPassword
+		if password == "" {
+			password = viper.GetString("password")
+		}
+		if password == "" {
+			if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+				return fmt.Errorf("no password given, use --password or FOLDY_PASSWORD")
+			}
+			fmt.Fprintf(os.Stderr, "Password for %s: ", loginUsername)
+			data, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				return err
+			}
+			password = string(data)
+		}
+		ctx, cancel := commandContext()
+		defer cancel()
+		session, err := installer.Login(ctx, args[0], loginUsername, password)
+		if err != nil {
+			return err
+		}
+		file := installer.DefaultCredentialsFile()
+		creds, err := installer.LoadCredentials(file)
+		if err != nil {
+			return err
+		}
+		creds.SetSession(session)
+		if err := installer.SaveCredentials(file, creds); err != nil {
+			return err
+		}
+		if session.ExpiresAt != nil {
+			log.Printf("Logged in to %s as %s until %s", session.Endpoint, session.Username, session.ExpiresAt.Local().Format(time.RFC1123))
+		} else {
+			log.Printf("Logged in to %s as %s", session.Endpoint, session.Username)
+		}
+		return nil
+	},
+}
+
+var logoutCmd = &cobra.Command{
+	Use:          "logout [endpoint]",
+	Short:        "Logout of a foldy deployment",
+	Long:         `Revokes the session token obtained with 'foldy login' and removes it from credentials.yaml. Without an endpoint, the current session is ended.`,
+	Args:         cobra.MaximumNArgs(1),
+	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		var endpoint string
+		if len(args) > 0 {
+			var err error
+			if endpoint, err = installer.NormalizeEndpoint(args[0]); err != nil {
+				return err
+			}
+		}
+		file := installer.DefaultCredentialsFile()
+		creds, err := installer.LoadCredentials(file)
+		if err != nil {
+			return err
+		}
+		session := creds.Session(endpoint)
+		if session == nil {
+			return fmt.Errorf("not logged in")
+		}
+		ctx, cancel := commandContext()
+		defer cancel()
+		if !session.Expired(time.Now()) {
+			if err := installer.Logout(ctx, session); err != nil {
+				// The token is forgotten regardless
+				log.Printf("Warning: failed to revoke the session on the server: %v", err)
+			}
+		}
+		creds.RemoveSession(session.Endpoint)
+		if err := installer.SaveCredentials(file, creds); err != nil {
+			return err
+		}
+		log.Printf("Logged out of %s", session.Endpoint)
 		return nil
 	},
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/foldy-project/foldy/cli/pkg/installer"
+	"github.com/spf13/cobra"
+)
+
+// Formats of --output for commands that report progress
+const (
+	outputText  = "text"
+	outputJSONL = "jsonl"
+)
+
+var progressOutput string
+
+// lockedWriter serializes writes of installers that run
+// concurrently, so that lines of JSON don't interleave
+type lockedWriter struct {
+	w io.Writer
+	l sync.Mutex
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.l.Lock()
+	defer w.l.Unlock()
+	return w.w.Write(p)
+}
+
+var progressWriter = &lockedWriter{w: os.Stdout}
+
+func addOutputFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVarP(&progressOutput, "output", "o", outputText, "progress format (text, or jsonl to write one JSON event per line to stdout)")
+}
+
+// jsonlOutput returns true if progress is written as JSON
+// lines, in which case nothing else may be printed to stdout
+func jsonlOutput() bool {
+	return progressOutput == outputJSONL
+}
+
+// renderProgress subscribes the renderer selected by --output
+// to the installer's events
+func renderProgress(install *installer.Installer) error {
+	switch progressOutput {
+	case outputText:
+		install.Events.Subscribe(installer.LogRenderer(install.Verbose))
+	case outputJSONL:
+		install.Events.Subscribe(installer.JSONLRenderer(progressWriter))
+	default:
+		return fmt.Errorf("unknown output format '%s' (expected %s or %s)", progressOutput, outputText, outputJSONL)
+	}
+	return nil
+}
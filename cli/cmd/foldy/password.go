@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/foldy-project/foldy/cli/pkg/installer"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	passwordRotateCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "print the commands that would be run without changing the cluster")
+	viper.BindPFlag("dryRun", passwordRotateCmd.PersistentFlags().Lookup("dry-run"))
+
+	passwordCmd.AddCommand(passwordRotateCmd)
+	rootCmd.AddCommand(passwordCmd)
+}
+
+var passwordCmd = &cobra.Command{
+	Use:   "password",
+	Short: "Manages the Argo CD admin password",
+}
+
+var passwordRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replaces the Argo CD admin password with a new random one",
+	Long: `Replaces the Argo CD admin password with a new random one. The password is saved to credentials.yaml, which is only readable by you, and its bcrypt hash is patched into argocd-secret. Existing Argo CD sessions are invalidated.
+
+If patching the secret fails, credentials.yaml is restored so that it keeps matching the cluster.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		install, err := newInstaller("")
+		if err != nil {
+			return err
+		}
+		defer install.Close()
+		install.Events.Subscribe(installer.LogRenderer(install.Verbose))
+		ctx, cancel := commandContext()
+		defer cancel()
+		file := installer.DefaultCredentialsFile()
+		if err := install.RotatePassword(ctx, file); err != nil {
+			return err
+		}
+		if install.DryRun {
+			install.Plan.Print(os.Stdout)
+			return nil
+		}
+		log.Printf("Rotated the Argo CD admin password and saved it to %s", file)
+		return nil
+	},
+}
@@ -1,14 +1,10 @@
 package main
 
 import (
-	"path/filepath"
-
 	"github.com/foldy-project/foldy/cli/pkg/portfwd"
 
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
-
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 func init() {
@@ -21,8 +17,7 @@ var portfwdCmd = &cobra.Command{
 	Long:  ``,
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		kubeconfig := filepath.Join(homedir.HomeDir(), ".kube", "config")
-		config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+		config, err := restConfig(viper.GetString("context"))
 		if err != nil {
 			return err
 		}
@@ -32,8 +27,10 @@ var portfwdCmd = &cobra.Command{
 		}
 		p.Verbose = true
 		p.AddAllPorts()
-		for {
-		}
+		ctx, cancel := commandContext()
+		defer cancel()
+		<-ctx.Done()
+		p.Close()
 		return nil
 	},
 }
@@ -13,9 +13,15 @@ import (
 
 var rootCmd = &cobra.Command{
 	Use: "foldy",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateConfig(cmd); err != nil {
+			return err
+		}
+		// Register the user's own components next to the built-in ones
+		return installer.LoadComponentFiles(installer.DefaultComponentsDir())
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		log.Printf("verbose=%b", viper.Get("verbose"))
-		log.Printf("password=%v", viper.Get("password"))
 		return nil
 	},
 }
@@ -27,6 +33,18 @@ func init() {
 	rootCmd.PersistentFlags().BoolP("show-secrets", "x", false, "print secrets to stdout instead of injecting them as env variables")
 	viper.BindPFlag("showSecrets", rootCmd.PersistentFlags().Lookup("show-secrets"))
 
+	rootCmd.PersistentFlags().String("mode", installer.InstallModeArgoCD, "how components are deployed (argocd, or helm to install charts directly without Argo CD)")
+	viper.BindPFlag("mode", rootCmd.PersistentFlags().Lookup("mode"))
+
+	rootCmd.PersistentFlags().Duration("timeout", 0, "abort the command if it takes longer than this (e.g. 30m, 0 for no limit)")
+	viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout"))
+
+	rootCmd.PersistentFlags().String("kubeconfig", "", "path to the kubeconfig file (defaults to $KUBECONFIG, ~/.kube/config or the pod's service account)")
+	viper.BindPFlag("kubeconfig", rootCmd.PersistentFlags().Lookup("kubeconfig"))
+
+	rootCmd.PersistentFlags().String("context", "", "kubeconfig context to use instead of the current one")
+	viper.BindPFlag("context", rootCmd.PersistentFlags().Lookup("context"))
+
 	installer.ConfigureViper()
 }
 
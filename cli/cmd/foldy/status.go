@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/foldy-project/foldy/cli/pkg/installer"
+	"github.com/spf13/cobra"
+)
+
+var statusOutput string
+
+func init() {
+	statusCmd.PersistentFlags().StringVarP(&statusOutput, "output", "o", "table", "output format (table or json)")
+
+	rootCmd.AddCommand(statusCmd)
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Reports the health of each installed component",
+	Long: `Reports the health of each installed component, including its namespaces, CRDs, Argo CD Application and deployments. The exit code is non-zero if any installed component is degraded. Components that were never installed are reported as NotInstalled.
+
+  # Machine-readable output for monitoring
+  foldy status -o json`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		install, err := newInstallerOrSession()
+		if err != nil {
+			return err
+		}
+		defer install.Close()
+		ctx, cancel := commandContext()
+		defer cancel()
+		statuses, err := install.Status(ctx)
+		if err != nil {
+			return err
+		}
+		switch statusOutput {
+		case "table":
+			err = installer.PrintStatusTable(os.Stdout, statuses)
+		case "json":
+			err = installer.PrintStatusJSON(os.Stdout, statuses)
+		default:
+			return fmt.Errorf("unknown output format '%s'", statusOutput)
+		}
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			if status.Degraded() {
+				return installer.ErrDegraded
+			}
+		}
+		return nil
+	},
+}
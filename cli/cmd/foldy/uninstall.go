@@ -1,26 +1,49 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
-	"path/filepath"
+	"os"
+	"time"
 
 	"github.com/foldy-project/foldy/cli/pkg/installer"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 var force bool
 
+var uninstallBackupDir string
+
+var noBackup bool
+
+var purge bool
+
 func init() {
 	uninstallCmd.PersistentFlags().BoolVar(&skipDependencies, "skip-dependencies", false, "only install the specified components without installing dependencies")
 	viper.BindPFlag("skipDependencies", uninstallCmd.PersistentFlags().Lookup("skip-dependencies"))
 
+	uninstallCmd.PersistentFlags().IntVar(&parallelism, "parallelism", 4, "maximum number of components handled concurrently")
+	viper.BindPFlag("parallelism", uninstallCmd.PersistentFlags().Lookup("parallelism"))
+
+	uninstallCmd.PersistentFlags().DurationVar(&componentTimeout, "component-timeout", 10*time.Minute, "abort a component if installing or uninstalling it takes longer than this")
+	viper.BindPFlag("componentTimeout", uninstallCmd.PersistentFlags().Lookup("component-timeout"))
+
+	uninstallCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "print the commands that would be run without changing the cluster")
+	viper.BindPFlag("dryRun", uninstallCmd.PersistentFlags().Lookup("dry-run"))
+
+	uninstallCmd.PersistentFlags().StringVar(&uninstallBackupDir, "backup-dir", "", "directory to back up foldy resources to before their CRDs are deleted (default ~/.foldy/backups)")
+	uninstallCmd.PersistentFlags().BoolVar(&noBackup, "no-backup", false, "delete foldy resources along with their CRDs without backing them up")
+
 	uninstallCmd.PersistentFlags().BoolVar(&force, "force", false, "force uninstall without waiting for Argo CD")
 
+	uninstallCmd.PersistentFlags().BoolVar(&purge, "purge", false, "afterwards, delete whatever the components left behind, such as webhooks, cluster roles and stuck namespaces, and print a report")
+
+	addOutputFlag(uninstallCmd)
+
 	rootCmd.AddCommand(uninstallCmd)
 }
 
@@ -28,40 +51,92 @@ var uninstallCmd = &cobra.Command{
 	Use:  "uninstall",
 	Args: cobra.ArbitraryArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		fmt.Println("Uninstalling...")
-		kubeconfig := filepath.Join(homedir.HomeDir(), ".kube", "config")
-		config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+		install, err := newInstaller("")
 		if err != nil {
 			return err
 		}
-		cl, err := client.New(config, client.Options{})
-		if err != nil {
+		defer install.Close()
+		if err := renderProgress(install); err != nil {
 			return err
 		}
-
-		install := installer.NewInstaller(cl)
+		ctx, cancel := commandContext()
+		defer cancel()
 		install.Force = force
+		if !noBackup {
+			install.BackupDir = uninstallBackupDir
+			if install.BackupDir == "" {
+				install.BackupDir = defaultBackupDir()
+			}
+		}
 		if force {
 			log.Printf("--force was specified. Uninstallation will not use Argo CD")
 		}
 
+		var uninstallErr error
 		if len(args) == 0 {
 			log.Printf("Uninstalling everything...")
-			if err := install.UninstallAll(); err != nil {
-				return err
-			}
-			log.Printf("all components were uninstalled successfully")
+			uninstallErr = install.UninstallAll(ctx)
 		} else {
 			log.Printf("Uninstalling %v", args)
-			if err := install.UninstallComponentsByName(args); err != nil {
-				return err
+			uninstallErr = install.UninstallComponentsByName(ctx, args)
+		}
+		if purge {
+			// Leftovers are mostly created by failed uninstallations,
+			// so purge regardless
+			if err := runPurge(ctx, install, args); err != nil && uninstallErr == nil {
+				uninstallErr = err
 			}
-			if len(args) == 1 {
-				log.Printf("component '%s' uninstalled", args[0])
-			} else {
-				log.Printf("components %v uninstalled", args)
+		}
+		if uninstallErr != nil {
+			return uninstallErr
+		}
+		if install.DryRun {
+			if !jsonlOutput() {
+				install.Plan.Print(os.Stdout)
 			}
+			return nil
+		}
+		if len(args) == 0 {
+			log.Printf("all components were uninstalled successfully")
+		} else if len(args) == 1 {
+			log.Printf("component '%s' uninstalled", args[0])
+		} else {
+			log.Printf("components %v uninstalled", args)
 		}
 		return nil
 	},
 }
+
+// runPurge deletes whatever the components, or all of them if
+// names is empty, left behind and prints a report
+func runPurge(ctx context.Context, install *installer.Installer, names []string) error {
+	if len(names) == 0 {
+		// Including those whose uninstallation failed, as
+		// only what foldy is known to have created is deleted
+		g, err := install.Graph()
+		if err != nil {
+			return err
+		}
+		for _, comp := range g.Components() {
+			names = append(names, comp.GetName())
+		}
+	}
+	log.Printf("Purging whatever %v left behind...", names)
+	results, err := install.GarbageCollect(ctx, names)
+	var w io.Writer = os.Stdout
+	if jsonlOutput() {
+		w = os.Stderr
+	}
+	if printErr := installer.PrintCleanUpResults(w, results); printErr != nil && err == nil {
+		err = printErr
+	}
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if !result.Removed() {
+			return fmt.Errorf("some leftovers could not be removed")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/foldy-project/foldy/cli/pkg/installer"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var allowDowngrade bool
+
+func init() {
+	upgradeCmd.PersistentFlags().BoolVar(&allowDowngrade, "allow-downgrade", false, "upgrade components even if the desired version is older than the deployed one")
+
+	upgradeCmd.PersistentFlags().DurationVar(&componentTimeout, "component-timeout", 10*time.Minute, "abort if a component takes longer than this to upgrade and become healthy")
+	viper.BindPFlag("componentTimeout", upgradeCmd.PersistentFlags().Lookup("component-timeout"))
+
+	upgradeCmd.PersistentFlags().DurationVar(&applicationTimeout, "application-timeout", 5*time.Minute, "wait at most this long for each Argo CD Application to become Synced and Healthy (0 to not wait)")
+	viper.BindPFlag("applicationTimeout", upgradeCmd.PersistentFlags().Lookup("application-timeout"))
+
+	upgradeCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "print the commands that would be run without changing the cluster")
+	viper.BindPFlag("dryRun", upgradeCmd.PersistentFlags().Lookup("dry-run"))
+
+	addOutputFlag(upgradeCmd)
+
+	rootCmd.AddCommand(upgradeCmd)
+}
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade [components...]",
+	Short: "Upgrades installed components to their desired versions",
+	Long: `Compares the desired version of each installed component with the deployed one and upgrades those that differ, one at a time in dependency order. Each component has to become healthy before its dependees are upgraded. Components that aren't installed are left alone.
+
+Versions are compared semantically where possible. Downgrades are refused unless --allow-downgrade is given. Revisions that can't be compared, such as HEAD or latest, are upgraded when they change, or when the component was installed by another version of the CLI. Components whose config changed since they were installed are upgraded as well.
+
+  # Show what would be upgraded
+  foldy upgrade --dry-run
+
+  # Roll Argo CD back to an older image set in config.yaml
+  foldy upgrade argocd --allow-downgrade`,
+	Args:         cobra.ArbitraryArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		install, err := newInstallerOrSession()
+		if err != nil {
+			return err
+		}
+		defer install.Close()
+		if err := renderProgress(install); err != nil {
+			return err
+		}
+		ctx, cancel := commandContext()
+		defer cancel()
+		upgrades, err := install.PlanUpgrade(ctx, args)
+		if err != nil {
+			return err
+		}
+		if !jsonlOutput() {
+			if err := installer.PrintUpgradePlan(os.Stdout, upgrades); err != nil {
+				return err
+			}
+		}
+		if _, err := install.Upgrade(ctx, args, allowDowngrade); err != nil {
+			return err
+		}
+		if install.DryRun {
+			if !jsonlOutput() {
+				install.Plan.Print(os.Stdout)
+			}
+			return nil
+		}
+		log.Printf("upgrade complete")
+		return nil
+	},
+}
@@ -0,0 +1,156 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ResourceProblem is a resource of an Application that is
+// out of sync or not healthy
+type ResourceProblem struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Sync      string `json:"sync"`
+	Health    string `json:"health,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+func (p *ResourceProblem) String() string {
+	name := p.Name
+	if p.Namespace != "" {
+		name = p.Namespace + "/" + name
+	}
+	health := p.Health
+	if health == "" {
+		health = "-"
+	}
+	desc := fmt.Sprintf("%s %s: %s/%s", p.Kind, name, p.Sync, health)
+	if p.Message != "" {
+		desc += ": " + p.Message
+	}
+	return desc
+}
+
+// ApplicationError is returned when an Application does not
+// become Synced and Healthy
+type ApplicationError struct {
+	Name      string
+	Sync      string
+	Health    string
+	Reason    string
+	Resources []*ResourceProblem
+}
+
+func (e *ApplicationError) Error() string {
+	msg := fmt.Sprintf("application %s is %s/%s: %s", e.Name, e.Sync, e.Health, e.Reason)
+	for _, resource := range e.Resources {
+		msg += "\n  " + resource.String()
+	}
+	return msg
+}
+
+// applicationReady returns true once the last sync of the
+// Application succeeded and it is Synced and Healthy. A
+// pending operation means the status is from before the sync.
+func applicationReady(app *ArgoCDApplication) bool {
+	status := app.Status
+	return len(app.Operation) == 0 &&
+		status != nil &&
+		status.OperationState != nil &&
+		status.OperationState.Phase == "Succeeded" &&
+		status.Sync.Status == "Synced" &&
+		status.Health.Status == "Healthy"
+}
+
+// newApplicationError describes why the Application isn't
+// ready, listing the resources that are out of sync or not
+// healthy along with the messages Argo CD gave for them
+func newApplicationError(name string, app *ArgoCDApplication, reason string) *ApplicationError {
+	e := &ApplicationError{Name: name, Sync: "Unknown", Health: "Unknown", Reason: reason}
+	if app == nil || app.Status == nil {
+		return e
+	}
+	status := app.Status
+	if status.Sync.Status != "" {
+		e.Sync = status.Sync.Status
+	}
+	if status.Health.Status != "" {
+		e.Health = status.Health.Status
+	}
+	// Sync failures carry more specific messages than health
+	results := make(map[string]string)
+	if op := status.OperationState; op != nil && op.SyncResult != nil {
+		for _, result := range op.SyncResult.Resources {
+			if result.Status != "Synced" && result.Message != "" {
+				results[result.Kind+"/"+result.Namespace+"/"+result.Name] = result.Message
+			}
+		}
+	}
+	for _, resource := range status.Resources {
+		healthy := resource.Health == nil || resource.Health.Status == "Healthy"
+		if resource.Status == "Synced" && healthy {
+			continue
+		}
+		problem := &ResourceProblem{
+			Kind:      resource.Kind,
+			Namespace: resource.Namespace,
+			Name:      resource.Name,
+			Sync:      resource.Status,
+		}
+		var messages []string
+		if message, ok := results[resource.Kind+"/"+resource.Namespace+"/"+resource.Name]; ok {
+			messages = append(messages, message)
+		}
+		if resource.Health != nil {
+			problem.Health = resource.Health.Status
+			if resource.Health.Message != "" {
+				messages = append(messages, resource.Health.Message)
+			}
+		}
+		problem.Message = strings.Join(messages, "; ")
+		e.Resources = append(e.Resources, problem)
+	}
+	return e
+}
+
+// waitForApplication blocks until the Application is Synced
+// and Healthy, for at most ApplicationTimeout. It fails early
+// if the sync itself failed.
+func (s *Installer) waitForApplication(ctx context.Context, name string) error {
+	if s.DryRun || s.ApplicationTimeout <= 0 {
+		return nil
+	}
+	argoCD, err := s.argoCD(ctx)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.ApplicationTimeout)
+	defer cancel()
+	defer s.waiting(ctx, fmt.Sprintf("application %s to become Synced and Healthy", name))()
+	start := time.Now()
+	var app *ArgoCDApplication
+	for {
+		latest, err := argoCD.GetApplication(ctx, name)
+		if err != nil && ctx.Err() == nil {
+			return err
+		} else if err == nil {
+			app = latest
+			if applicationReady(app) {
+				return nil
+			}
+			if app.Status != nil && len(app.Operation) == 0 {
+				if op := app.Status.OperationState; op != nil && (op.Phase == "Failed" || op.Phase == "Error") {
+					return newApplicationError(name, app, fmt.Sprintf("sync %s: %s", strings.ToLower(op.Phase), op.Message))
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return newApplicationError(name, app, fmt.Sprintf("not ready after %v", time.Since(start).Round(time.Second)))
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
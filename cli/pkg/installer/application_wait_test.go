@@ -0,0 +1,96 @@
+package installer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitForApplicationDegraded(t *testing.T) {
+	install, _ := newFakeInstaller(healthyArgoCD("password")...)
+	install.ApplicationTimeout = 10 * time.Millisecond
+	install.ArgoCD.(*FakeArgoCDClient).Outcomes["foo"] = &ArgoCDApplicationStatus{
+		Sync:   ArgoCDSyncStatus{Status: "Synced"},
+		Health: ArgoCDHealthStatus{Status: "Degraded"},
+		Resources: []ArgoCDResourceStatus{{
+			Kind:      "Service",
+			Namespace: "foo",
+			Name:      "foo",
+			Status:    "Synced",
+			Health:    &ArgoCDHealthStatus{Status: "Healthy"},
+		}, {
+			Group:     "apps",
+			Kind:      "Deployment",
+			Namespace: "foo",
+			Name:      "foo",
+			Status:    "Synced",
+			Health: &ArgoCDHealthStatus{
+				Status:  "Degraded",
+				Message: `Deployment "foo" exceeded its progress deadline`,
+			},
+		}},
+		OperationState: &ArgoCDOperationState{Phase: "Succeeded"},
+	}
+	err := install.CreateApplication(context.TODO(), "foo", "https://example.com/foo.git", "charts/foo", "HEAD", nil)
+	require.IsType(t, &ApplicationError{}, err)
+	appErr := err.(*ApplicationError)
+	assert.Equal(t, "Degraded", appErr.Health)
+	assert.Equal(t, []*ResourceProblem{{
+		Kind:      "Deployment",
+		Namespace: "foo",
+		Name:      "foo",
+		Sync:      "Synced",
+		Health:    "Degraded",
+		Message:   `Deployment "foo" exceeded its progress deadline`,
+	}}, appErr.Resources)
+	assert.Contains(t, err.Error(), `Deployment foo/foo: Synced/Degraded: Deployment "foo" exceeded its progress deadline`)
+}
+
+func TestWaitForApplicationSyncFailed(t *testing.T) {
+	install, _ := newFakeInstaller(healthyArgoCD("password")...)
+	install.ArgoCD.(*FakeArgoCDClient).Outcomes["foo"] = &ArgoCDApplicationStatus{
+		Sync:   ArgoCDSyncStatus{Status: "OutOfSync"},
+		Health: ArgoCDHealthStatus{Status: "Missing"},
+		Resources: []ArgoCDResourceStatus{{
+			Kind:      "ConfigMap",
+			Namespace: "foo",
+			Name:      "settings",
+			Status:    "OutOfSync",
+		}},
+		OperationState: &ArgoCDOperationState{
+			Phase:   "Failed",
+			Message: "one or more objects failed to apply",
+			SyncResult: &ArgoCDSyncResult{
+				Resources: []ArgoCDResourceResult{{
+					Kind:      "ConfigMap",
+					Namespace: "foo",
+					Name:      "settings",
+					Status:    "SyncFailed",
+					Message:   "error validating data",
+				}},
+			},
+		},
+	}
+	start := time.Now()
+	err := install.CreateApplication(context.TODO(), "foo", "https://example.com/foo.git", "charts/foo", "HEAD", nil)
+	require.Error(t, err)
+	assert.True(t, time.Since(start) < install.ApplicationTimeout, "a failed sync must not be waited on")
+	assert.Contains(t, err.Error(), "application foo is OutOfSync/Missing: sync failed: one or more objects failed to apply")
+	assert.Contains(t, err.Error(), "ConfigMap foo/settings: OutOfSync/-: error validating data")
+}
+
+func TestApplicationReadyPendingOperation(t *testing.T) {
+	app := &ArgoCDApplication{
+		Status: &ArgoCDApplicationStatus{
+			Sync:           ArgoCDSyncStatus{Status: "Synced"},
+			Health:         ArgoCDHealthStatus{Status: "Healthy"},
+			OperationState: &ArgoCDOperationState{Phase: "Succeeded"},
+		},
+	}
+	assert.True(t, applicationReady(app))
+	app.Operation = []byte(`{"sync":{}}`)
+	assert.False(t, applicationReady(app), "the status predates the pending sync")
+}
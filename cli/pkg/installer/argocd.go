@@ -3,19 +3,25 @@ package installer
 import (
 	"context"
 	"fmt"
-	"log"
-	"os"
+	"net/url"
 	"strings"
-	"time"
 
-	"github.com/hashicorp/go-multierror"
-	"github.com/spf13/viper"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
 
+	"github.com/foldy-project/foldy/cli/pkg/portfwd"
+	"github.com/foldy-project/foldy/cli/pkg/readiness"
+
 	appsv1 "k8s.io/api/apps/v1"
 )
 
+// ArgoCDManifestURL is the upstream manifest used to install Argo CD
+const ArgoCDManifestURL = "https://raw.githubusercontent.com/argoproj/argo-cd/stable/manifests/install.yaml"
+
 func init() {
 	AddComponent(&CustomComponent{
 		Name: "argocd",
@@ -23,21 +29,35 @@ func init() {
 			"applications.argoproj.io",
 			"appprojects.argoproj.io",
 		},
-		Install: func(s *Installer) error {
-			return s.installArgoCD()
+		Namespaces: []string{"argocd"},
+		// Upstream sets no requests, this is what the five
+		// deployments use when idle
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("500m"),
+			corev1.ResourceMemory: resource.MustParse("512Mi"),
+		},
+		Source: func(s *Installer) (string, string) {
+			return s.argoCDManifestURL(), s.argoCDImage()
+		},
+		Images: func(s *Installer) []string {
+			return []string{s.argoCDImage()}
+		},
+		Install: func(ctx context.Context, s *Installer) error {
+			return s.installArgoCD(ctx)
 		},
-		Uninstall: func(s *Installer) error {
+		Uninstall: func(ctx context.Context, s *Installer) error {
 			// Delete argocd namespace (CRD removal happens elsewhere)
-			return s.deleteNamespace("argocd")
+			return s.deleteNamespace(ctx, "argocd")
 		},
 	})
 }
 
 // WaitForArgoCD waits for all the Argo CD deployments to come online
-func (s *Installer) WaitForArgoCD() error {
-	if s.Verbose {
-		defer NewWaitingMessage("Argo CD", s.StatusUpdateInterval).Stop()
+func (s *Installer) WaitForArgoCD(ctx context.Context) error {
+	if s.DryRun {
+		return nil
 	}
+	defer s.waiting(ctx, "Argo CD")()
 	waitForDeployments := []string{
 		"argocd-application-controller",
 		"argocd-dex-server",
@@ -45,41 +65,30 @@ func (s *Installer) WaitForArgoCD() error {
 		"argocd-repo-server",
 		"argocd-server",
 	}
-	dones := make([]chan error, len(waitForDeployments), len(waitForDeployments))
-	for i, deploymentName := range waitForDeployments {
-		done := make(chan error, 1)
-		dones[i] = done
-		go func(deploymentName string, done chan<- error) {
-			defer close(done)
-			done <- WaitForDeployment(
-				s.client,
-				deploymentName,
-				"argocd",
-				5*time.Second,
-				30*time.Second)
-		}(deploymentName, done)
-	}
-	var multi error
-	for _, done := range dones {
-		if err := <-done; err != nil {
-			multi = multierror.Append(multi, err)
-		}
+	waiter, err := s.waiter()
+	if err != nil {
+		return err
 	}
-	return multi
+	var conditions []readiness.Condition
+	for _, name := range waitForDeployments {
+		conditions = append(conditions, readiness.DeploymentAvailable("argocd", name))
+	}
+	return waiter.Wait(ctx, conditions...)
 }
 
-func (s *Installer) installArgoCD() error {
-	if err := s.createNamespace("argocd"); err != nil {
+func (s *Installer) installArgoCD(ctx context.Context) error {
+	if err := s.createNamespace(ctx, "argocd"); err != nil {
 		return err
 	}
 
-	apply := s.RestartArgoCD
+	// Reapplying the manifest upgrades Argo CD to its latest release
+	apply := s.RestartArgoCD || s.Upgrading
 
 	if !apply {
 		// We're not *trying* to restart, but we may have to
 		deployment := &appsv1.Deployment{}
 		if err := s.client.Get(
-			context.TODO(),
+			ctx,
 			types.NamespacedName{
 				Name:      "argocd-server",
 				Namespace: "argocd"},
@@ -94,39 +103,49 @@ func (s *Installer) installArgoCD() error {
 
 	if apply {
 		// Install the yaml
-		if err := s.exec("kubectl apply -n argocd -f https://raw.githubusercontent.com/argoproj/argo-cd/stable/manifests/install.yaml"); err != nil {
+		if err := s.exec(ctx, "kubectl apply -n argocd -f %s", s.argoCDManifest()); err != nil {
+			return err
+		}
+		if err := s.waitForCRDs(ctx, []string{
+			"applications.argoproj.io",
+			"appprojects.argoproj.io",
+		}); err != nil {
 			return err
 		}
 	}
 
-	// Use a newer argocd image that has Helm v3+
-	newImage, ok := viper.Get("argocd.image").(string)
-	if !ok {
-		newImage = "argoproj/argocd:latest"
-	}
+	newImage := s.mirrorImage(s.argoCDImage())
 
 	checkArgoCDImageTag := func(deploymentName string) error {
 		deployment := &appsv1.Deployment{}
 		if err := s.client.Get(
-			context.TODO(),
+			ctx,
 			types.NamespacedName{
 				Name:      deploymentName,
 				Namespace: "argocd",
 			},
 			deployment,
 		); err != nil {
-			return err
+			if !s.DryRun || !errors.IsNotFound(err) {
+				return err
+			}
+			// The planned manifest apply would have created
+			// the deployment with the stock image.
+			return s.exec(ctx, `kubectl patch deployment %s -n argocd --type=json -p='[{"op": "add", "path": "/spec/template/spec/containers/0/image", "value": "%s"}]'`, deploymentName, newImage)
 		}
 		// TODO: configure argocd image in config
 		image := deployment.Spec.Template.Spec.Containers[0].Image
-		if image == "argoproj/argocd:v1.4.2" {
+		if image == "argoproj/argocd:v1.4.2" || (s.Upgrading && image != newImage) {
 			command := fmt.Sprintf(`kubectl patch deployment %s -n argocd --type=json -p='[{"op": "add", "path": "/spec/template/spec/containers/0/image", "value": "%s"}]'`, deploymentName, newImage)
-			if err := s.exec(command); err != nil {
+			if err := s.exec(ctx, command); err != nil {
 				return err
 			}
+			if s.DryRun {
+				return nil
+			}
 			// Verify the result
 			if err := s.client.Get(
-				context.TODO(),
+				ctx,
 				types.NamespacedName{
 					Name:      deploymentName,
 					Namespace: "argocd",
@@ -139,7 +158,7 @@ func (s *Installer) installArgoCD() error {
 				return fmt.Errorf("deployments/%s image change did not take effect", deploymentName)
 			}
 		} else if s.Verbose {
-			log.Printf("deployments/%s image patch already applied", deploymentName)
+			s.logf(ctx, "deployments/%s image patch already applied", deploymentName)
 		}
 		return nil
 	}
@@ -147,13 +166,16 @@ func (s *Installer) installArgoCD() error {
 	isRunningInsecurely := func() (bool, error) {
 		deployment := &appsv1.Deployment{}
 		if err := s.client.Get(
-			context.TODO(),
+			ctx,
 			types.NamespacedName{
 				Name:      "argocd-server",
 				Namespace: "argocd",
 			},
 			deployment,
 		); err != nil {
+			if s.DryRun && errors.IsNotFound(err) {
+				return false, nil
+			}
 			return false, err
 		}
 		command := deployment.Spec.Template.Spec.Containers[0].Command
@@ -167,7 +189,7 @@ func (s *Installer) installArgoCD() error {
 
 	configOK := make(chan error, 1)
 	go func() {
-		configOK <- s.patchArgoCDConfigMap()
+		configOK <- s.patchArgoCDConfigMap(ctx)
 		close(configOK)
 	}()
 
@@ -194,27 +216,31 @@ func (s *Installer) installArgoCD() error {
 		defer close(secretOK)
 		secret := &corev1.Secret{}
 		if err := s.client.Get(
-			context.TODO(),
+			ctx,
 			types.NamespacedName{
 				Name:      "argocd-secret",
 				Namespace: "argocd",
 			},
 			secret,
-		); err != nil {
+		); err != nil && (!s.DryRun || !errors.IsNotFound(err)) {
 			secretOK <- err
 			return
 		}
+		if s.Password == "" {
+			secretOK <- fmt.Errorf("no Argo CD admin password is configured")
+			return
+		}
 		adminPassword, ok := secret.Data["admin.password"]
 		if ok {
 			if ComparePasswordHash(s.Password, adminPassword) {
 				if s.Verbose {
-					log.Printf("Argo CD admin password already matches local config")
+					s.logf(ctx, "Argo CD admin password already matches local config")
 				}
 				secretOK <- nil
 				return
 			}
 			if s.Verbose {
-				log.Printf("Argo CD admin password differs from config. Synchronizing...")
+				s.logf(ctx, "Argo CD admin password differs from config. Synchronizing...")
 			}
 		}
 		hash := HashPassword(s.Password)
@@ -222,38 +248,31 @@ func (s *Installer) installArgoCD() error {
 			secretPatchCommand := fmt.Sprintf(`kubectl -n argocd patch secret argocd-secret -p '{"stringData":{"admin.password": "%s","admin.passwordMtime": "'%s'"}}'`,
 				hash,
 				"$(date +%FT%T%Z)")
-			secretOK <- s.exec(secretPatchCommand)
-			return
-		}
-		if err := os.Setenv("PASSWORD_HASH", hash); err != nil {
-			secretOK <- err
+			secretOK <- s.exec(ctx, secretPatchCommand)
 			return
 		}
 		secretPatchCommand := fmt.Sprintf(`kubectl -n argocd patch secret argocd-secret -p '{"stringData":{"admin.password": "'${PASSWORD_HASH}'","admin.passwordMtime": "'%s'"}}'`,
 			"$(date +%FT%T%Z)")
-		execErr := s.exec(secretPatchCommand)
-		if err := os.Unsetenv("PASSWORD_HASH"); err != nil {
-			secretOK <- err
-			return
-		}
-		secretOK <- execErr
+		secretOK <- s.execEnv(ctx, []string{fmt.Sprintf("PASSWORD_HASH=%s", hash)}, secretPatchCommand)
 	}()
 	if insecure, err := isRunningInsecurely(); err != nil {
 		return err
 	} else if insecure {
 		if s.Verbose {
-			log.Printf("deployments/argocd-server already running with insecure flag")
+			s.logf(ctx, "deployments/argocd-server already running with insecure flag")
 		}
 	} else {
 		// Patch the deployment so it's running in insecure mode.
 		// We'll be using traefik and cert-manager to handle TLS.
-		if err := s.exec(`kubectl patch deployment argocd-server -n argocd --type=json -p='[{"op": "add", "path": "/spec/template/spec/containers/0/command", "value": ["argocd-server", "--staticassets", "/shared/app", "--insecure"]}]'`); err != nil {
+		if err := s.exec(ctx, `kubectl patch deployment argocd-server -n argocd --type=json -p='[{"op": "add", "path": "/spec/template/spec/containers/0/command", "value": ["argocd-server", "--staticassets", "/shared/app", "--insecure"]}]'`); err != nil {
 			return err
 		}
-		if insecure, err := isRunningInsecurely(); err != nil {
-			return err
-		} else if !insecure {
-			return fmt.Errorf("--insecure was not added to the command")
+		if !s.DryRun {
+			if insecure, err := isRunningInsecurely(); err != nil {
+				return err
+			} else if !insecure {
+				return fmt.Errorf("--insecure was not added to the command")
+			}
 		}
 	}
 	if err := <-configOK; err != nil {
@@ -262,7 +281,7 @@ func (s *Installer) installArgoCD() error {
 	if err := <-appControllerOK; err != nil {
 		return err
 	}
-	if err := <-appControllerOK; err != nil {
+	if err := <-serverOK; err != nil {
 		return err
 	}
 	if err := <-repoServerOK; err != nil {
@@ -271,32 +290,35 @@ func (s *Installer) installArgoCD() error {
 	if err := <-secretOK; err != nil {
 		return err
 	}
+	// The remaining images of the manifest, e.g. dex and redis
+	if err := s.mirrorWorkloads(ctx, "argocd"); err != nil {
+		return err
+	}
 
-	return s.WaitForArgoCD()
+	return s.WaitForArgoCD(ctx)
 }
 
-func (s *Installer) RunCommandInArgoCDServer(command string, args ...interface{}) error {
-	if s.argoCDPodName == "" {
-		if err := s.ArgoCDSession(false); err != nil {
-			return err
-		}
+// argoCDImage returns the image Argo CD should be running.
+// The stock image does not ship with Helm v3+, so a newer
+// one is used by default.
+func (s *Installer) argoCDImage() string {
+	if image := EffectiveConfig().ArgoCD.Image; image != "" {
+		return image
 	}
-	interpolated := fmt.Sprintf(command, args...)
-	// TODO: catch errors that could be fixed by calling ArgoCDSession() and retrying
-	return s.exec("kubectl exec -n argocd %s -- %s", s.argoCDPodName, interpolated)
+	return "argoproj/argocd:latest"
 }
 
 // IsArgoCDHealthy checks if argocd-server is up and running
 // without looping.
-func (s *Installer) IsArgoCDHealthy() error {
-	return DeploymentIsHealthy(s.client, "argocd-server", "argocd")
+func (s *Installer) IsArgoCDHealthy(ctx context.Context) error {
+	return DeploymentIsHealthy(ctx, s.client, "argocd-server", "argocd")
 }
 
-func (s *Installer) patchArgoCDConfigMap() error {
+func (s *Installer) patchArgoCDConfigMap(ctx context.Context) error {
 	return nil
 	config := &corev1.ConfigMap{}
 	if err := s.client.Get(
-		context.TODO(),
+		ctx,
 		types.NamespacedName{
 			Name:      "argocd-cm",
 			Namespace: "argocd",
@@ -308,7 +330,7 @@ func (s *Installer) patchArgoCDConfigMap() error {
 	customizations, _ := config.Data["resource.customizations"]
 	if strings.Contains(customizations, "extensions/Ingress") {
 		if s.Verbose {
-			log.Printf("argocd-cm already has ingress health check patch")
+			s.logf(ctx, "argocd-cm already has ingress health check patch")
 		}
 		return nil
 	}
@@ -332,7 +354,7 @@ metadata:
 	if strings.Contains(yaml, "\t") {
 		panic("const string should use spaces instead of tabs")
 	}
-	return s.exec("cat <<EOF | kubectl apply -f -\n%s", yaml)
+	return s.exec(ctx, "cat <<EOF | kubectl apply -f -\n%s", yaml)
 	/*
 
 			customizations += `|
@@ -352,12 +374,162 @@ metadata:
 			newConfig := config.DeepCopy()
 			newConfig.Data["resource.customizations"] = customizations
 			if err := s.client.Update(
-				context.TODO(),
+				ctx,
 				newConfig,
 			); err != nil {
 				return err
 			}
 			return nil
-			//return s.exec(`kubectl patch configmap argocd-cm -n argocd --type=merge -p '{"data":{"resource.customizations":"%s"}}'`, customiziations)
+			//return s.exec(ctx, `kubectl patch configmap argocd-cm -n argocd --type=merge -p '{"data":{"resource.customizations":"%s"}}'`, customiziations)
 	*/
 }
+
+// ArgoCDServiceURL is where argocd-server is reached from
+// within the cluster. The installer runs it with --insecure.
+const ArgoCDServiceURL = "http://argocd-server.argocd.svc.cluster.local"
+
+// argoCDTunnel is a port forward to argocd-server
+type argoCDTunnel struct {
+	url  string
+	stop chan struct{}
+	done <-chan struct{}
+}
+
+// argoCD returns the client for the Argo CD API. If there
+// isn't one, it uses the Session if it is for this cluster
+// and still accepted, or else logs in as admin once Argo CD
+// is up.
+func (s *Installer) argoCD(ctx context.Context) (ArgoCDClient, error) {
+	s.argocdL.Lock()
+	defer s.argocdL.Unlock()
+	if s.ArgoCD == nil && s.Session != nil {
+		argoCD, err := s.sessionArgoCD(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.ArgoCD = argoCD
+	}
+	if s.ArgoCD == nil {
+		if err := s.WaitForArgoCD(ctx); err != nil {
+			return nil, err
+		}
+		s.ArgoCD = NewArgoCDAPIClient(s.argoCDEndpoint, "admin", s.Password)
+	}
+	return s.ArgoCD, nil
+}
+
+// sessionArgoCD returns a client using the Session's token,
+// or nil if the session's endpoint isn't routed to this
+// cluster's argocd-server or its token is rejected
+func (s *Installer) sessionArgoCD(ctx context.Context) (ArgoCDClient, error) {
+	ok, err := s.sessionForCluster(ctx)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		if s.Verbose {
+			s.logf(ctx, "Not using the session for %s, which is not exposed by this cluster", s.Session.Endpoint)
+		}
+		return nil, nil
+	}
+	argoCD := NewArgoCDTokenClient(s.Session.Endpoint, s.Session.Token)
+	if _, err := argoCD.GetApplication(ctx, "foldy"); err != nil && err != ErrApplicationNotFound {
+		s.logf(ctx, "Warning: session for %s not usable, logging in as admin instead: %v", s.Session.Endpoint, err)
+		return nil, nil
+	}
+	return argoCD, nil
+}
+
+// sessionForCluster returns true if the Session's endpoint is
+// a host that this cluster routes to argocd-server, i.e. one
+// configured with ingress.argocd. A session for any other
+// endpoint may belong to a different cluster.
+func (s *Installer) sessionForCluster(ctx context.Context) (bool, error) {
+	u, err := url.Parse(s.Session.Endpoint)
+	if err != nil {
+		return false, err
+	}
+	// The http route exists whether or not TLS is enabled
+	expected := ingressObjects("argocd", u.Hostname(), true, false)[0]
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(expected.GroupVersionKind())
+	if err := s.client.Get(ctx, types.NamespacedName{Name: expected.GetName(), Namespace: expected.GetNamespace()}, route); err != nil {
+		if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "routes")
+	for _, rule := range rules {
+		if rule, ok := rule.(map[string]interface{}); ok {
+			if strings.HasPrefix(fmt.Sprint(rule["match"]), fmt.Sprintf("Host(`%s`)", u.Hostname())) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// argoCDEndpoint returns the URL of argocd-server, which is
+// the service when installing to the cluster the CLI runs in
+// and otherwise a port forward. The port forward is reopened if it stopped,
+// e.g. because the pod it was bound to was replaced.
+func (s *Installer) argoCDEndpoint(ctx context.Context) (string, error) {
+	if s.InCluster {
+		return ArgoCDServiceURL, nil
+	}
+	s.tunnelL.Lock()
+	defer s.tunnelL.Unlock()
+	if s.tunnel != nil {
+		select {
+		case <-s.tunnel.done:
+			s.tunnel = nil
+		default:
+			return s.tunnel.url, nil
+		}
+	}
+	if s.RestConfig == nil {
+		return "", fmt.Errorf("unable to reach argocd-server: no Kubernetes config to port-forward with")
+	}
+	stop := make(chan struct{})
+	port, done, err := portfwd.Forward(s.RestConfig, "argocd-server", "argocd", 80, stop)
+	if err != nil {
+		return "", fmt.Errorf("port-forward to argocd-server: %v", err)
+	}
+	s.announce(ctx, fmt.Sprintf("kubectl port-forward -n argocd svc/argocd-server %d:80", port))
+	s.tunnel = &argoCDTunnel{
+		url:  fmt.Sprintf("http://localhost:%d", port),
+		stop: stop,
+		done: done,
+	}
+	return s.tunnel.url, nil
+}
+
+// waiter returns the waiter for resources in the cluster,
+// creating one from RestConfig if there isn't one
+func (s *Installer) waiter() (*readiness.Waiter, error) {
+	s.readinessL.Lock()
+	defer s.readinessL.Unlock()
+	if s.Readiness == nil {
+		if s.RestConfig == nil {
+			return nil, fmt.Errorf("unable to watch resources: no Kubernetes config")
+		}
+		waiter, err := readiness.NewWaiterForConfig(s.RestConfig)
+		if err != nil {
+			return nil, err
+		}
+		s.Readiness = waiter
+	}
+	return s.Readiness, nil
+}
+
+// Close stops the port forward to argocd-server, if any, and
+// removes temporary files
+func (s *Installer) Close() {
+	s.removeKubeconfig()
+	s.tunnelL.Lock()
+	defer s.tunnelL.Unlock()
+	if s.tunnel != nil {
+		close(s.tunnel.stop)
+		s.tunnel = nil
+	}
+}
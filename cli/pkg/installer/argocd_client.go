@@ -0,0 +1,407 @@
+package installer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ArgoCDApplication is the subset of an Argo CD Application
+// that the installer manages
+type ArgoCDApplication struct {
+	Metadata  metav1.ObjectMeta        `json:"metadata"`
+	Spec      ArgoCDApplicationSpec    `json:"spec"`
+	Status    *ArgoCDApplicationStatus `json:"status,omitempty"`
+	Operation json.RawMessage          `json:"operation,omitempty"` // Set while an operation is pending
+}
+
+type ArgoCDApplicationSpec struct {
+	Source      ArgoCDApplicationSource      `json:"source"`
+	Destination ArgoCDApplicationDestination `json:"destination"`
+	Project     string                       `json:"project"`
+}
+
+type ArgoCDApplicationSource struct {
+	RepoURL        string            `json:"repoURL"`
+	Path           string            `json:"path,omitempty"`
+	TargetRevision string            `json:"targetRevision,omitempty"`
+	Helm           *ArgoCDHelmSource `json:"helm,omitempty"`
+}
+
+type ArgoCDHelmSource struct {
+	Parameters []ArgoCDHelmParameter `json:"parameters,omitempty"`
+}
+
+type ArgoCDHelmParameter struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type ArgoCDApplicationDestination struct {
+	Server    string `json:"server"`
+	Namespace string `json:"namespace"`
+}
+
+type ArgoCDApplicationStatus struct {
+	Sync           ArgoCDSyncStatus       `json:"sync"`
+	Health         ArgoCDHealthStatus     `json:"health"`
+	Resources      []ArgoCDResourceStatus `json:"resources,omitempty"`
+	OperationState *ArgoCDOperationState  `json:"operationState,omitempty"`
+}
+
+type ArgoCDSyncStatus struct {
+	Status   string `json:"status"`
+	Revision string `json:"revision,omitempty"`
+}
+
+type ArgoCDHealthStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// ArgoCDResourceStatus is the state of a resource managed by
+// an Application
+type ArgoCDResourceStatus struct {
+	Group     string              `json:"group,omitempty"`
+	Kind      string              `json:"kind"`
+	Namespace string              `json:"namespace,omitempty"`
+	Name      string              `json:"name"`
+	Status    string              `json:"status"` // Sync status
+	Health    *ArgoCDHealthStatus `json:"health,omitempty"`
+}
+
+// ArgoCDOperationState is the state of the last sync
+type ArgoCDOperationState struct {
+	Phase      string            `json:"phase"` // Running, Succeeded, Failed, Error or Terminating
+	Message    string            `json:"message,omitempty"`
+	SyncResult *ArgoCDSyncResult `json:"syncResult,omitempty"`
+}
+
+type ArgoCDSyncResult struct {
+	Resources []ArgoCDResourceResult `json:"resources,omitempty"`
+}
+
+// ArgoCDResourceResult is the outcome of syncing a resource
+type ArgoCDResourceResult struct {
+	Group     string `json:"group,omitempty"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Status    string `json:"status"` // Synced, SyncFailed, Pruned or PruneSkipped
+	Message   string `json:"message,omitempty"`
+}
+
+// ArgoCDClient manages Argo CD Applications
+type ArgoCDClient interface {
+	// CreateApplication creates the application. If upsert is
+	// true, the spec of an existing application is replaced.
+	CreateApplication(ctx context.Context, app *ArgoCDApplication, upsert bool) error
+
+	// SyncApplication starts syncing the application
+	SyncApplication(ctx context.Context, name string) error
+
+	// GetApplication returns the application along with its
+	// status. ErrApplicationNotFound is returned if it doesn't
+	// exist.
+	GetApplication(ctx context.Context, name string) (*ArgoCDApplication, error)
+
+	// DeleteApplication deletes the application, along with its
+	// resources if cascade is true. ErrApplicationNotFound is
+	// returned if it doesn't exist.
+	DeleteApplication(ctx context.Context, name string, cascade bool) error
+}
+
+var ErrApplicationNotFound = fmt.Errorf("application not found")
+
+// ArgoCDAPIError is an unsuccessful response of the Argo CD API
+type ArgoCDAPIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ArgoCDAPIError) Error() string {
+	return fmt.Sprintf("argocd api: %s (%d)", e.Message, e.StatusCode)
+}
+
+// ErrSessionExpired is returned when the token of a client
+// without a password is no longer accepted
+var ErrSessionExpired = fmt.Errorf("session expired, log in again with 'foldy login'")
+
+// ArgoCDAPIClient talks to the REST API of argocd-server. It
+// logs in on first use and again whenever the session token
+// is rejected, e.g. after the server restarted.
+type ArgoCDAPIClient struct {
+	// Endpoint returns the base URL of argocd-server. It is
+	// called for every request, so that it may reconnect.
+	Endpoint   func(ctx context.Context) (string, error)
+	Username   string
+	Password   string
+	HTTPClient *http.Client
+	token      string
+	l          sync.Mutex
+}
+
+func NewArgoCDAPIClient(
+	endpoint func(ctx context.Context) (string, error),
+	username string,
+	password string,
+) *ArgoCDAPIClient {
+	return &ArgoCDAPIClient{
+		Endpoint:   endpoint,
+		Username:   username,
+		Password:   password,
+		HTTPClient: &http.Client{},
+	}
+}
+
+// NewArgoCDTokenClient returns a client that uses a token
+// obtained with Login, e.g. by 'foldy login'. Requests fail
+// with ErrSessionExpired once the token is rejected.
+func NewArgoCDTokenClient(endpoint string, token string) *ArgoCDAPIClient {
+	return &ArgoCDAPIClient{
+		Endpoint: func(ctx context.Context) (string, error) {
+			return endpoint, nil
+		},
+		HTTPClient: &http.Client{},
+		token:      token,
+	}
+}
+
+// Login exchanges the username and password for a session
+// token without keeping it
+func (c *ArgoCDAPIClient) Login(ctx context.Context) (string, error) {
+	return c.login(ctx)
+}
+
+// Logout revokes the client's session token, if it has one
+func (c *ArgoCDAPIClient) Logout(ctx context.Context) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.token == "" {
+		return nil
+	}
+	err := c.send(ctx, http.MethodDelete, "/api/v1/session", c.token, nil, nil)
+	c.token = ""
+	return err
+}
+
+// login exchanges the credentials for a session token
+func (c *ArgoCDAPIClient) login(ctx context.Context) (string, error) {
+	var session struct {
+		Token string `json:"token"`
+	}
+	if err := c.send(ctx, http.MethodPost, "/api/v1/session", "", map[string]string{
+		"username": c.Username,
+		"password": c.Password,
+	}, &session); err != nil {
+		return "", fmt.Errorf("login: %v", err)
+	}
+	return session.Token, nil
+}
+
+// do sends an authenticated request, logging in again once
+// if the token is missing or no longer accepted
+func (c *ArgoCDAPIClient) do(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+	for attempt := 0; ; attempt++ {
+		if c.token == "" && c.Password == "" {
+			return ErrSessionExpired
+		} else if c.token == "" {
+			token, err := c.login(ctx)
+			if err != nil {
+				return err
+			}
+			c.token = token
+		}
+		err := c.send(ctx, method, path, c.token, body, out)
+		if apiErr, ok := err.(*ArgoCDAPIError); ok && apiErr.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			c.token = ""
+			if c.Password == "" {
+				return ErrSessionExpired
+			}
+			continue
+		}
+		return err
+	}
+}
+
+func (c *ArgoCDAPIClient) send(ctx context.Context, method string, path string, token string, body interface{}, out interface{}) error {
+	endpoint, err := c.Endpoint(ctx)
+	if err != nil {
+		return err
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, endpoint+path, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &ArgoCDAPIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		var status struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &status) == nil {
+			if status.Message != "" {
+				apiErr.Message = status.Message
+			} else if status.Error != "" {
+				apiErr.Message = status.Error
+			}
+		}
+		return apiErr
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+func (c *ArgoCDAPIClient) CreateApplication(ctx context.Context, app *ArgoCDApplication, upsert bool) error {
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("/api/v1/applications?upsert=%v", upsert), app, nil)
+}
+
+func (c *ArgoCDAPIClient) SyncApplication(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("/api/v1/applications/%s/sync", url.PathEscape(name)), map[string]string{
+		"name": name,
+	}, nil)
+}
+
+func (c *ArgoCDAPIClient) GetApplication(ctx context.Context, name string) (*ArgoCDApplication, error) {
+	app := &ArgoCDApplication{}
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/v1/applications/%s", url.PathEscape(name)), nil, app)
+	if apiErr, ok := err.(*ArgoCDAPIError); ok && apiErr.StatusCode == http.StatusNotFound {
+		return nil, ErrApplicationNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+func (c *ArgoCDAPIClient) DeleteApplication(ctx context.Context, name string, cascade bool) error {
+	err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/api/v1/applications/%s?cascade=%v", url.PathEscape(name), cascade), nil, nil)
+	if apiErr, ok := err.(*ArgoCDAPIError); ok && apiErr.StatusCode == http.StatusNotFound {
+		return ErrApplicationNotFound
+	}
+	return err
+}
+
+// FakeArgoCDClient records applications instead of creating
+// them in Argo CD. Synced applications become Synced and
+// Healthy unless a different outcome is scripted in Outcomes.
+type FakeArgoCDClient struct {
+	Applications map[string]*ArgoCDApplication
+	Synced       map[string]int // Number of syncs by application
+	Errors       map[string]error
+	Outcomes     map[string]*ArgoCDApplicationStatus
+	l            sync.Mutex
+}
+
+func NewFakeArgoCDClient() *FakeArgoCDClient {
+	return &FakeArgoCDClient{
+		Applications: make(map[string]*ArgoCDApplication),
+		Synced:       make(map[string]int),
+		Errors:       make(map[string]error),
+		Outcomes:     make(map[string]*ArgoCDApplicationStatus),
+	}
+}
+
+// Fail scripts the error returned for the operation (create,
+// sync, get or delete) on the named application
+func (f *FakeArgoCDClient) Fail(operation string, name string, err error) *FakeArgoCDClient {
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.Errors[operation+"/"+name] = err
+	return f
+}
+
+func (f *FakeArgoCDClient) CreateApplication(ctx context.Context, app *ArgoCDApplication, upsert bool) error {
+	f.l.Lock()
+	defer f.l.Unlock()
+	if err := f.Errors["create/"+app.Metadata.Name]; err != nil {
+		return err
+	}
+	if _, ok := f.Applications[app.Metadata.Name]; ok && !upsert {
+		return nil
+	}
+	f.Applications[app.Metadata.Name] = app
+	return nil
+}
+
+func (f *FakeArgoCDClient) SyncApplication(ctx context.Context, name string) error {
+	f.l.Lock()
+	defer f.l.Unlock()
+	if err := f.Errors["sync/"+name]; err != nil {
+		return err
+	}
+	app, ok := f.Applications[name]
+	if !ok {
+		return ErrApplicationNotFound
+	}
+	f.Synced[name]++
+	if outcome, ok := f.Outcomes[name]; ok {
+		app.Status = outcome
+	} else {
+		app.Status = &ArgoCDApplicationStatus{
+			Sync:           ArgoCDSyncStatus{Status: "Synced"},
+			Health:         ArgoCDHealthStatus{Status: "Healthy"},
+			OperationState: &ArgoCDOperationState{Phase: "Succeeded"},
+		}
+	}
+	return nil
+}
+
+func (f *FakeArgoCDClient) GetApplication(ctx context.Context, name string) (*ArgoCDApplication, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	if err := f.Errors["get/"+name]; err != nil {
+		return nil, err
+	}
+	app, ok := f.Applications[name]
+	if !ok {
+		return nil, ErrApplicationNotFound
+	}
+	return app, nil
+}
+
+func (f *FakeArgoCDClient) DeleteApplication(ctx context.Context, name string, cascade bool) error {
+	f.l.Lock()
+	defer f.l.Unlock()
+	if err := f.Errors["delete/"+name]; err != nil {
+		return err
+	}
+	if _, ok := f.Applications[name]; !ok {
+		return ErrApplicationNotFound
+	}
+	delete(f.Applications, name)
+	return nil
+}
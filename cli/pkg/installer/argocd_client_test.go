@@ -0,0 +1,152 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeArgoCDServer mimics the parts of the Argo CD API the
+// client uses. Every login issues a new token, and expire
+// invalidates the current one.
+type fakeArgoCDServer struct {
+	logins   int
+	token    string
+	apps     map[string]*ArgoCDApplication
+	requests []string
+	l        sync.Mutex
+}
+
+func (f *fakeArgoCDServer) expire() {
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.token = ""
+}
+
+func (f *fakeArgoCDServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.RequestURI())
+	fail := func(status int, message string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": message, "message": message})
+	}
+	if r.URL.Path == "/api/v1/session" && r.Method == http.MethodDelete {
+		if f.token == "" || r.Header.Get("Authorization") != "Bearer "+f.token {
+			fail(http.StatusUnauthorized, "invalid session")
+			return
+		}
+		f.token = ""
+		w.Write([]byte("{}"))
+		return
+	}
+	if r.URL.Path == "/api/v1/session" {
+		var creds map[string]string
+		json.NewDecoder(r.Body).Decode(&creds)
+		if creds["username"] != "admin" || creds["password"] != "password" {
+			fail(http.StatusUnauthorized, "invalid username or password")
+			return
+		}
+		f.logins++
+		f.token = fmt.Sprintf("token-%d", f.logins)
+		json.NewEncoder(w).Encode(map[string]string{"token": f.token})
+		return
+	}
+	if f.token == "" || r.Header.Get("Authorization") != "Bearer "+f.token {
+		fail(http.StatusUnauthorized, "invalid session")
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/applications":
+		app := &ArgoCDApplication{}
+		json.NewDecoder(r.Body).Decode(app)
+		f.apps[app.Metadata.Name] = app
+		json.NewEncoder(w).Encode(app)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/v1/applications/"):
+		app, ok := f.apps[strings.TrimPrefix(r.URL.Path, "/api/v1/applications/")]
+		if !ok {
+			fail(http.StatusNotFound, "application not found")
+			return
+		}
+		json.NewEncoder(w).Encode(app)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/applications/foo/sync":
+		json.NewEncoder(w).Encode(f.apps["foo"])
+	case r.Method == http.MethodDelete && r.URL.Path == "/api/v1/applications/foo":
+		if _, ok := f.apps["foo"]; !ok {
+			fail(http.StatusNotFound, "applications.argoproj.io \"foo\" not found")
+			return
+		}
+		delete(f.apps, "foo")
+		w.Write([]byte("{}"))
+	default:
+		fail(http.StatusNotImplemented, "not implemented")
+	}
+}
+
+func newTestArgoCDClient(password string) (*ArgoCDAPIClient, *fakeArgoCDServer, func()) {
+	server := &fakeArgoCDServer{apps: make(map[string]*ArgoCDApplication)}
+	httpServer := httptest.NewServer(server)
+	return NewArgoCDAPIClient(func(ctx context.Context) (string, error) {
+		return httpServer.URL, nil
+	}, "admin", password), server, httpServer.Close
+}
+
+func TestArgoCDAPIClient(t *testing.T) {
+	argoCD, server, stop := newTestArgoCDClient("password")
+	defer stop()
+	app := &ArgoCDApplication{Spec: ArgoCDApplicationSpec{Project: "default"}}
+	app.Metadata.Name = "foo"
+	require.NoError(t, argoCD.CreateApplication(context.TODO(), app, true))
+	require.NoError(t, argoCD.SyncApplication(context.TODO(), "foo"))
+	require.Contains(t, server.apps, "foo")
+	assert.Equal(t, "default", server.apps["foo"].Spec.Project)
+	assert.Equal(t, []string{
+		"POST /api/v1/session",
+		"POST /api/v1/applications?upsert=true",
+		"POST /api/v1/applications/foo/sync",
+	}, server.requests, "the session must be reused")
+	require.NoError(t, argoCD.DeleteApplication(context.TODO(), "foo", true))
+	assert.Equal(t, ErrApplicationNotFound, argoCD.DeleteApplication(context.TODO(), "foo", true))
+}
+
+func TestArgoCDAPIClientReauthenticates(t *testing.T) {
+	argoCD, server, stop := newTestArgoCDClient("password")
+	defer stop()
+	app := &ArgoCDApplication{}
+	app.Metadata.Name = "foo"
+	require.NoError(t, argoCD.CreateApplication(context.TODO(), app, false))
+	server.expire()
+	require.NoError(t, argoCD.SyncApplication(context.TODO(), "foo"))
+	assert.Equal(t, 2, server.logins)
+}
+
+func TestArgoCDAPIClientBadPassword(t *testing.T) {
+	argoCD, server, stop := newTestArgoCDClient("wrong")
+	defer stop()
+	err := argoCD.SyncApplication(context.TODO(), "foo")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid username or password")
+	assert.Equal(t, 0, server.logins)
+}
+
+func TestArgoCDEndpoint(t *testing.T) {
+	install, _ := newFakeInstaller()
+	install.InCluster = true
+	endpoint, err := install.argoCDEndpoint(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, ArgoCDServiceURL, endpoint)
+
+	// Targeting another cluster from a pod must port-forward,
+	// whatever the environment looks like
+	install.InCluster = false
+	_, err = install.argoCDEndpoint(context.TODO())
+	assert.Error(t, err)
+}
@@ -0,0 +1,434 @@
+package installer
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+// BackupResources are the custom resources of foldy, which
+// are lost when their CRDs are deleted
+var BackupResources = []schema.GroupVersionResource{
+	{Group: "app.foldy.dev", Version: "v1alpha1", Resource: "backends"},
+	{Group: "app.foldy.dev", Version: "v1alpha1", Resource: "datasets"},
+	{Group: "app.foldy.dev", Version: "v1alpha1", Resource: "experiments"},
+	{Group: "app.foldy.dev", Version: "v1alpha1", Resource: "models"},
+	{Group: "app.foldy.dev", Version: "v1alpha1", Resource: "transforms"},
+}
+
+// backupManifestName is the first file of a backup
+const backupManifestName = "backup.json"
+
+// BackupManifest describes the contents of a backup
+type BackupManifest struct {
+	CreatedAt  time.Time      `json:"createdAt"`
+	CLIVersion string         `json:"cliVersion"`
+	Resources  map[string]int `json:"resources"` // Number of objects by resource, e.g. experiments.app.foldy.dev
+}
+
+// Total returns the number of objects in the backup
+func (m *BackupManifest) Total() int {
+	total := 0
+	for _, count := range m.Resources {
+		total += count
+	}
+	return total
+}
+
+// Conflict policies of Restore for objects that already exist
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictFail      = "fail"
+)
+
+// RestoreOptions control how a backup is restored
+type RestoreOptions struct {
+	Namespaces map[string]string // Objects are restored to the mapped namespace instead, if there is one
+	Conflict   string            // ConflictSkip, ConflictOverwrite or ConflictFail
+}
+
+// Actions taken by Restore for each object
+const (
+	RestoreCreated     = "created"
+	RestoreOverwritten = "overwritten"
+	RestoreSkipped     = "skipped"
+	RestoreFailed      = "failed"
+)
+
+// RestoreResult is what Restore did with one object
+type RestoreResult struct {
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Action    string `json:"action"`
+	Error     string `json:"error,omitempty"`
+}
+
+// dynamic returns the client used for custom resources,
+// creating one from RestConfig if there isn't one
+func (s *Installer) dynamic() (dynamic.Interface, error) {
+	s.dynamicL.Lock()
+	defer s.dynamicL.Unlock()
+	if s.Dynamic == nil {
+		if s.RestConfig == nil {
+			return nil, fmt.Errorf("unable to access custom resources: no Kubernetes config")
+		}
+		client, err := dynamic.NewForConfig(s.RestConfig)
+		if err != nil {
+			return nil, err
+		}
+		s.Dynamic = client
+	}
+	return s.Dynamic, nil
+}
+
+// resourceName returns the name of the CRD for the resource
+func resourceName(gvr schema.GroupVersionResource) string {
+	return gvr.Resource + "." + gvr.Group
+}
+
+// backupEntry returns the path of an object in a backup
+func backupEntry(gvr schema.GroupVersionResource, namespace string, name string) string {
+	return path.Join("resources", resourceName(gvr), namespace, name+".yaml")
+}
+
+// cleanForBackup removes the fields of an object that the API
+// server sets, so that it can be created again. Owners are
+// dropped because they won't exist with the same UID after a
+// reinstall, and the garbage collector would delete the object.
+func cleanForBackup(obj *unstructured.Unstructured) {
+	for _, field := range []string{
+		"uid",
+		"resourceVersion",
+		"selfLink",
+		"creationTimestamp",
+		"deletionTimestamp",
+		"deletionGracePeriodSeconds",
+		"generation",
+		"managedFields",
+		"ownerReferences",
+	} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+}
+
+// Backup writes every foldy custom resource, across all
+// namespaces and including status, to w as a gzipped tarball.
+// Resources whose CRD isn't installed are left out.
+func (s *Installer) Backup(ctx context.Context, w io.Writer) (*BackupManifest, error) {
+	client, err := s.dynamic()
+	if err != nil {
+		return nil, err
+	}
+	manifest := &BackupManifest{
+		CreatedAt:  time.Now().UTC(),
+		CLIVersion: s.Version,
+		Resources:  make(map[string]int),
+	}
+	var objects []*unstructured.Unstructured
+	var entries []string
+	for _, gvr := range BackupResources {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var cont string
+		for {
+			list, err := client.Resource(gvr).Namespace(metav1.NamespaceAll).List(metav1.ListOptions{
+				Limit:    500,
+				Continue: cont,
+			})
+			if errors.IsNotFound(err) {
+				// CRD isn't installed
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("list %s: %v", resourceName(gvr), err)
+			}
+			for i := range list.Items {
+				obj := &list.Items[i]
+				cleanForBackup(obj)
+				objects = append(objects, obj)
+				entries = append(entries, backupEntry(gvr, obj.GetNamespace(), obj.GetName()))
+				manifest.Resources[resourceName(gvr)]++
+			}
+			if cont = list.GetContinue(); cont == "" {
+				break
+			}
+		}
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	write := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0600,
+			Size:    int64(len(data)),
+			ModTime: manifest.CreatedAt,
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := write(backupManifestName, data); err != nil {
+		return nil, err
+	}
+	for i, obj := range objects {
+		data, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return nil, err
+		}
+		if err := write(entries[i], data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// BackupToFile writes a backup to the file, which is only
+// readable by the current user. A partial file is removed.
+func (s *Installer) BackupToFile(ctx context.Context, file string) (*BackupManifest, error) {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := s.Backup(ctx, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// backupBeforeUninstall backs up the foldy custom resources
+// to BackupDir before the component deletes their CRDs. This
+// happens at most once per installer.
+func (s *Installer) backupBeforeUninstall(ctx context.Context, comp Component) error {
+	if s.BackupDir == "" {
+		return nil
+	}
+	removesData := false
+	for _, crd := range comp.GetCRDs() {
+		for _, gvr := range BackupResources {
+			if crd == resourceName(gvr) {
+				removesData = true
+			}
+		}
+	}
+	if !removesData {
+		return nil
+	}
+	s.backupL.Lock()
+	defer s.backupL.Unlock()
+	if s.backedUp {
+		return nil
+	}
+	file := filepath.Join(s.BackupDir, fmt.Sprintf("foldy-backup-%s.tar.gz", time.Now().UTC().Format("20060102-150405")))
+	if err := s.mutate(ctx, func() error {
+		if err := os.MkdirAll(s.BackupDir, 0700); err != nil {
+			return err
+		}
+		manifest, err := s.BackupToFile(ctx, file)
+		if err != nil {
+			return fmt.Errorf("backup before deleting CRDs (uninstall with --no-backup to skip): %v", err)
+		}
+		if manifest.Total() == 0 {
+			os.Remove(file)
+			s.logf(ctx, "No foldy resources to back up")
+			return nil
+		}
+		s.logf(ctx, "Backed up %d foldy resources to %s, restore them with 'foldy restore %s'", manifest.Total(), file, file)
+		return nil
+	}, "foldy backup -o %s", file); err != nil {
+		return err
+	}
+	s.backedUp = true
+	return nil
+}
+
+// Restore creates the objects of a backup created by Backup.
+// Missing namespaces are created. Objects that already exist
+// are handled according to the conflict policy. The status
+// of each object is restored along with it.
+func (s *Installer) Restore(ctx context.Context, r io.Reader, opts *RestoreOptions) ([]*RestoreResult, error) {
+	switch opts.Conflict {
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+	default:
+		return nil, fmt.Errorf("unknown conflict policy '%s' (expected %s, %s or %s)", opts.Conflict, ConflictSkip, ConflictOverwrite, ConflictFail)
+	}
+	client, err := s.dynamic()
+	if err != nil {
+		return nil, err
+	}
+	resources := make(map[string]schema.GroupVersionResource)
+	for _, gvr := range BackupResources {
+		resources[resourceName(gvr)] = gvr
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup: %v", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	var results []*RestoreResult
+	namespaces := make(map[string]bool)
+	sawManifest := false
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return results, err
+		}
+		if header.Name == backupManifestName {
+			sawManifest = true
+			continue
+		}
+		// resources/<resource>/<namespace>/<name>.yaml
+		parts := strings.Split(header.Name, "/")
+		if len(parts) != 4 || parts[0] != "resources" {
+			continue
+		}
+		gvr, ok := resources[parts[1]]
+		if !ok {
+			return results, fmt.Errorf("%s: unknown resource '%s'", header.Name, parts[1])
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return results, err
+		}
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(data, &obj.Object); err != nil {
+			return results, fmt.Errorf("%s: %v", header.Name, err)
+		}
+		namespace := obj.GetNamespace()
+		if mapped, ok := opts.Namespaces[namespace]; ok {
+			namespace = mapped
+			obj.SetNamespace(namespace)
+		}
+		if namespace != "" && !namespaces[namespace] {
+			if exists, err := NamespaceExists(ctx, s.client, namespace); err != nil {
+				return results, err
+			} else if !exists {
+				if err := s.createNamespace(ctx, namespace); err != nil {
+					return results, err
+				}
+			}
+			namespaces[namespace] = true
+		}
+		result := &RestoreResult{
+			Resource:  parts[1],
+			Namespace: namespace,
+			Name:      obj.GetName(),
+		}
+		results = append(results, result)
+		result.Action, err = s.restoreObject(ctx, client.Resource(gvr).Namespace(namespace), obj, header.Name, opts.Conflict)
+		if err != nil {
+			result.Action = RestoreFailed
+			result.Error = err.Error()
+			if opts.Conflict == ConflictFail {
+				return results, fmt.Errorf("%s %s/%s: %v", result.Resource, namespace, result.Name, err)
+			}
+		}
+	}
+	if !sawManifest {
+		return results, fmt.Errorf("not a backup: %s is missing", backupManifestName)
+	}
+	return results, nil
+}
+
+// restoreObject creates the object, or replaces an existing
+// one if the conflict policy allows it, followed by its status
+func (s *Installer) restoreObject(
+	ctx context.Context,
+	client dynamic.ResourceInterface,
+	obj *unstructured.Unstructured,
+	entry string,
+	conflict string,
+) (string, error) {
+	status, hasStatus := obj.Object["status"]
+	existing, err := client.Get(obj.GetName(), metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
+	var action string
+	var command string
+	if errors.IsNotFound(err) {
+		action = RestoreCreated
+		command = fmt.Sprintf("kubectl create -n %s -f %s", obj.GetNamespace(), entry)
+	} else if conflict == ConflictOverwrite {
+		action = RestoreOverwritten
+		command = fmt.Sprintf("kubectl replace -n %s -f %s", obj.GetNamespace(), entry)
+		obj.SetResourceVersion(existing.GetResourceVersion())
+	} else if conflict == ConflictSkip {
+		return RestoreSkipped, nil
+	} else {
+		return "", fmt.Errorf("already exists")
+	}
+	err = s.mutate(ctx, func() error {
+		var restored *unstructured.Unstructured
+		var err error
+		if action == RestoreCreated {
+			restored, err = client.Create(obj, metav1.CreateOptions{})
+		} else {
+			restored, err = client.Update(obj, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return err
+		}
+		if !hasStatus {
+			return nil
+		}
+		// The status subresource ignores status on create
+		restored.Object["status"] = status
+		if _, err := client.UpdateStatus(restored, metav1.UpdateOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("restore status: %v", err)
+		}
+		return nil
+	}, "%s", command)
+	return action, err
+}
+
+// PrintRestoreResults writes a table with a row per object
+func PrintRestoreResults(w io.Writer, results []*RestoreResult) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RESOURCE\tNAMESPACE\tNAME\tACTION\tERROR")
+	for _, result := range results {
+		message := "-"
+		if result.Error != "" {
+			message = result.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", result.Resource, result.Namespace, result.Name, result.Action, message)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,129 @@
+package installer
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var experimentsResource = BackupResources[2]
+
+func newExperiment(namespace string, name string, phase string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "app.foldy.dev/v1alpha1",
+		"kind":       "Experiment",
+		"metadata": map[string]interface{}{
+			"namespace":       namespace,
+			"name":            name,
+			"uid":             "3a1c7c2e-0000-0000-0000-000000000000",
+			"resourceVersion": "42",
+			"ownerReferences": []interface{}{map[string]interface{}{
+				"apiVersion": "app.foldy.dev/v1alpha1",
+				"kind":       "Model",
+				"name":       "owner",
+				"uid":        "3a1c7c2e-0000-0000-0000-000000000001",
+			}},
+		},
+		"spec":   map[string]interface{}{"model": "owner"},
+		"status": map[string]interface{}{"phase": phase},
+	}}
+	return obj
+}
+
+func backupExperiments(t *testing.T, objs ...runtime.Object) []byte {
+	install, _ := newFakeInstaller(objs...)
+	buf := &bytes.Buffer{}
+	manifest, err := install.Backup(context.TODO(), buf)
+	require.NoError(t, err)
+	assert.Equal(t, len(objs), manifest.Total())
+	return buf.Bytes()
+}
+
+func TestBackupRestore(t *testing.T) {
+	backup := backupExperiments(t,
+		newExperiment("team-a", "fold-1", "Succeeded"),
+		newExperiment("team-b", "fold-2", "Running"))
+
+	install, _ := newFakeInstaller(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}})
+	results, err := install.Restore(context.TODO(), bytes.NewReader(backup), &RestoreOptions{
+		Namespaces: map[string]string{"team-a": "team-c"},
+		Conflict:   ConflictSkip,
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, &RestoreResult{
+		Resource:  "experiments.app.foldy.dev",
+		Namespace: "team-c",
+		Name:      "fold-1",
+		Action:    RestoreCreated,
+	}, results[0])
+	exists, err := NamespaceExists(context.TODO(), install.client, "team-c")
+	require.NoError(t, err)
+	assert.True(t, exists, "mapped namespaces must be created")
+
+	restored, err := install.Dynamic.Resource(experimentsResource).Namespace("team-c").Get("fold-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	phase, _, _ := unstructured.NestedString(restored.Object, "status", "phase")
+	assert.Equal(t, "Succeeded", phase)
+	assert.Empty(t, restored.GetOwnerReferences(), "owners don't survive a reinstall")
+	assert.Empty(t, string(restored.GetUID()))
+}
+
+func TestRestoreConflicts(t *testing.T) {
+	backup := backupExperiments(t, newExperiment("team-a", "fold-1", "Succeeded"))
+	existing := newExperiment("team-a", "fold-1", "Failed")
+
+	install, _ := newFakeInstaller(existing)
+	results, err := install.Restore(context.TODO(), bytes.NewReader(backup), &RestoreOptions{Conflict: ConflictSkip})
+	require.NoError(t, err)
+	assert.Equal(t, RestoreSkipped, results[0].Action)
+
+	_, err = install.Restore(context.TODO(), bytes.NewReader(backup), &RestoreOptions{Conflict: ConflictFail})
+	assert.Error(t, err)
+
+	results, err = install.Restore(context.TODO(), bytes.NewReader(backup), &RestoreOptions{Conflict: ConflictOverwrite})
+	require.NoError(t, err)
+	assert.Equal(t, RestoreOverwritten, results[0].Action)
+	restored, err := install.Dynamic.Resource(experimentsResource).Namespace("team-a").Get("fold-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	phase, _, _ := unstructured.NestedString(restored.Object, "status", "phase")
+	assert.Equal(t, "Succeeded", phase)
+}
+
+func TestRestoreNotABackup(t *testing.T) {
+	install, _ := newFakeInstaller()
+	_, err := install.Restore(context.TODO(), bytes.NewReader([]byte("foo")), &RestoreOptions{Conflict: ConflictSkip})
+	assert.Error(t, err)
+}
+
+func TestBackupBeforeUninstall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "foldy-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	install, _ := newFakeInstaller(newExperiment("team-a", "fold-1", "Succeeded"))
+	install.Force = true
+	install.BackupDir = dir
+	require.NoError(t, install.UninstallComponentsByName(context.TODO(), []string{"foldy"}))
+	files, err := filepath.Glob(filepath.Join(dir, "foldy-backup-*.tar.gz"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	info, err := os.Stat(files[0])
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// Only the first component deleting the CRDs backs up
+	require.NoError(t, install.backupBeforeUninstall(context.TODO(), GetComponentByName("foldy")))
+	files, err = filepath.Glob(filepath.Join(dir, "foldy-backup-*.tar.gz"))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
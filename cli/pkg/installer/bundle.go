@@ -0,0 +1,458 @@
+package installer
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/foldy-project/foldy/cli/pkg/readiness"
+)
+
+const (
+	// BundleManifestName is the file at the root of a bundle
+	// describing its contents
+	BundleManifestName = "bundle.json"
+
+	// BundleServerName is the name of the Deployment, Service
+	// and PersistentVolumeClaim that serve a bundle's
+	// repositories to Argo CD
+	BundleServerName = "foldy-bundle"
+
+	// BundleServerStorage is the size of the volume holding
+	// the repositories
+	BundleServerStorage = "1Gi"
+
+	// ArgoCDManifestURLFormat is the manifest of a specific
+	// Argo CD release, which is what bundles are pinned to
+	ArgoCDManifestURLFormat = "https://raw.githubusercontent.com/argoproj/argo-cd/%s/manifests/install.yaml"
+
+	bundleArgoCDManifest = "argocd/install.yaml"
+	bundleRepoDir        = "repos"
+)
+
+// BundleRepository is a git repository mirrored into a bundle
+type BundleRepository struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// BundleManifest describes the contents of a bundle
+type BundleManifest struct {
+	CLIVersion    string              `json:"cliVersion"`
+	CreatedAt     time.Time           `json:"createdAt"`
+	ArgoCDVersion string              `json:"argocdVersion"`
+	Repositories  []*BundleRepository `json:"repositories"`
+}
+
+// Bundle is an extracted bundle that foldy can be installed
+// from without internet access
+type Bundle struct {
+	Dir      string
+	Manifest *BundleManifest
+}
+
+// normalizeRepoURL makes equivalent git URLs compare equal
+func normalizeRepoURL(url string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
+}
+
+// bundleRepositories returns every git repository that the
+// registered components deploy from. Extra repositories that
+// aren't git repositories can't be mirrored, so a bundle
+// without them would be incomplete.
+func bundleRepositories() ([]*BundleRepository, error) {
+	g, err := BuildGraph()
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, comp := range g.Components() {
+		app, ok := comp.(*ApplicationComponent)
+		if !ok {
+			continue
+		}
+		urls = append(urls, app.RepoURL)
+		for _, url := range app.RepoValues {
+			urls = append(urls, url)
+		}
+		for _, repo := range app.ExtraRepos {
+			if !strings.HasSuffix(repo.URL, ".git") {
+				return nil, fmt.Errorf("%s: unable to bundle '%s', which is not a git repository", comp.GetName(), repo.URL)
+			}
+			urls = append(urls, repo.URL)
+		}
+	}
+	sort.Strings(urls)
+	var repos []*BundleRepository
+	seen := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, url := range urls {
+		if seen[normalizeRepoURL(url)] {
+			continue
+		}
+		seen[normalizeRepoURL(url)] = true
+		name := path.Base(normalizeRepoURL(url))
+		for i := 2; names[name]; i++ {
+			name = fmt.Sprintf("%s-%d", path.Base(normalizeRepoURL(url)), i)
+		}
+		names[name] = true
+		repos = append(repos, &BundleRepository{Name: name, URL: url})
+	}
+	return repos, nil
+}
+
+// CreateBundle downloads the Argo CD manifest of the given
+// release and mirrors every repository the components deploy
+// from, then writes them to a gzipped tarball at output
+func (s *Installer) CreateBundle(ctx context.Context, output string, argoCDVersion string) error {
+	dir, err := ioutil.TempDir("", "foldy-bundle")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	repos, err := bundleRepositories()
+	if err != nil {
+		return err
+	}
+	manifest := &BundleManifest{
+		CLIVersion:    s.Version,
+		CreatedAt:     time.Now().UTC(),
+		ArgoCDVersion: argoCDVersion,
+		Repositories:  repos,
+	}
+	if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(bundleArgoCDManifest)), 0755); err != nil {
+		return err
+	}
+	if err := s.exec(ctx, "curl -fsSL -o %s %s",
+		filepath.Join(dir, bundleArgoCDManifest),
+		fmt.Sprintf(ArgoCDManifestURLFormat, argoCDVersion)); err != nil {
+		return err
+	}
+	for _, repo := range repos {
+		if err := s.exec(ctx, "git clone --mirror %s %s",
+			repo.URL,
+			filepath.Join(dir, bundleRepoDir, repo.Name+".git")); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, BundleManifestName), data, 0644); err != nil {
+		return err
+	}
+	return writeTarball(output, dir)
+}
+
+// OpenBundle extracts the bundle to a temporary directory,
+// which is removed by Close
+func OpenBundle(file string) (*Bundle, error) {
+	dir, err := ioutil.TempDir("", "foldy-bundle")
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{Dir: dir}
+	if err := extractTarball(file, dir); err != nil {
+		b.Close()
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, BundleManifestName))
+	if err != nil {
+		b.Close()
+		return nil, fmt.Errorf("%s is not a foldy bundle: %v", file, err)
+	}
+	b.Manifest = &BundleManifest{}
+	if err := json.Unmarshal(data, b.Manifest); err != nil {
+		b.Close()
+		return nil, fmt.Errorf("corrupt bundle manifest: %v", err)
+	}
+	return b, nil
+}
+
+// Close removes the extracted bundle
+func (b *Bundle) Close() error {
+	return os.RemoveAll(b.Dir)
+}
+
+// ArgoCDManifest returns the path to the pinned Argo CD manifest
+func (b *Bundle) ArgoCDManifest() string {
+	return filepath.Join(b.Dir, bundleArgoCDManifest)
+}
+
+// RepoURL returns the in-cluster URL of the repository that
+// mirrors url, or false if the bundle doesn't contain it
+func (b *Bundle) RepoURL(url string) (string, bool) {
+	for _, repo := range b.Manifest.Repositories {
+		if normalizeRepoURL(repo.URL) == normalizeRepoURL(url) {
+			return fmt.Sprintf("git://%s.argocd.svc.cluster.local/%s.git", BundleServerName, repo.Name), true
+		}
+	}
+	return "", false
+}
+
+// sourceURL returns the URL Argo CD should pull the repository
+// from, which is redirected to the bundle server if installing
+// from a bundle
+func (s *Installer) sourceURL(url string) (string, error) {
+	if s.Bundle == nil {
+		return url, nil
+	}
+	if bundled, ok := s.Bundle.RepoURL(url); ok {
+		return bundled, nil
+	}
+	return "", fmt.Errorf("repository '%s' is not part of the bundle", url)
+}
+
+// argoCDManifest returns the location of the Argo CD manifest
+func (s *Installer) argoCDManifest() string {
+	if s.Bundle != nil {
+		return s.Bundle.ArgoCDManifest()
+	}
+	return ArgoCDManifestURL
+}
+
+// argoCDManifestURL returns where the Argo CD manifest was
+// originally downloaded from
+func (s *Installer) argoCDManifestURL() string {
+	if s.Bundle != nil {
+		return fmt.Sprintf(ArgoCDManifestURLFormat, s.Bundle.Manifest.ArgoCDVersion)
+	}
+	return ArgoCDManifestURL
+}
+
+const bundleServerManifest = `---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: %[1]s
+  namespace: argocd
+  labels:
+    app.kubernetes.io/name: %[1]s
+    app.kubernetes.io/managed-by: foldy
+spec:
+  accessModes: ["ReadWriteOnce"]
+  resources:
+    requests:
+      storage: %[3]s
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: %[1]s
+  namespace: argocd
+  labels:
+    app.kubernetes.io/name: %[1]s
+    app.kubernetes.io/managed-by: foldy
+spec:
+  replicas: 1
+  strategy:
+    type: Recreate
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: %[1]s
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: %[1]s
+    spec:
+      containers:
+      - name: git
+        image: %[2]s
+        command: ["git", "daemon", "--reuseaddr", "--export-all", "--base-path=/git", "/git"]
+        ports:
+        - containerPort: 9418
+        volumeMounts:
+        - name: git
+          mountPath: /git
+      volumes:
+      - name: git
+        persistentVolumeClaim:
+          claimName: %[1]s
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: %[1]s
+  namespace: argocd
+  labels:
+    app.kubernetes.io/name: %[1]s
+    app.kubernetes.io/managed-by: foldy
+spec:
+  selector:
+    app.kubernetes.io/name: %[1]s
+  ports:
+  - name: git
+    port: 9418
+`
+
+// serveBundle deploys a git daemon next to Argo CD and uploads
+// the bundle's repositories to it. The Argo CD image is reused
+// because it ships with git and has to be available anyway.
+// The repositories live on a volume, so they survive the pod
+// being rescheduled, and are uploaded again on every install.
+func (s *Installer) serveBundle(ctx context.Context) error {
+	s.bundleL.Lock()
+	defer s.bundleL.Unlock()
+	if s.Bundle == nil || s.bundleServed {
+		return nil
+	}
+	manifest := fmt.Sprintf(bundleServerManifest, BundleServerName, s.mirrorImage(s.argoCDImage()), BundleServerStorage)
+	if err := s.exec(ctx, "cat <<EOF | kubectl apply -f -\n%sEOF", manifest); err != nil {
+		return err
+	}
+	podName := "<foldy-bundle-pod>"
+	if !s.DryRun {
+		waiter, err := s.waiter()
+		if err != nil {
+			return err
+		}
+		if err := waiter.Wait(ctx, readiness.DeploymentAvailable("argocd", BundleServerName)); err != nil {
+			return err
+		}
+		pods := &corev1.PodList{}
+		if err := s.client.List(
+			ctx,
+			pods,
+			client.InNamespace("argocd"),
+			client.MatchingLabels{"app.kubernetes.io/name": BundleServerName},
+		); err != nil {
+			return err
+		}
+		podName = ""
+		for _, pod := range pods.Items {
+			if pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil {
+				podName = pod.Name
+				break
+			}
+		}
+		if podName == "" {
+			return fmt.Errorf("did not find running %s pod", BundleServerName)
+		}
+	}
+	for _, repo := range s.Bundle.Manifest.Repositories {
+		if err := s.exec(ctx, "kubectl cp %s argocd/%s:/git/%s.git",
+			filepath.Join(s.Bundle.Dir, bundleRepoDir, repo.Name+".git"),
+			podName,
+			repo.Name); err != nil {
+			return err
+		}
+	}
+	s.bundleServed = true
+	return nil
+}
+
+// writeTarball writes the contents of dir to a gzipped tarball
+func writeTarball(output string, dir string) (err error) {
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	if err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		} else if rel == "." {
+			return nil
+		}
+		if !info.Mode().IsDir() && !info.Mode().IsRegular() {
+			// git mirrors don't need symlinks or devices
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		src, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// extractTarball extracts a gzipped tarball into dir
+func extractTarball(file string, dir string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("illegal path in bundle: %s", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			dst, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode)&0777)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(dst, tr); err != nil {
+				dst.Close()
+				return err
+			}
+			if err := dst.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
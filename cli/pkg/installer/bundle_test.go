@@ -0,0 +1,100 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestBundle creates a bundle mirroring every repository
+// without touching the network
+func writeTestBundle(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "foldy-bundle-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	repos, err := bundleRepositories()
+	require.NoError(t, err)
+	data, err := json.Marshal(&BundleManifest{ArgoCDVersion: "v1.5.0", Repositories: repos})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, BundleManifestName), data, 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "argocd"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, bundleArgoCDManifest), []byte("---\n"), 0644))
+	for _, repo := range repos {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, bundleRepoDir, repo.Name+".git"), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, bundleRepoDir, repo.Name+".git", "HEAD"), []byte("ref: refs/heads/master\n"), 0644))
+	}
+	f, err := ioutil.TempFile("", "foldy-bundle-*.tar.gz")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, writeTarball(f.Name(), dir))
+	return f.Name()
+}
+
+func TestOpenBundle(t *testing.T) {
+	file := writeTestBundle(t)
+	defer os.Remove(file)
+	b, err := OpenBundle(file)
+	require.NoError(t, err)
+	defer b.Close()
+	assert.Equal(t, "v1.5.0", b.Manifest.ArgoCDVersion)
+	data, err := ioutil.ReadFile(b.ArgoCDManifest())
+	require.NoError(t, err)
+	assert.Equal(t, "---\n", string(data))
+	url, ok := b.RepoURL("https://github.com/foldy-project/foldy")
+	require.True(t, ok, ".git suffix must not matter")
+	assert.Equal(t, "git://foldy-bundle.argocd.svc.cluster.local/foldy.git", url)
+	_, ok = b.RepoURL("https://example.com/unknown.git")
+	assert.False(t, ok)
+}
+
+func TestInstallFromBundle(t *testing.T) {
+	file := writeTestBundle(t)
+	defer os.Remove(file)
+	b, err := OpenBundle(file)
+	require.NoError(t, err)
+	defer b.Close()
+	install, _ := newFakeInstaller()
+	defer install.Reuse()
+	install.DryRun = true
+	install.Bundle = b
+	require.NoError(t, install.InstallComponentsByName(context.TODO(), []string{"foldy"}))
+	var commands []string
+	for _, step := range install.Plan.Steps {
+		commands = append(commands, step.Command)
+		assert.NotContains(t, step.Command, "-f https://", "nothing may be fetched from the internet")
+		assert.NotContains(t, step.Command, "=https://", "child applications must use the bundle")
+	}
+	plan := strings.Join(commands, "\n")
+	assert.Contains(t, plan, "kubectl apply -n argocd -f "+b.ArgoCDManifest())
+	assert.Contains(t, plan, "kubectl cp "+filepath.Join(b.Dir, "repos", "foldy.git")+" argocd/<foldy-bundle-pod>:/git/foldy.git")
+	assert.Contains(t, plan, "--repo git://foldy-bundle.argocd.svc.cluster.local/foldy.git")
+	assert.Contains(t, plan, "--helm-set argo.repoURL=git://foldy-bundle.argocd.svc.cluster.local/argo-helm.git")
+	assert.Contains(t, plan, "claimName: foldy-bundle", "the repositories must survive the pod being rescheduled")
+}
+
+func TestBundleRepositoriesRequireGit(t *testing.T) {
+	componentsL.Lock()
+	registered := components
+	components = append(append([]Component{}, registered...), &ApplicationComponent{
+		Name:       "results-db",
+		RepoURL:    "https://git.example.com/results-db.git",
+		Path:       "chart",
+		ExtraRepos: []*Repository{{Name: "stable", URL: "https://kubernetes-charts.storage.googleapis.com"}},
+	})
+	componentsL.Unlock()
+	defer func() {
+		componentsL.Lock()
+		components = registered
+		componentsL.Unlock()
+	}()
+	_, err := bundleRepositories()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "results-db: unable to bundle 'https://kubernetes-charts.storage.googleapis.com'")
+}
@@ -0,0 +1,550 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Actions taken by GarbageCollect for each leftover
+const (
+	CleanUpDeleted = "deleted"
+	CleanUpPlanned = "would delete" // In dry-run mode
+	CleanUpKept    = "kept"
+	CleanUpFailed  = "failed"
+)
+
+// Removed returns true unless the leftover is still there
+func (r *CleanUpResult) Removed() bool {
+	return r.Action == CleanUpDeleted || r.Action == CleanUpPlanned
+}
+
+// CleanUpResult is what GarbageCollect did with one leftover
+// resource
+type CleanUpResult struct {
+	Resource          string   `json:"resource"`
+	Namespace         string   `json:"namespace,omitempty"`
+	Name              string   `json:"name"`
+	Component         string   `json:"component"`
+	Reason            string   `json:"reason"` // Why it is considered a leftover
+	Action            string   `json:"action"`
+	RemovedFinalizers []string `json:"removedFinalizers,omitempty"`
+	Error             string   `json:"error,omitempty"`
+}
+
+// finalizerControllers maps finalizers to the component
+// running the controller that removes them. A finalizer can
+// only be removed safely once that component is gone, as
+// nothing else will ever process it.
+var finalizerControllers = map[string]string{
+	"resources-finalizer.argocd.argoproj.io": "argocd",
+}
+
+// argoCDInstanceLabel is how Argo CD tracks the resources,
+// including child Applications, that an Application deployed
+const argoCDInstanceLabel = "app.kubernetes.io/instance"
+
+var applicationsResource = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "applications"}
+
+// protectedNamespaces are never deleted, whatever a
+// component claims
+var protectedNamespaces = map[string]bool{
+	"default":         true,
+	"kube-node-lease": true,
+	"kube-public":     true,
+	"kube-system":     true,
+}
+
+// leftovers tracks the uninstalled components and what they
+// are known to have created. Resources with generic names,
+// such as a traefik release, may just as well belong to the
+// user, so only foldy's own markers and the ledger count.
+type leftovers struct {
+	components   map[string]bool
+	applications map[string]string // Argo CD Applications created by foldy or its Applications -> component
+	namespaces   map[string]string // Namespaces that the ledger shows were created -> component
+}
+
+// findLeftovers returns the named components, or all of
+// those without an install record if names is empty, along
+// with the records of those that have one
+func (s *Installer) findLeftovers(ctx context.Context, names []string) (*leftovers, []Component, map[string]*InstallRecord, error) {
+	g, err := s.Graph()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	records, err := s.ReadLedger(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		if g.Component(name) == nil {
+			return nil, nil, nil, fmt.Errorf("unknown component '%s'", name)
+		}
+		wanted[name] = true
+	}
+	l := &leftovers{
+		components:   make(map[string]bool),
+		applications: make(map[string]string),
+		namespaces:   make(map[string]string),
+	}
+	var components []Component
+	for _, comp := range g.Components() {
+		if len(wanted) > 0 {
+			if !wanted[comp.GetName()] {
+				continue
+			}
+		} else if _, installed := records[comp.GetName()]; installed {
+			continue
+		}
+		components = append(components, comp)
+		l.components[comp.GetName()] = true
+		if _, ok := comp.(*ApplicationComponent); ok {
+			l.applications[comp.GetName()] = comp.GetName()
+		}
+		if record, ok := records[comp.GetName()]; ok {
+			for _, namespace := range recordedNamespaces(comp, record) {
+				l.namespaces[namespace] = comp.GetName()
+			}
+		}
+	}
+	return l, components, records, nil
+}
+
+// findChildApplications adds the Applications that Argo CD
+// deployed on behalf of those created by foldy, at any depth
+func (s *Installer) findChildApplications(l *leftovers) error {
+	apps, err := s.listCustomResources(applicationsResource)
+	if err != nil {
+		return err
+	}
+	for found := true; found; {
+		found = false
+		for i := range apps {
+			if _, ok := l.applications[apps[i].GetName()]; ok {
+				continue
+			}
+			if comp, ok := l.applications[apps[i].GetLabels()[argoCDInstanceLabel]]; ok {
+				l.applications[apps[i].GetName()] = comp
+				found = true
+			}
+		}
+	}
+	return nil
+}
+
+// ownerOf returns the uninstalled component that the object
+// belongs to according to its labels, and why. Only objects
+// deployed by an Application that foldy created, or labelled
+// by foldy itself, are claimed.
+func (l *leftovers) ownerOf(obj metav1.Object) (string, string) {
+	if strings.HasPrefix(obj.GetName(), "system:") {
+		// Never touch Kubernetes' own RBAC
+		return "", ""
+	}
+	if instance := obj.GetLabels()[argoCDInstanceLabel]; instance != "" {
+		if comp, ok := l.applications[instance]; ok {
+			return comp, fmt.Sprintf("label %s=%s", argoCDInstanceLabel, instance)
+		}
+	}
+	if obj.GetLabels()["app.kubernetes.io/managed-by"] == "foldy" && l.components["foldy"] {
+		return "foldy", "label app.kubernetes.io/managed-by=foldy"
+	}
+	return "", ""
+}
+
+// CleanUp removes whatever uninstalled components left behind.
+// It fails if anything could not be removed.
+func (s *Installer) CleanUp() error {
+	results, err := s.GarbageCollect(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	return cleanUpError(results)
+}
+
+func cleanUpError(results []*CleanUpResult) error {
+	var failed []string
+	for _, result := range results {
+		if !result.Removed() {
+			failed = append(failed, fmt.Sprintf("%s %s", result.Resource, qualifiedName(result.Namespace, result.Name)))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not clean up %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func qualifiedName(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// GarbageCollect finds the resources left behind by the named
+// components and deletes them. The install records of those
+// that were fully removed are dropped, as their uninstallation
+// may have failed part way. If names is empty, only components
+// without an install record are considered, so that nothing
+// installed is ever touched.
+//
+// Leftovers are the Argo CD Applications that foldy created
+// and their children, along with the webhooks, cluster roles
+// and bindings and ClusterIssuers that those deployed, that
+// foldy labelled or that are tied to namespaces foldy
+// created. CRDs and namespaces are only deleted if the ledger
+// shows that foldy created them. Finalizers are removed only
+// if the controller that would process them was uninstalled.
+func (s *Installer) GarbageCollect(ctx context.Context, names []string) ([]*CleanUpResult, error) {
+	l, components, records, err := s.findLeftovers(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	if len(components) == 0 {
+		return nil, nil
+	}
+	if err := s.findChildApplications(l); err != nil {
+		return nil, err
+	}
+	var results []*CleanUpResult
+	for _, find := range []func(context.Context, *leftovers) ([]*CleanUpResult, error){
+		// Webhooks go first, as a webhook whose service is gone
+		// makes the API server reject other requests
+		s.leftoverWebhooks,
+		s.leftoverApplications,
+		s.leftoverClusterRoles,
+		s.leftoverClusterIssuers,
+	} {
+		found, err := find(ctx, l)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, found...)
+	}
+	for _, comp := range components {
+		results = append(results, s.leftoverCRDs(ctx, comp, records[comp.GetName()])...)
+	}
+	namespaces, err := s.leftoverNamespaces(ctx, l)
+	if err != nil {
+		return results, err
+	}
+	results = append(results, namespaces...)
+	failed := make(map[string]bool)
+	for _, result := range results {
+		if result.Action == "" {
+			s.removeLeftover(ctx, l, result)
+		}
+		if !result.Removed() {
+			failed[result.Component] = true
+		}
+	}
+	for _, comp := range components {
+		if _, ok := records[comp.GetName()]; ok && !failed[comp.GetName()] {
+			if err := s.updateLedger(ctx, comp.GetName(), nil); err != nil {
+				return results, err
+			}
+		}
+	}
+	return results, nil
+}
+
+// removeLeftover strips the finalizers that are safe to
+// remove and deletes the resource
+func (s *Installer) removeLeftover(ctx context.Context, l *leftovers, result *CleanUpResult) {
+	obj, err := newObject(result.Resource, result.Name, result.Namespace)
+	if err != nil {
+		result.Action, result.Error = CleanUpFailed, err.Error()
+		return
+	}
+	if err := s.client.Get(ctx, types.NamespacedName{Name: result.Name, Namespace: result.Namespace}, obj); err != nil {
+		if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			result.Action = CleanUpDeleted
+			return
+		}
+		result.Action, result.Error = CleanUpFailed, err.Error()
+		return
+	}
+	var kept []string
+	for _, finalizer := range obj.GetFinalizers() {
+		if l.components[finalizerControllers[finalizer]] {
+			result.RemovedFinalizers = append(result.RemovedFinalizers, finalizer)
+		} else {
+			kept = append(kept, finalizer)
+		}
+	}
+	if len(result.RemovedFinalizers) > 0 {
+		if err := s.setFinalizers(ctx, result.Resource, obj, kept); err != nil {
+			result.Action, result.Error = CleanUpFailed, err.Error()
+			return
+		}
+	}
+	if err := s.deleteResource(ctx, result.Resource, result.Name, result.Namespace); err != nil {
+		result.Action, result.Error = CleanUpFailed, err.Error()
+		return
+	}
+	if s.DryRun {
+		result.Action = CleanUpPlanned
+		return
+	}
+	result.Action = CleanUpDeleted
+	if obj.GetDeletionTimestamp() == nil {
+		return
+	}
+	// It was already being deleted, so whatever blocked it
+	// before may still do
+	if err := s.client.Get(ctx, types.NamespacedName{Name: result.Name, Namespace: result.Namespace}, obj); errors.IsNotFound(err) {
+		return
+	}
+	result.Action, result.Error = CleanUpKept, "still terminating"
+	if len(kept) > 0 {
+		result.Error += fmt.Sprintf(", waiting for finalizers %s", strings.Join(kept, ", "))
+	} else if result.Resource == "namespace" {
+		result.Error += ", check for resources with finalizers in it"
+	}
+}
+
+// setFinalizers replaces the finalizers of the object
+func (s *Installer) setFinalizers(ctx context.Context, resource string, obj *unstructured.Unstructured, finalizers []string) error {
+	patch := client.MergeFrom(obj.DeepCopy())
+	obj.SetFinalizers(finalizers)
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	command := fmt.Sprintf("kubectl patch %s %s", resource, obj.GetName())
+	if obj.GetNamespace() != "" {
+		command = fmt.Sprintf("kubectl patch %s -n %s %s", resource, obj.GetNamespace(), obj.GetName())
+	}
+	return s.mutate(ctx, func() error {
+		if err := s.client.Patch(ctx, obj, patch); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		return nil
+	}, "%s --type=merge -p '%s'", command, data)
+}
+
+func (s *Installer) leftoverWebhooks(ctx context.Context, l *leftovers) ([]*CleanUpResult, error) {
+	var results []*CleanUpResult
+	check := func(resource string, obj metav1.Object, configs []admissionv1.WebhookClientConfig) {
+		comp, reason := l.ownerOf(obj)
+		for _, config := range configs {
+			if comp != "" {
+				break
+			}
+			if config.Service != nil {
+				if owner, ok := l.namespaces[config.Service.Namespace]; ok {
+					comp = owner
+					reason = fmt.Sprintf("calls service %s/%s", config.Service.Namespace, config.Service.Name)
+				}
+			}
+		}
+		if comp != "" {
+			results = append(results, &CleanUpResult{Resource: resource, Name: obj.GetName(), Component: comp, Reason: reason})
+		}
+	}
+	mutating := &admissionv1.MutatingWebhookConfigurationList{}
+	if err := s.client.List(ctx, mutating); err != nil {
+		return nil, err
+	}
+	for i := range mutating.Items {
+		var configs []admissionv1.WebhookClientConfig
+		for _, webhook := range mutating.Items[i].Webhooks {
+			configs = append(configs, webhook.ClientConfig)
+		}
+		check("mutatingwebhookconfiguration", &mutating.Items[i], configs)
+	}
+	validating := &admissionv1.ValidatingWebhookConfigurationList{}
+	if err := s.client.List(ctx, validating); err != nil {
+		return nil, err
+	}
+	for i := range validating.Items {
+		var configs []admissionv1.WebhookClientConfig
+		for _, webhook := range validating.Items[i].Webhooks {
+			configs = append(configs, webhook.ClientConfig)
+		}
+		check("validatingwebhookconfiguration", &validating.Items[i], configs)
+	}
+	return results, nil
+}
+
+// listCustomResources lists the custom resources in every
+// namespace. Nothing is returned if their CRD is gone.
+func (s *Installer) listCustomResources(gvr schema.GroupVersionResource) ([]unstructured.Unstructured, error) {
+	client, err := s.dynamic()
+	if err != nil {
+		return nil, err
+	}
+	list, err := client.Resource(gvr).Namespace(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("list %s: %v", resourceName(gvr), err)
+	}
+	return list.Items, nil
+}
+
+// leftoverApplications returns the Argo CD Applications of
+// uninstalled components, including the child Applications
+// they created, which Argo CD labels with their parent
+func (s *Installer) leftoverApplications(ctx context.Context, l *leftovers) ([]*CleanUpResult, error) {
+	apps, err := s.listCustomResources(applicationsResource)
+	if err != nil {
+		return nil, err
+	}
+	var results []*CleanUpResult
+	for i := range apps {
+		app := &apps[i]
+		comp, reason := l.ownerOf(app)
+		if comp == "" && l.applications[app.GetName()] == app.GetName() {
+			comp, reason = app.GetName(), "Application of the component"
+		}
+		if comp != "" {
+			results = append(results, &CleanUpResult{Resource: "application", Namespace: app.GetNamespace(), Name: app.GetName(), Component: comp, Reason: reason})
+		}
+	}
+	return results, nil
+}
+
+func (s *Installer) leftoverClusterRoles(ctx context.Context, l *leftovers) ([]*CleanUpResult, error) {
+	var results []*CleanUpResult
+	bindings := &rbacv1.ClusterRoleBindingList{}
+	if err := s.client.List(ctx, bindings); err != nil {
+		return nil, err
+	}
+	for i := range bindings.Items {
+		binding := &bindings.Items[i]
+		comp, reason := l.ownerOf(binding)
+		if comp == "" && len(binding.Subjects) > 0 {
+			// Bindings of service accounts that are all gone
+			for _, subject := range binding.Subjects {
+				owner, ok := l.namespaces[subject.Namespace]
+				if subject.Kind != rbacv1.ServiceAccountKind || !ok {
+					comp = ""
+					break
+				}
+				comp = owner
+				reason = fmt.Sprintf("binds service account %s/%s", subject.Namespace, subject.Name)
+			}
+		}
+		if comp != "" {
+			results = append(results, &CleanUpResult{Resource: "clusterrolebinding", Name: binding.Name, Component: comp, Reason: reason})
+		}
+	}
+	roles := &rbacv1.ClusterRoleList{}
+	if err := s.client.List(ctx, roles); err != nil {
+		return nil, err
+	}
+	for i := range roles.Items {
+		if comp, reason := l.ownerOf(&roles.Items[i]); comp != "" {
+			results = append(results, &CleanUpResult{Resource: "clusterrole", Name: roles.Items[i].Name, Component: comp, Reason: reason})
+		}
+	}
+	return results, nil
+}
+
+func (s *Installer) leftoverClusterIssuers(ctx context.Context, l *leftovers) ([]*CleanUpResult, error) {
+	issuers, err := s.listCustomResources(schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1alpha2", Resource: "clusterissuers"})
+	if err != nil {
+		return nil, err
+	}
+	var results []*CleanUpResult
+	for i := range issuers {
+		if comp, reason := l.ownerOf(&issuers[i]); comp != "" {
+			results = append(results, &CleanUpResult{Resource: "clusterissuer", Name: issuers[i].GetName(), Component: comp, Reason: reason})
+		}
+	}
+	return results, nil
+}
+
+// leftoverCRDs returns the component's CRDs that still exist,
+// including those recorded by its last installation. Without
+// a record, nothing shows that foldy created them.
+func (s *Installer) leftoverCRDs(ctx context.Context, comp Component, record *InstallRecord) []*CleanUpResult {
+	if record == nil {
+		return nil
+	}
+	var results []*CleanUpResult
+	for _, name := range recordedCRDs(comp, record) {
+		crd, err := newObject("crd", name, "")
+		if err != nil {
+			continue
+		}
+		result := &CleanUpResult{Resource: "crd", Name: name, Component: comp.GetName(), Reason: "CRD of the component"}
+		if err := s.client.Get(ctx, types.NamespacedName{Name: name}, crd); errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			result.Action, result.Error = CleanUpFailed, err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// leftoverNamespaces returns the recorded namespaces of
+// uninstalled components that still exist, e.g. because a
+// hook failed or they are stuck terminating
+func (s *Installer) leftoverNamespaces(ctx context.Context, l *leftovers) ([]*CleanUpResult, error) {
+	names := make([]string, 0, len(l.namespaces))
+	for name := range l.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var results []*CleanUpResult
+	for _, name := range names {
+		if protectedNamespaces[name] {
+			continue
+		}
+		namespace := &corev1.Namespace{}
+		if err := s.client.Get(ctx, types.NamespacedName{Name: name}, namespace); errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		reason := "namespace of the component"
+		if namespace.Status.Phase == corev1.NamespaceTerminating {
+			reason = "stuck terminating"
+		}
+		results = append(results, &CleanUpResult{Resource: "namespace", Name: name, Component: l.namespaces[name], Reason: reason})
+	}
+	return results, nil
+}
+
+// PrintCleanUpResults writes a table of what GarbageCollect
+// removed and what it couldn't
+func PrintCleanUpResults(w io.Writer, results []*CleanUpResult) error {
+	if len(results) == 0 {
+		_, err := fmt.Fprintln(w, "Nothing was left behind")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RESOURCE\tNAME\tCOMPONENT\tREASON\tACTION\tERROR")
+	for _, result := range results {
+		action := result.Action
+		if len(result.RemovedFinalizers) > 0 {
+			action += fmt.Sprintf(" (removed finalizers %s)", strings.Join(result.RemovedFinalizers, ", "))
+		}
+		message := "-"
+		if result.Error != "" {
+			message = result.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", result.Resource, qualifiedName(result.Namespace, result.Name), result.Component, result.Reason, action, message)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,222 @@
+package installer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// leftoverObjects are what a failed uninstallation of foldy
+// typically leaves behind, next to a traefik and cert-manager
+// that the user installed
+func leftoverObjects(t *testing.T) []runtime.Object {
+	ui, err := newObject("application", "foldy-ui", "argocd")
+	require.NoError(t, err)
+	ui.SetLabels(map[string]string{"app.kubernetes.io/instance": "foldy"})
+	ui.SetFinalizers([]string{"resources-finalizer.argocd.argoproj.io"})
+	certManager, err := newObject("application", "foldy-cert-manager", "argocd")
+	require.NoError(t, err)
+	certManager.SetLabels(map[string]string{"app.kubernetes.io/instance": "foldy"})
+	crd, err := newObject("crd", "backends.app.foldy.dev", "")
+	require.NoError(t, err)
+	userCRD, err := newObject("crd", "certificates.cert-manager.io", "")
+	require.NoError(t, err)
+	return []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "argocd"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "argo-events"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "traefik"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "cert-manager"}},
+		&admissionv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "foldy-cert-manager-webhook",
+				Labels: map[string]string{"app.kubernetes.io/instance": "foldy-cert-manager"},
+			},
+		},
+		&admissionv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "cert-manager-webhook"},
+			Webhooks: []admissionv1.ValidatingWebhook{{
+				Name: "webhook.cert-manager.io",
+				ClientConfig: admissionv1.WebhookClientConfig{
+					Service: &admissionv1.ServiceReference{Namespace: "cert-manager", Name: "cert-manager-webhook"},
+				},
+			}},
+		},
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "foldy-ui", Labels: map[string]string{"app.kubernetes.io/instance": "foldy-ui"}}},
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "system:foldy-ui", Labels: map[string]string{"app.kubernetes.io/instance": "foldy-ui"}}},
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "traefik", Labels: map[string]string{"release": "traefik", "app.kubernetes.io/instance": "traefik"}}},
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "unrelated"}},
+		&rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "argo-binding"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "argo", Namespace: "argo"}},
+		},
+		&rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "mixed-binding"},
+			Subjects: []rbacv1.Subject{
+				{Kind: rbacv1.ServiceAccountKind, Name: "argo", Namespace: "argo"},
+				{Kind: rbacv1.ServiceAccountKind, Name: "other", Namespace: "other"},
+			},
+		},
+		ui,
+		certManager,
+		crd,
+		userCRD,
+	}
+}
+
+func cleanUpResult(results []*CleanUpResult, resource string, name string) *CleanUpResult {
+	for _, result := range results {
+		if result.Resource == resource && result.Name == name {
+			return result
+		}
+	}
+	return nil
+}
+
+func TestGarbageCollect(t *testing.T) {
+	install, _ := newFakeInstaller(leftoverObjects(t)...)
+	// Argo CD is still installed, so it processes its finalizers
+	require.NoError(t, install.updateLedger(context.TODO(), "argocd", install.newInstallRecord(GetComponentByName("argocd"))))
+	results, err := install.GarbageCollect(context.TODO(), nil)
+	require.NoError(t, err)
+
+	for _, expected := range []struct {
+		resource  string
+		name      string
+		component string
+	}{
+		{"validatingwebhookconfiguration", "foldy-cert-manager-webhook", "foldy"},
+		{"clusterrole", "foldy-ui", "foldy"},
+		{"application", "foldy-ui", "foldy"},
+		{"application", "foldy-cert-manager", "foldy"},
+	} {
+		result := cleanUpResult(results, expected.resource, expected.name)
+		require.NotNil(t, result, "%s %s", expected.resource, expected.name)
+		assert.Equal(t, expected.component, result.Component)
+		assert.Equal(t, CleanUpDeleted, result.Action, "%s %s: %s", expected.resource, expected.name, result.Error)
+		obj, err := newObject(expected.resource, expected.name, result.Namespace)
+		require.NoError(t, err)
+		assert.True(t, errors.IsNotFound(install.client.Get(context.TODO(), types.NamespacedName{Name: expected.name, Namespace: result.Namespace}, obj)))
+	}
+	assert.Empty(t, cleanUpResult(results, "application", "foldy-ui").RemovedFinalizers)
+	assert.Nil(t, cleanUpResult(results, "clusterrole", "system:foldy-ui"))
+	assert.Nil(t, cleanUpResult(results, "clusterrole", "unrelated"))
+	assert.Nil(t, cleanUpResult(results, "namespace", "argocd"))
+	assert.NoError(t, install.client.Get(context.TODO(), types.NamespacedName{Name: "argocd"}, &corev1.Namespace{}))
+
+	// The ledger doesn't show that foldy created these
+	assert.Nil(t, cleanUpResult(results, "clusterrolebinding", "argo-binding"))
+	assert.Nil(t, cleanUpResult(results, "crd", "backends.app.foldy.dev"))
+	assert.Nil(t, cleanUpResult(results, "namespace", "argo-events"))
+	assertUserResourcesKept(t, install, results)
+}
+
+// assertUserResourcesKept checks that the traefik and
+// cert-manager installed by the user were left alone
+func assertUserResourcesKept(t *testing.T, install *Installer, results []*CleanUpResult) {
+	for _, name := range []string{"traefik", "cert-manager"} {
+		assert.Nil(t, cleanUpResult(results, "namespace", name))
+		assert.NoError(t, install.client.Get(context.TODO(), types.NamespacedName{Name: name}, &corev1.Namespace{}))
+	}
+	assert.Nil(t, cleanUpResult(results, "clusterrole", "traefik"))
+	assert.NoError(t, install.client.Get(context.TODO(), types.NamespacedName{Name: "traefik"}, &rbacv1.ClusterRole{}))
+	assert.Nil(t, cleanUpResult(results, "validatingwebhookconfiguration", "cert-manager-webhook"))
+	assert.Nil(t, cleanUpResult(results, "crd", "certificates.cert-manager.io"))
+}
+
+func TestGarbageCollectRemovesOrphanedFinalizers(t *testing.T) {
+	install, _ := newFakeInstaller(leftoverObjects(t)...)
+	results, err := install.GarbageCollect(context.TODO(), nil)
+	require.NoError(t, err)
+	result := cleanUpResult(results, "application", "foldy-ui")
+	require.NotNil(t, result)
+	assert.Equal(t, []string{"resources-finalizer.argocd.argoproj.io"}, result.RemovedFinalizers)
+	assert.Nil(t, cleanUpResult(results, "namespace", "argocd"), "argocd has no install record")
+}
+
+func TestGarbageCollectInstalled(t *testing.T) {
+	install, _ := newFakeInstaller(leftoverObjects(t)...)
+	for _, name := range []string{"argocd", "foldy"} {
+		require.NoError(t, install.updateLedger(context.TODO(), name, install.newInstallRecord(GetComponentByName(name))))
+	}
+	results, err := install.GarbageCollect(context.TODO(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+	assert.NoError(t, install.CleanUp())
+}
+
+func TestGarbageCollectDryRun(t *testing.T) {
+	install, _ := newFakeInstaller(leftoverObjects(t)...)
+	require.NoError(t, install.updateLedger(context.TODO(), "foldy", install.newInstallRecord(GetComponentByName("foldy"))))
+	install.DryRun = true
+	results, err := install.GarbageCollect(context.TODO(), []string{"foldy"})
+	require.NoError(t, err)
+	require.NotNil(t, cleanUpResult(results, "clusterrole", "foldy-ui"))
+	assert.Equal(t, CleanUpPlanned, cleanUpResult(results, "clusterrole", "foldy-ui").Action)
+	assert.Nil(t, cleanUpResult(results, "namespace", "argocd"), "only foldy was selected")
+	var commands []string
+	for _, step := range install.Plan.Steps {
+		commands = append(commands, step.Command)
+	}
+	plan := strings.Join(commands, "\n")
+	assert.Contains(t, plan, "kubectl delete validatingwebhookconfiguration foldy-cert-manager-webhook")
+	assert.Contains(t, plan, "kubectl delete namespace argo-events")
+	assert.NoError(t, install.client.Get(context.TODO(), types.NamespacedName{Name: "foldy-ui"}, &rbacv1.ClusterRole{}))
+}
+
+func TestGarbageCollectNamedInstalled(t *testing.T) {
+	install, _ := newFakeInstaller(leftoverObjects(t)...)
+	for _, name := range []string{"argocd", "foldy"} {
+		require.NoError(t, install.updateLedger(context.TODO(), name, install.newInstallRecord(GetComponentByName(name))))
+	}
+	// Uninstalling foldy failed in a hook, so it still has a record
+	results, err := install.GarbageCollect(context.TODO(), []string{"foldy"})
+	require.NoError(t, err)
+	for _, expected := range []struct {
+		resource string
+		name     string
+	}{
+		{"namespace", "argo-events"},
+		{"crd", "backends.app.foldy.dev"},
+		{"clusterrolebinding", "argo-binding"},
+	} {
+		result := cleanUpResult(results, expected.resource, expected.name)
+		require.NotNil(t, result, "%s %s", expected.resource, expected.name)
+		assert.Equal(t, CleanUpDeleted, result.Action, "%s %s: %s", expected.resource, expected.name, result.Error)
+	}
+	assert.Nil(t, cleanUpResult(results, "clusterrolebinding", "mixed-binding"))
+	assert.Nil(t, cleanUpResult(results, "namespace", "argocd"))
+	// foldy was installed without ingress
+	assertUserResourcesKept(t, install, results)
+	records, err := install.ReadLedger(context.TODO())
+	require.NoError(t, err)
+	assert.NotContains(t, records, "foldy")
+	assert.Contains(t, records, "argocd")
+
+	_, err = install.GarbageCollect(context.TODO(), []string{"widget"})
+	assert.Error(t, err)
+}
+
+func TestGarbageCollectRecordedNamespaces(t *testing.T) {
+	install, _ := newFakeInstaller(leftoverObjects(t)...)
+	viper.Set("ingress.enabled", true)
+	record := install.newInstallRecord(GetComponentByName("foldy"))
+	viper.Set("ingress.enabled", nil)
+	assert.Equal(t, []string{"traefik"}, record.Namespaces)
+	require.NoError(t, install.updateLedger(context.TODO(), "foldy", record))
+	results, err := install.GarbageCollect(context.TODO(), []string{"foldy"})
+	require.NoError(t, err)
+	require.NotNil(t, cleanUpResult(results, "namespace", "traefik"))
+	assert.Nil(t, cleanUpResult(results, "namespace", "cert-manager"))
+	assert.Nil(t, cleanUpResult(results, "crd", "certificates.cert-manager.io"))
+}
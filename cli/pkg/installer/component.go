@@ -1,6 +1,7 @@
 package installer
 
 import (
+	"context"
 	"fmt"
 	"sync"
 )
@@ -14,13 +15,11 @@ type Component interface {
 	GetName() string
 	GetDependencies() []string
 	GetCRDs() []string
-	IsHandled() bool
-	RunInstall(s *Installer) error
-	RunUninstall(s *Installer) error
+	GetNamespaces() []string
+	RunInstall(ctx context.Context, s *Installer) error
+	RunUninstall(ctx context.Context, s *Installer) error
 
 	init()
-	reuse()
-	sync.Locker
 }
 
 var componentsL sync.Mutex
@@ -53,22 +52,3 @@ func GetComponentsByName(names []string) ([]Component, error) {
 	}
 	return filtered, nil
 }
-
-func GetDirectDependees(name string) []string {
-	var dependees []string
-	for _, comp := range components {
-		if comp.GetName() == name {
-			// No need to scan self
-			continue
-		}
-		if len(comp.GetDependencies()) > 0 {
-			for _, dependency := range comp.GetDependencies() {
-				if dependency == name {
-					dependees = append(dependees, comp.GetName())
-					break
-				}
-			}
-		}
-	}
-	return dependees
-}
@@ -1,28 +1,45 @@
 package installer
 
 import (
-	"sync"
-	"sync/atomic"
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
 )
 
 type ApplicationComponent struct {
-	Name          string
-	RepoURL       string
-	Path          string
-	Dependencies  []string
-	CRDs          []string
-	ExtraRepos    []*Repository
-	PreInstall    func(s *Installer) error
-	PostInstall   func(s *Installer) error
-	PreUninstall  func(s *Installer) error
-	PostUninstall func(s *Installer) error
-	done          <-chan error
-	isHandled     int32
-	l             sync.Mutex
+	Name               string
+	RepoURL            string
+	Path               string
+	Revision           string // Git revision to deploy (default: HEAD)
+	Dependencies       []string
+	CRDs               []string
+	Namespaces         []string // Namespaces besides the one named after the application
+	ExtraRepos         []*Repository
+	RepoValues         map[string]string                    // Helm values holding repository URLs, which are redirected when installing from a bundle
+	Images             []string                             // Images used besides those in ImageValues, e.g. by workloads created at runtime
+	ImageValues        map[string]string                    // Helm values holding images, which are redirected to the mirror registry
+	PrefixValues       map[string]string                    // Helm values holding image repositories or namespaces without a tag, which are prefixed with the mirror registry
+	Values             func(s *Installer) map[string]string // Helm values that depend on the config
+	OptionalCRDs       func(s *Installer) []string          // CRDs that are only applied depending on the config, which are recorded in the ledger
+	OptionalNamespaces func(s *Installer) []string          // Namespaces that are only created depending on the config, which are recorded in the ledger
+	Helm               *HelmComponent                       // Optional alternative used when installing without Argo CD
+	Requests           corev1.ResourceList                  // CPU and memory requested by the deployed workloads, checked by Doctor
+	PreInstall         func(ctx context.Context, s *Installer) error
+	PostInstall        func(ctx context.Context, s *Installer) error
+	PreUninstall       func(ctx context.Context, s *Installer) error
+	PostUninstall      func(ctx context.Context, s *Installer) error
 }
 
 func (c *ApplicationComponent) init() {
-	c.done = make(chan error, 1)
+	if c.Revision == "" {
+		c.Revision = "HEAD"
+	}
+	if c.Helm != nil {
+		if c.Helm.Name == "" {
+			c.Helm.Name = c.Name
+		}
+		c.Helm.init()
+	}
 	hasArgoCDDep := false
 	for _, dep := range c.Dependencies {
 		if dep == "argocd" {
@@ -35,10 +52,6 @@ func (c *ApplicationComponent) init() {
 	}
 }
 
-func (c *ApplicationComponent) IsHandled() bool {
-	return atomic.LoadInt32(&c.isHandled) == 1
-}
-
 func (c *ApplicationComponent) GetName() string {
 	return c.Name
 }
@@ -51,57 +64,83 @@ func (c *ApplicationComponent) GetCRDs() []string {
 	return c.CRDs
 }
 
-func (c *ApplicationComponent) reuse() {
-	atomic.StoreInt32(&c.isHandled, 0)
+func (c *ApplicationComponent) GetNamespaces() []string {
+	return append([]string{c.Name}, c.Namespaces...)
 }
 
-func (c *ApplicationComponent) Lock() {
-	c.l.Lock()
-}
-
-func (c *ApplicationComponent) Unlock() {
-	c.l.Unlock()
-}
-
-func (c *ApplicationComponent) RunInstall(s *Installer) error {
-	if atomic.SwapInt32(&c.isHandled, 1) == 1 {
-		return nil
-	}
+func (c *ApplicationComponent) RunInstall(ctx context.Context, s *Installer) error {
 	if c.PreInstall != nil {
-		if err := c.PreInstall(s); err != nil {
+		if err := c.PreInstall(ctx, s); err != nil {
 			return err
 		}
 	}
 	//for _, repo := range c.ExtraRepos {
-	//	if err := s.RunCommandInArgoCDServer("argocd repo add %s %s", repo.Name, repo.URL); err != nil {
+	//	if err := s.RunCommandInArgoCDServer(ctx, "argocd repo add %s %s", repo.Name, repo.URL); err != nil {
 	//		return err
 	//	}
 	//}
-	if err := s.CreateApplication(c.Name, c.RepoURL, c.Path); err != nil {
+	repoURL, values, err := c.source(ctx, s)
+	if err != nil {
+		return err
+	}
+	if err := s.CreateApplication(ctx, c.Name, repoURL, c.Path, c.Revision, values); err != nil {
 		return err
 	}
 	if c.PostInstall != nil {
-		if err := c.PostInstall(s); err != nil {
+		if err := c.PostInstall(ctx, s); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (c *ApplicationComponent) RunUninstall(s *Installer) error {
-	if atomic.SwapInt32(&c.isHandled, 1) == 1 {
-		return nil
+// source returns the repository and the Helm values that the
+// Application is created with, serving the bundle if there is
+// one and redirecting images to the mirror registry
+func (c *ApplicationComponent) source(ctx context.Context, s *Installer) (string, map[string]string, error) {
+	repoURL := c.RepoURL
+	values := make(map[string]string)
+	if c.Values != nil {
+		for key, value := range c.Values(s) {
+			values[key] = value
+		}
+	}
+	if s.Bundle != nil {
+		if err := s.serveBundle(ctx); err != nil {
+			return "", nil, err
+		}
+		var err error
+		if repoURL, err = s.sourceURL(c.RepoURL); err != nil {
+			return "", nil, err
+		}
+		for key, url := range c.RepoValues {
+			if values[key], err = s.sourceURL(url); err != nil {
+				return "", nil, err
+			}
+		}
 	}
+	if s.Registry != "" {
+		for key, image := range c.ImageValues {
+			values[key] = s.mirrorImage(image)
+		}
+		for key, prefix := range c.PrefixValues {
+			values[key] = s.mirrorImage(prefix)
+		}
+	}
+	return repoURL, values, nil
+}
+
+func (c *ApplicationComponent) RunUninstall(ctx context.Context, s *Installer) error {
 	if c.PreUninstall != nil {
-		if err := c.PreUninstall(s); err != nil {
+		if err := c.PreUninstall(ctx, s); err != nil {
 			return err
 		}
 	}
-	if err := s.DeleteApplication(c.Name); err != nil {
+	if err := s.DeleteApplication(ctx, c.Name); err != nil {
 		return err
 	}
 	if c.PostUninstall != nil {
-		if err := c.PostUninstall(s); err != nil {
+		if err := c.PostUninstall(ctx, s); err != nil {
 			return err
 		}
 	}
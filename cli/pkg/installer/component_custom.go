@@ -1,23 +1,21 @@
 package installer
 
 import (
-	"sync"
-	"sync/atomic"
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
 )
 
 type CustomComponent struct {
 	Name         string
 	Dependencies []string
 	CRDs         []string
-	Install      func(s *Installer) error
-	Uninstall    func(s *Installer) error
-	done         <-chan error
-	isHandled    int32
-	l            sync.Mutex
-}
-
-func (c *CustomComponent) IsHandled() bool {
-	return atomic.LoadInt32(&c.isHandled) == 1
+	Namespaces   []string
+	Source       func(s *Installer) (repoURL string, revision string) // Optional
+	Images       func(s *Installer) []string                          // Optional
+	Requests     corev1.ResourceList                                  // CPU and memory requested by the deployed workloads, checked by Doctor
+	Install      func(ctx context.Context, s *Installer) error
+	Uninstall    func(ctx context.Context, s *Installer) error
 }
 
 func (c *CustomComponent) GetName() string {
@@ -32,26 +30,16 @@ func (c *CustomComponent) GetCRDs() []string {
 	return c.CRDs
 }
 
-func (c *CustomComponent) Lock() {
-	c.l.Lock()
+func (c *CustomComponent) GetNamespaces() []string {
+	return c.Namespaces
 }
 
-func (c *CustomComponent) Unlock() {
-	c.l.Unlock()
+func (c *CustomComponent) RunInstall(ctx context.Context, s *Installer) error {
+	return c.Install(ctx, s)
 }
 
-func (c *CustomComponent) RunInstall(s *Installer) error {
-	if atomic.SwapInt32(&c.isHandled, 1) == 1 {
-		return nil
-	}
-	return c.Install(s)
-}
-
-func (c *CustomComponent) RunUninstall(s *Installer) error {
-	if atomic.SwapInt32(&c.isHandled, 1) == 1 {
-		return nil
-	}
-	return c.Uninstall(s)
+func (c *CustomComponent) RunUninstall(ctx context.Context, s *Installer) error {
+	return c.Uninstall(ctx, s)
 }
 
 func (c *CustomComponent) init() {
@@ -61,9 +49,4 @@ func (c *CustomComponent) init() {
 	if c.Uninstall == nil {
 		panic("CustomComponent has no Uninstall method")
 	}
-	c.done = make(chan error, 1)
-}
-
-func (c *CustomComponent) reuse() {
-	atomic.StoreInt32(&c.isHandled, 0)
 }
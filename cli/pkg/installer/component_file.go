@@ -0,0 +1,169 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/homedir"
+	"sigs.k8s.io/yaml"
+)
+
+// ComponentFile is the declarative description of an
+// ApplicationComponent, which allows components to be added
+// without recompiling the CLI:
+//
+//	name: results-db
+//	repo: https://git.example.com/research/results-db.git
+//	path: chart
+//	revision: v1.2.0
+//	dependencies: [foldy]
+//	crds: [results.example.com]
+//	namespaces: [results-db-backups]
+//
+// The component is deployed to the namespace named after it.
+// The other namespaces are created before the Application and
+// deleted after it.
+type ComponentFile struct {
+	Name         string   `json:"name"`
+	Repo         string   `json:"repo"`
+	Path         string   `json:"path"`
+	Revision     string   `json:"revision,omitempty"`
+	Dependencies []string `json:"dependencies,omitempty"`
+	CRDs         []string `json:"crds,omitempty"`
+	Namespaces   []string `json:"namespaces,omitempty"`
+}
+
+// DefaultComponentsDir is where component files are loaded
+// from, ~/.foldy/components.d
+func DefaultComponentsDir() string {
+	return filepath.Join(homedir.HomeDir(), ".foldy", "components.d")
+}
+
+// ParseComponentFile reads and validates a component file.
+// Unknown keys are rejected so that typos don't go unnoticed.
+func ParseComponentFile(file string) (*ApplicationComponent, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	desc := &ComponentFile{}
+	if err := yaml.UnmarshalStrict(data, desc); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	for _, required := range []struct{ key, value string }{
+		{"name", desc.Name},
+		{"repo", desc.Repo},
+		{"path", desc.Path},
+	} {
+		if required.value == "" {
+			return nil, fmt.Errorf("%s: missing required key '%s'", file, required.key)
+		}
+	}
+	// The name is also a namespace
+	for _, namespace := range append([]string{desc.Name}, desc.Namespaces...) {
+		if msgs := validation.IsDNS1123Label(namespace); len(msgs) > 0 {
+			return nil, fmt.Errorf("%s: '%s' is not a valid namespace: %s", file, namespace, strings.Join(msgs, ", "))
+		}
+		if protectedNamespaces[namespace] {
+			return nil, fmt.Errorf("%s: namespace '%s' is reserved", file, namespace)
+		}
+	}
+	namespaces := desc.Namespaces
+	return &ApplicationComponent{
+		Name:         desc.Name,
+		RepoURL:      desc.Repo,
+		Path:         desc.Path,
+		Revision:     desc.Revision,
+		Dependencies: desc.Dependencies,
+		CRDs:         desc.CRDs,
+		Namespaces:   namespaces,
+		PreInstall: func(ctx context.Context, s *Installer) error {
+			for _, namespace := range namespaces {
+				if err := s.createNamespace(ctx, namespace); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		PostUninstall: func(ctx context.Context, s *Installer) error {
+			return s.AsyncDelete(ctx, "namespace", namespaces)
+		},
+	}, nil
+}
+
+// registeredNamespaces returns the namespaces of every
+// registered component by the name of the component
+func registeredNamespaces() map[string]string {
+	componentsL.Lock()
+	defer componentsL.Unlock()
+	owners := make(map[string]string)
+	for _, comp := range components {
+		for _, namespace := range comp.GetNamespaces() {
+			owners[namespace] = comp.GetName()
+		}
+	}
+	return owners
+}
+
+var componentFilesL sync.Mutex
+var loadedComponentDirs = make(map[string]bool)
+
+// LoadComponentFiles registers a component for every *.yaml
+// file in dir. A missing directory is not an error. Each
+// directory is only loaded once.
+func LoadComponentFiles(dir string) error {
+	componentFilesL.Lock()
+	defer componentFilesL.Unlock()
+	if loadedComponentDirs[dir] {
+		return nil
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	var comps []*ApplicationComponent
+	owners := registeredNamespaces()
+	for _, file := range files {
+		comp, err := ParseComponentFile(file)
+		if err != nil {
+			return err
+		}
+		componentsL.Lock()
+		existing := GetComponentByName(comp.Name)
+		componentsL.Unlock()
+		if existing != nil {
+			return fmt.Errorf("%s: component '%s' is already registered", file, comp.Name)
+		}
+		for _, other := range comps {
+			if other.Name == comp.Name {
+				return fmt.Errorf("%s: component '%s' is defined more than once", file, comp.Name)
+			}
+		}
+		for _, namespace := range comp.GetNamespaces() {
+			if owner, ok := owners[namespace]; ok {
+				return fmt.Errorf("%s: namespace '%s' is already used by component '%s'", file, namespace, owner)
+			}
+			owners[namespace] = comp.Name
+		}
+		comps = append(comps, comp)
+	}
+	// Only register once every file is known to be valid
+	for _, comp := range comps {
+		AddComponent(comp)
+	}
+	loadedComponentDirs[dir] = true
+	return nil
+}
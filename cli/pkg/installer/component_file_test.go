@@ -0,0 +1,104 @@
+package installer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeComponentFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "foldy-components")
+	require.NoError(t, err)
+	for name, data := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+	return dir
+}
+
+func TestParseComponentFile(t *testing.T) {
+	dir := writeComponentFiles(t, map[string]string{
+		"results-db.yaml": `name: results-db
+repo: https://git.example.com/results-db.git
+path: chart
+dependencies: [foldy]
+crds: [results.example.com]
+namespaces: [results-db-backups]
+`,
+		"typo.yaml": `name: typo
+repo: https://git.example.com/typo.git
+path: chart
+dependecies: [foldy]
+`,
+		"incomplete.yaml": `name: incomplete
+path: chart
+`,
+	})
+	defer os.RemoveAll(dir)
+	comp, err := ParseComponentFile(filepath.Join(dir, "results-db.yaml"))
+	require.NoError(t, err)
+	comp.init()
+	assert.Equal(t, "https://git.example.com/results-db.git", comp.RepoURL)
+	assert.Equal(t, "HEAD", comp.Revision)
+	assert.Equal(t, []string{"argocd", "foldy"}, comp.GetDependencies())
+	assert.Equal(t, []string{"results-db", "results-db-backups"}, comp.GetNamespaces())
+	_, err = ParseComponentFile(filepath.Join(dir, "typo.yaml"))
+	assert.Error(t, err, "unknown keys must be rejected")
+	_, err = ParseComponentFile(filepath.Join(dir, "incomplete.yaml"))
+	assert.EqualError(t, err, filepath.Join(dir, "incomplete.yaml")+": missing required key 'repo'")
+}
+
+func TestParseComponentFileNamespaces(t *testing.T) {
+	dir := writeComponentFiles(t, map[string]string{
+		"invalid.yaml":  "name: Results_DB\nrepo: https://git.example.com/results-db.git\npath: chart\n",
+		"reserved.yaml": "name: results-db\nrepo: https://git.example.com/results-db.git\npath: chart\nnamespaces: [kube-system]\n",
+	})
+	defer os.RemoveAll(dir)
+	_, err := ParseComponentFile(filepath.Join(dir, "invalid.yaml"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "'Results_DB' is not a valid namespace")
+	_, err = ParseComponentFile(filepath.Join(dir, "reserved.yaml"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "namespace 'kube-system' is reserved")
+}
+
+func TestComponentFileManagesNamespaces(t *testing.T) {
+	dir := writeComponentFiles(t, map[string]string{
+		"results-db.yaml": "name: results-db\nrepo: https://git.example.com/results-db.git\npath: chart\nnamespaces: [results-db-backups]\n",
+	})
+	defer os.RemoveAll(dir)
+	comp, err := ParseComponentFile(filepath.Join(dir, "results-db.yaml"))
+	require.NoError(t, err)
+	install, _ := newFakeInstaller(healthyArgoCD("password")...)
+	require.NoError(t, comp.RunInstall(context.TODO(), install))
+	exists, err := NamespaceExists(context.TODO(), install.client, "results-db-backups")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	require.NoError(t, comp.RunUninstall(context.TODO(), install))
+	exists, err = NamespaceExists(context.TODO(), install.client, "results-db-backups")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestLoadComponentFilesNamespaceInUse(t *testing.T) {
+	dir := writeComponentFiles(t, map[string]string{
+		"results-db.yaml": "name: results-db\nrepo: https://git.example.com/results-db.git\npath: chart\nnamespaces: [argo-events]\n",
+	})
+	defer os.RemoveAll(dir)
+	err := LoadComponentFiles(dir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "namespace 'argo-events' is already used by component 'foldy'")
+}
+
+func TestLoadComponentFilesDuplicate(t *testing.T) {
+	dir := writeComponentFiles(t, map[string]string{
+		"argocd.yaml": "name: argocd\nrepo: https://git.example.com/argocd.git\npath: chart\n",
+	})
+	defer os.RemoveAll(dir)
+	assert.Error(t, LoadComponentFiles(dir), "built-in components must not be shadowed")
+	assert.NoError(t, LoadComponentFiles(filepath.Join(dir, "missing")))
+}
@@ -0,0 +1,269 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HelmComponent installs a chart directly with Helm, which
+// unlike ApplicationComponent does not require Argo CD.
+// Values are read from config.yaml under helm.values.<name>.
+type HelmComponent struct {
+	Name          string
+	Chart         string // Path relative to the installer's ChartsDir such as controller, or chart name in RepoURL
+	RepoURL       string // Optional chart repository
+	Version       string // Optional chart version
+	Namespace     string // Namespace of the release (default: Name)
+	Dependencies  []string
+	CRDs          []string
+	CRDManifests  []string // Applied before the chart, for charts that don't ship their CRDs. URLs, or paths like Chart.
+	Namespaces    []string // Namespaces besides the release namespace
+	Values        map[string]interface{}
+	ImageValues   map[string]string // Helm values holding images, which are redirected to the mirror registry
+	PreInstall    func(ctx context.Context, s *Installer) error
+	PostInstall   func(ctx context.Context, s *Installer) error
+	PreUninstall  func(ctx context.Context, s *Installer) error
+	PostUninstall func(ctx context.Context, s *Installer) error
+}
+
+func (c *HelmComponent) init() {
+	if c.Namespace == "" {
+		c.Namespace = c.Name
+	}
+}
+
+func (c *HelmComponent) GetName() string {
+	return c.Name
+}
+
+func (c *HelmComponent) GetDependencies() []string {
+	return c.Dependencies
+}
+
+func (c *HelmComponent) GetCRDs() []string {
+	return c.CRDs
+}
+
+func (c *HelmComponent) GetNamespaces() []string {
+	namespaces := []string{c.Namespace}
+	for _, namespace := range c.Namespaces {
+		if namespace != c.Namespace {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// values returns the chart's values merged with those from
+// config.yaml, which take precedence
+func (c *HelmComponent) values(s *Installer) map[string]interface{} {
+	values, ok := normalizeValues(c.Values).(map[string]interface{})
+	if !ok {
+		values = make(map[string]interface{})
+	}
+	if config, ok := normalizeValues(EffectiveConfig().Helm.Values[c.Name]).(map[string]interface{}); ok {
+		mergeValues(values, config)
+	}
+	if s.Registry != "" {
+		for key, image := range c.ImageValues {
+			setValue(values, key, s.mirrorImage(image))
+		}
+	}
+	return values
+}
+
+func mergeValues(dst map[string]interface{}, src map[string]interface{}) {
+	for key, value := range src {
+		if nested, ok := value.(map[string]interface{}); ok {
+			if existing, ok := dst[key].(map[string]interface{}); ok {
+				mergeValues(existing, nested)
+				continue
+			}
+		}
+		dst[key] = value
+	}
+}
+
+// localPath resolves a path of the component against the
+// installer's ChartsDir. URLs and absolute paths are kept.
+func (c *HelmComponent) localPath(s *Installer, path string) string {
+	if strings.Contains(path, "://") || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(s.ChartsDir, path)
+}
+
+// chart returns the chart as passed to Helm, checking that a
+// local chart exists so that a missing checkout is reported
+// before anything is changed
+func (c *HelmComponent) chart(s *Installer) (string, error) {
+	if c.RepoURL != "" {
+		return c.Chart, nil
+	}
+	chart := c.localPath(s, c.Chart)
+	if _, err := os.Stat(chart); os.IsNotExist(err) && !s.DryRun {
+		return "", fmt.Errorf("chart %s not found, set helm.chartsDir to the charts directory of a checkout of %s", chart, s.RepoURL)
+	} else if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return chart, nil
+}
+
+func (c *HelmComponent) RunInstall(ctx context.Context, s *Installer) error {
+	chart, err := c.chart(s)
+	if err != nil {
+		return err
+	}
+	if c.PreInstall != nil {
+		if err := c.PreInstall(ctx, s); err != nil {
+			return err
+		}
+	}
+	for _, namespace := range c.GetNamespaces() {
+		if err := s.createNamespace(ctx, namespace); err != nil {
+			return err
+		}
+	}
+	for _, manifest := range c.CRDManifests {
+		if err := s.exec(ctx, "kubectl apply -f %s", c.localPath(s, manifest)); err != nil {
+			return err
+		}
+	}
+	if len(c.CRDManifests) > 0 {
+		if err := s.waitForCRDs(ctx, c.CRDs); err != nil {
+			return err
+		}
+	}
+	release := &HelmRelease{
+		Name:      c.Name,
+		Namespace: c.Namespace,
+		Chart:     chart,
+		RepoURL:   c.RepoURL,
+		Version:   c.Version,
+		Values:    c.values(s),
+		Timeout:   s.ComponentTimeout,
+	}
+	var flags string
+	if c.RepoURL != "" {
+		flags += " --repo " + c.RepoURL
+	}
+	if c.Version != "" {
+		flags += " --version " + c.Version
+	}
+	for _, value := range flattenValues(release.Values) {
+		flags += " --set " + value
+	}
+	if err := s.mutate(ctx, func() error {
+		return s.Helm.Upgrade(ctx, release)
+	}, "helm upgrade --install %s %s --namespace %s --wait%s", c.Name, chart, c.Namespace, flags); err != nil {
+		return err
+	}
+	if c.PostInstall != nil {
+		if err := c.PostInstall(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *HelmComponent) RunUninstall(ctx context.Context, s *Installer) error {
+	if c.PreUninstall != nil {
+		if err := c.PreUninstall(ctx, s); err != nil {
+			return err
+		}
+	}
+	if err := s.mutate(ctx, func() error {
+		if err := s.Helm.Uninstall(ctx, c.Name, c.Namespace); err != nil {
+			if err != ErrReleaseNotFound || !s.IgnoreDeleteNotFound {
+				return err
+			}
+		}
+		return nil
+	}, "helm uninstall %s --namespace %s", c.Name, c.Namespace); err != nil {
+		return err
+	}
+	if err := s.AsyncDelete(ctx, "namespace", c.GetNamespaces()); err != nil {
+		return err
+	}
+	if c.PostUninstall != nil {
+		if err := c.PostUninstall(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Install modes select how ApplicationComponents are deployed
+const (
+	InstallModeArgoCD = "argocd" // As Argo CD Applications
+	InstallModeHelm   = "helm"   // As Helm releases, without Argo CD
+)
+
+// Graph returns the dependency graph of the components that
+// are installed in the installer's mode. In Helm mode, every
+// ApplicationComponent is replaced by its Helm alternative,
+// and components that can't be installed without Argo CD are
+// left out.
+func (s *Installer) Graph() (*Graph, error) {
+	switch s.Mode {
+	case "", InstallModeArgoCD:
+		return BuildGraph()
+	case InstallModeHelm:
+	default:
+		return nil, fmt.Errorf("unknown install mode '%s' (expected %s or %s)", s.Mode, InstallModeArgoCD, InstallModeHelm)
+	}
+	componentsL.Lock()
+	registered := append([]Component{}, components...)
+	componentsL.Unlock()
+	var candidates []Component
+	omitted := map[string]bool{"argocd": true}
+	for _, comp := range registered {
+		if comp.GetName() == "argocd" {
+			continue
+		}
+		if app, ok := comp.(*ApplicationComponent); ok {
+			if app.Helm == nil {
+				omitted[comp.GetName()] = true
+				continue
+			}
+			comp = app.Helm
+		}
+		candidates = append(candidates, comp)
+	}
+	// Leave out everything that depends on what was left out
+	for changed := true; changed; {
+		changed = false
+		for _, comp := range candidates {
+			if omitted[comp.GetName()] {
+				continue
+			}
+			for _, dep := range comp.GetDependencies() {
+				if omitted[dep] {
+					omitted[comp.GetName()] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	var comps []Component
+	for _, comp := range candidates {
+		if !omitted[comp.GetName()] {
+			comps = append(comps, comp)
+		}
+	}
+	if s.Verbose {
+		var left []string
+		for _, comp := range registered {
+			if omitted[comp.GetName()] {
+				left = append(left, comp.GetName())
+			}
+		}
+		log.Printf("Components requiring Argo CD are left out in helm mode: %s", strings.Join(left, ", "))
+	}
+	return NewGraph(comps)
+}
@@ -0,0 +1,93 @@
+package installer
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// helmModeCRDs returns the CRDs of the helm mode components as
+// if kubectl had applied their manifests
+func helmModeCRDs() []runtime.Object {
+	var crds []runtime.Object
+	for _, comp := range components {
+		if c, ok := comp.(*ApplicationComponent); ok && c.Helm != nil {
+			for _, name := range c.Helm.CRDs {
+				crds = append(crds, establishedCRD(name))
+			}
+		}
+	}
+	return crds
+}
+
+func TestHelmModeGraph(t *testing.T) {
+	install, _ := newFakeInstaller()
+	install.Mode = InstallModeHelm
+	g, err := install.Graph()
+	require.NoError(t, err)
+	names := componentNames(g.Components())
+	assert.NotContains(t, names, "argocd")
+	require.Contains(t, names, "foldy")
+	for _, comp := range g.Components() {
+		assert.NotContains(t, comp.GetDependencies(), "argocd")
+	}
+	install.Mode = "kustomize"
+	_, err = install.Graph()
+	assert.Error(t, err)
+}
+
+func TestHelmModeInstall(t *testing.T) {
+	viper.Set("helm.values.foldy", map[interface{}]interface{}{
+		"replicas": 2,
+	})
+	defer viper.Set("helm.values.foldy", nil)
+	install, executor := newFakeInstaller(helmModeCRDs()...)
+	defer install.Reuse()
+	install.Mode = InstallModeHelm
+	install.Registry = "registry.internal"
+	helm := install.Helm.(*FakeHelmClient)
+	require.NoError(t, install.InstallComponentsByName(context.TODO(), []string{"foldy"}))
+	assert.Equal(t, -1, executor.Index(`argocd`), "Argo CD must not be used")
+	assert.NotEqual(t, -1, executor.Index(`^kubectl apply -f `+regexp.QuoteMeta(testChartsDir+"/apps/crds/app.foldy.dev_models_crd.yaml")+`$`))
+	require.Contains(t, helm.Releases, "foldy/foldy")
+	release := helm.Releases["foldy/foldy"]
+	assert.Equal(t, testChartsDir+"/controller", release.Chart)
+	assert.Equal(t, map[string]interface{}{
+		"image":    "registry.internal/foldy/foldy-controller:latest",
+		"replicas": 2,
+	}, release.Values)
+
+	install.Reuse()
+	require.NoError(t, install.UninstallComponentsByName(context.TODO(), []string{"foldy"}))
+	assert.Empty(t, helm.Releases)
+}
+
+func TestHelmModeDryRun(t *testing.T) {
+	install, _ := newFakeInstaller()
+	defer install.Reuse()
+	install.Mode = InstallModeHelm
+	install.DryRun = true
+	require.NoError(t, install.InstallComponentsByName(context.TODO(), []string{"foldy"}))
+	var commands []string
+	for _, step := range install.Plan.Steps {
+		commands = append(commands, step.Command)
+	}
+	assert.Contains(t, commands, "helm upgrade --install foldy "+testChartsDir+"/controller --namespace foldy --wait")
+	assert.Empty(t, install.Helm.(*FakeHelmClient).Releases)
+}
+
+func TestHelmModeChartsDir(t *testing.T) {
+	install, executor := newFakeInstaller(helmModeCRDs()...)
+	defer install.Reuse()
+	install.Mode = InstallModeHelm
+	install.ChartsDir = "missing"
+	err := install.InstallComponentsByName(context.TODO(), []string{"foldy"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "chart missing/controller not found, set helm.chartsDir")
+	assert.Empty(t, executor.Commands, "nothing may be changed without the chart")
+}
@@ -0,0 +1,473 @@
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/homedir"
+	"sigs.k8s.io/yaml"
+)
+
+// configFile is the config.yaml that was loaded by
+// ConfigureViper, if any
+var configFile string
+
+// DefaultConfigFile returns the config.yaml loaded by
+// ConfigureViper, or ~/.foldy/config.yaml if there wasn't one
+func DefaultConfigFile() string {
+	if configFile != "" {
+		return configFile
+	}
+	return filepath.Join(homedir.HomeDir(), ".foldy", "config.yaml")
+}
+
+// Config is the schema of config.yaml. Keys that aren't part
+// of it are rejected, so that a typo doesn't silently fall
+// back to the default. See examples/config.yaml.
+type Config struct {
+	Endpoint             string            `json:"endpoint,omitempty"` // API endpoint for the CLI
+	Verbose              bool              `json:"verbose,omitempty"`
+	ShowSecrets          bool              `json:"showSecrets,omitempty"`
+	Mode                 string            `json:"mode,omitempty"` // argocd or helm
+	Timeout              Duration          `json:"timeout,omitempty"`
+	ComponentTimeout     Duration          `json:"componentTimeout,omitempty"`
+	ApplicationTimeout   Duration          `json:"applicationTimeout,omitempty"`
+	Parallelism          int               `json:"parallelism,omitempty"`
+	Kubeconfig           string            `json:"kubeconfig,omitempty"`
+	Context              string            `json:"context,omitempty"`
+	ArgoCD               ArgoCDConfig      `json:"argocd,omitempty"`
+	Images               ImagesConfig      `json:"images,omitempty"`
+	Helm                 HelmConfig        `json:"helm,omitempty"`
+	Ingress              IngressConfig     `json:"ingress,omitempty"`
+	CertManager          CertManagerConfig `json:"certmanager,omitempty"`
+	CI                   CIConfig          `json:"ci,omitempty"`
+	CommunityAPIEndpoint string            `json:"communityApiEndpoint,omitempty"` // Used by ingress.auto
+}
+
+type ArgoCDConfig struct {
+	Image string `json:"image,omitempty"` // Must include Helm v3+
+}
+
+type ImagesConfig struct {
+	Registry string `json:"registry,omitempty"` // Mirror for every image
+}
+
+type HelmConfig struct {
+	Values    map[string]interface{} `json:"values,omitempty"`    // By component, for helm mode
+	ChartsDir string                 `json:"chartsDir,omitempty"` // The charts directory of a foldy checkout, for helm mode (default: ./charts)
+}
+
+type IngressConfig struct {
+	Enabled  bool                 `json:"enabled,omitempty"`
+	Insecure bool                 `json:"insecure,omitempty"` // Disables TLS for every service
+	Auto     bool                 `json:"auto,omitempty"`     // Request a name from communityApiEndpoint
+	Email    string               `json:"email,omitempty"`    // Let's Encrypt account, notified about expiring certificates
+	API      ServiceIngressConfig `json:"api,omitempty"`
+	ArgoCD   ServiceIngressConfig `json:"argocd,omitempty"`
+	Events   ServiceIngressConfig `json:"events,omitempty"`
+	UI       ServiceIngressConfig `json:"ui,omitempty"`
+}
+
+// ServiceIngressConfig exposes a single service
+type ServiceIngressConfig struct {
+	Enabled  bool   `json:"enabled,omitempty"`
+	Insecure bool   `json:"insecure,omitempty"`
+	Host     string `json:"host,omitempty"` // Overrides the name from ingress.auto
+}
+
+type CertManagerConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+type CIConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+// Duration is a time.Duration that is written as a string
+// such as "10m" in config.yaml
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	case float64:
+		// Seconds, as in 'timeout: 600'
+		*d = Duration(time.Duration(v) * time.Second)
+	case nil:
+		*d = 0
+	default:
+		return fmt.Errorf("invalid duration %v", v)
+	}
+	return nil
+}
+
+// Validate returns every problem with the config's values
+func (c *Config) Validate() error {
+	var multi error
+	if c.Mode != "" && c.Mode != InstallModeArgoCD && c.Mode != InstallModeHelm {
+		multi = multierror.Append(multi, fmt.Errorf("mode: must be %s or %s, not '%s'", InstallModeArgoCD, InstallModeHelm, c.Mode))
+	}
+	if c.Timeout < 0 {
+		multi = multierror.Append(multi, fmt.Errorf("timeout: must not be negative"))
+	}
+	if c.ComponentTimeout < 0 {
+		multi = multierror.Append(multi, fmt.Errorf("componentTimeout: must not be negative"))
+	}
+	if c.ApplicationTimeout < 0 {
+		multi = multierror.Append(multi, fmt.Errorf("applicationTimeout: must not be negative"))
+	}
+	if c.Parallelism < 0 {
+		multi = multierror.Append(multi, fmt.Errorf("parallelism: must not be negative"))
+	}
+	if err := validateURL("endpoint", c.Endpoint); err != nil {
+		multi = multierror.Append(multi, err)
+	}
+	if err := validateURL("communityApiEndpoint", c.CommunityAPIEndpoint); err != nil {
+		multi = multierror.Append(multi, err)
+	}
+	if registry := c.Images.Registry; strings.Contains(registry, "://") || strings.HasSuffix(registry, "/") {
+		multi = multierror.Append(multi, fmt.Errorf("images.registry: must be a host and optional path such as registry.internal/foldy, not '%s'", registry))
+	}
+	services := c.Ingress.Services()
+	for _, name := range IngressServices {
+		service, key := services[name], "ingress."+name
+		if service.Host != "" {
+			for _, msg := range validation.IsDNS1123Subdomain(service.Host) {
+				multi = multierror.Append(multi, fmt.Errorf("%s.host: %s", key, msg))
+			}
+		}
+		if c.Ingress.Enabled && service.Enabled && service.Host == "" && !c.Ingress.Auto {
+			multi = multierror.Append(multi, fmt.Errorf("%s.host: required unless ingress.auto is true", key))
+		}
+	}
+	if email := c.Ingress.Email; email != "" && !strings.Contains(email, "@") {
+		multi = multierror.Append(multi, fmt.Errorf("ingress.email: '%s' is not an email address", email))
+	}
+	if c.Ingress.Enabled && c.Ingress.Auto && c.CommunityAPIEndpoint == "" {
+		multi = multierror.Append(multi, fmt.Errorf("ingress.auto: requires communityApiEndpoint"))
+	}
+	return multi
+}
+
+// validateURL checks that a non-empty setting is an http or
+// https URL
+func validateURL(key string, value string) error {
+	if value == "" {
+		return nil
+	}
+	if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s: '%s' is not an http or https URL", key, value)
+	}
+	return nil
+}
+
+// IngressServices are the services that can be exposed by
+// ingress, in the order they are configured
+var IngressServices = []string{"api", "argocd", "events", "ui"}
+
+// Services returns the per-service ingress settings by name
+func (c *IngressConfig) Services() map[string]*ServiceIngressConfig {
+	return map[string]*ServiceIngressConfig{
+		"api":    &c.API,
+		"argocd": &c.ArgoCD,
+		"events": &c.Events,
+		"ui":     &c.UI,
+	}
+}
+
+// ParseConfig parses and validates the contents of a
+// config.yaml. Unknown keys are errors.
+func ParseConfig(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// LoadConfigFile parses and validates a config.yaml. A file
+// that doesn't exist yields an empty config.
+func LoadConfigFile(file string) (*Config, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	config, err := ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return config, nil
+}
+
+// ConfigKey is a single setting of the Config, such as
+// ingress.api.host
+type ConfigKey struct {
+	Name  string // Dotted path, as used by viper
+	Env   string // Environment variable overriding it
+	index []int
+	typ   reflect.Type
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// ConfigKeys returns every setting of the Config, sorted
+func ConfigKeys() []*ConfigKey {
+	var keys []*ConfigKey
+	var walk func(t reflect.Type, prefix string, index []int)
+	walk = func(t reflect.Type, prefix string, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := prefix + strings.Split(field.Tag.Get("json"), ",")[0]
+			fieldIndex := append(append([]int{}, index...), i)
+			if field.Type.Kind() == reflect.Struct {
+				walk(field.Type, name+".", fieldIndex)
+				continue
+			}
+			keys = append(keys, &ConfigKey{
+				Name:  name,
+				Env:   configEnv(name),
+				index: fieldIndex,
+				typ:   field.Type,
+			})
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "", nil)
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys
+}
+
+// LookupConfigKey returns the setting with the name, which is
+// matched case-insensitively like viper does
+func LookupConfigKey(name string) (*ConfigKey, error) {
+	for _, key := range ConfigKeys() {
+		if strings.EqualFold(key.Name, name) {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown config key '%s'", name)
+}
+
+// configEnv returns the environment variable for the key,
+// e.g. FOLDY_INGRESS_API_HOST for ingress.api.host
+func configEnv(name string) string {
+	var b strings.Builder
+	b.WriteString("FOLDY")
+	for _, part := range strings.Split(name, ".") {
+		b.WriteByte('_')
+		for i, r := range part {
+			if i > 0 && unicode.IsUpper(r) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// bindConfigEnv lets the environment override every scalar
+// setting of the Config
+func bindConfigEnv() {
+	for _, key := range ConfigKeys() {
+		if key.typ.Kind() != reflect.Map {
+			viper.BindEnv(key.Name, key.Env)
+		}
+	}
+}
+
+// Value returns the effective value of the setting, from the
+// flags, environment, config file or defaults known to viper
+func (k *ConfigKey) Value() interface{} {
+	switch {
+	case k.typ == durationType:
+		return Duration(viper.GetDuration(k.Name))
+	case k.typ.Kind() == reflect.Bool:
+		return viper.GetBool(k.Name)
+	case k.typ.Kind() == reflect.Int:
+		return viper.GetInt(k.Name)
+	case k.typ.Kind() == reflect.String:
+		return viper.GetString(k.Name)
+	}
+	value, _ := normalizeValues(viper.Get(k.Name)).(map[string]interface{})
+	return value
+}
+
+// Parse converts a command-line value to the setting's type
+func (k *ConfigKey) Parse(value string) (interface{}, error) {
+	switch {
+	case k.typ == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", k.Name, err)
+		}
+		return d.String(), nil
+	case k.typ.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: '%s' is not a boolean", k.Name, value)
+		}
+		return b, nil
+	case k.typ.Kind() == reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: '%s' is not an integer", k.Name, value)
+		}
+		return i, nil
+	case k.typ.Kind() == reflect.String:
+		return value, nil
+	}
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(value), &v); err != nil {
+		return nil, fmt.Errorf("%s: %v", k.Name, err)
+	}
+	return v, nil
+}
+
+// Where a setting's value came from
+const (
+	ConfigSourceFlag    = "flag"
+	ConfigSourceEnv     = "env"
+	ConfigSourceFile    = "file"
+	ConfigSourceDefault = "default"
+)
+
+// Source returns where the effective value of the setting
+// came from. flagChanged tells if it was given on the command
+// line, which only the command knows.
+func (k *ConfigKey) Source(flagChanged bool) string {
+	if flagChanged {
+		return ConfigSourceFlag
+	}
+	if _, ok := os.LookupEnv(k.Env); ok {
+		return ConfigSourceEnv
+	}
+	if inConfigFile(k.Name) {
+		return ConfigSourceFile
+	}
+	return ConfigSourceDefault
+}
+
+// inConfigFile returns true if the config file loaded by
+// ConfigureViper sets the key. Keys are matched
+// case-insensitively like viper does.
+func inConfigFile(name string) bool {
+	if configFile == "" {
+		return false
+	}
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return false
+	}
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return false
+	}
+next:
+	for _, part := range strings.Split(name, ".") {
+		settings, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, v := range settings {
+			if strings.EqualFold(key, part) {
+				value = v
+				continue next
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// EffectiveConfig returns the merged config from the flags,
+// environment, config file and defaults
+func EffectiveConfig() *Config {
+	config := &Config{}
+	v := reflect.ValueOf(config).Elem()
+	for _, key := range ConfigKeys() {
+		v.FieldByIndex(key.index).Set(reflect.ValueOf(key.Value()))
+	}
+	return config
+}
+
+// SetConfigValue sets the key to the value in the config file,
+// creating it if needed. The result must pass validation.
+// Comments in the file are not preserved.
+func SetConfigValue(file string, name string, value string) error {
+	key, err := LookupConfigKey(name)
+	if err != nil {
+		return err
+	}
+	parsed, err := key.Parse(value)
+	if err != nil {
+		return err
+	}
+	settings := make(map[string]interface{})
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	if settings == nil {
+		settings = make(map[string]interface{})
+	}
+	setValue(settings, key.Name, parsed)
+	if data, err = yaml.Marshal(settings); err != nil {
+		return err
+	}
+	if _, err := ParseConfig(data); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(file), ".config-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
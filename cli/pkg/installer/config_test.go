@@ -0,0 +1,117 @@
+package installer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExampleConfig(t *testing.T) {
+	for _, file := range []string{
+		"../../../examples/config.yaml",
+		"../../cmd/foldy/config.yaml",
+	} {
+		config, err := LoadConfigFile(file)
+		require.NoError(t, err, file)
+		assert.True(t, config.Verbose, file)
+		assert.Equal(t, "argoproj/argocd:v1.5.0-rc1", config.ArgoCD.Image, file)
+	}
+	config, err := LoadConfigFile("../../../examples/config.yaml")
+	require.NoError(t, err)
+	assert.True(t, config.Ingress.Auto)
+	assert.False(t, config.Ingress.Events.Enabled)
+	assert.Equal(t, "https://community.foldy.dev", config.CommunityAPIEndpoint)
+}
+
+func TestParseConfigUnknownKey(t *testing.T) {
+	_, err := ParseConfig([]byte("ingres:\n  enabled: true\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown field "ingres"`)
+
+	_, err = ParseConfig([]byte("ingress:\n  api:\n    hostname: api.example.com\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown field "hostname"`)
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	_, err := ParseConfig([]byte(`
+mode: kubectl
+componentTimeout: -1m
+communityApiEndpoint: community.foldy.dev
+ingress:
+  enabled: true
+  api:
+    enabled: true
+    host: API_example
+  ui:
+    enabled: true
+`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "mode: must be argocd or helm")
+	assert.Contains(t, err.Error(), "componentTimeout: must not be negative")
+	assert.Contains(t, err.Error(), "communityApiEndpoint: 'community.foldy.dev' is not an http or https URL")
+	assert.Contains(t, err.Error(), "ingress.api.host: a DNS-1123 subdomain")
+	assert.Contains(t, err.Error(), "ingress.ui.host: required unless ingress.auto is true")
+
+	_, err = ParseConfig([]byte("timeout: soon\n"))
+	assert.Error(t, err)
+}
+
+func TestParseConfigDuration(t *testing.T) {
+	config, err := ParseConfig([]byte("componentTimeout: 15m\napplicationTimeout: 90\n"))
+	require.NoError(t, err)
+	assert.Equal(t, Duration(15*time.Minute), config.ComponentTimeout)
+	assert.Equal(t, Duration(90*time.Second), config.ApplicationTimeout)
+}
+
+func TestConfigKeys(t *testing.T) {
+	key, err := LookupConfigKey("ingress.api.host")
+	require.NoError(t, err)
+	assert.Equal(t, "FOLDY_INGRESS_API_HOST", key.Env)
+	key, err = LookupConfigKey("communityapiendpoint")
+	require.NoError(t, err)
+	assert.Equal(t, "communityApiEndpoint", key.Name)
+	assert.Equal(t, "FOLDY_COMMUNITY_API_ENDPOINT", key.Env)
+	_, err = LookupConfigKey("ingress.api")
+	assert.Error(t, err)
+}
+
+func TestConfigSourceEnv(t *testing.T) {
+	bindConfigEnv()
+	os.Setenv("FOLDY_INGRESS_API_HOST", "api.example.com")
+	defer os.Unsetenv("FOLDY_INGRESS_API_HOST")
+	key, err := LookupConfigKey("ingress.api.host")
+	require.NoError(t, err)
+	assert.Equal(t, "api.example.com", key.Value())
+	assert.Equal(t, ConfigSourceEnv, key.Source(false))
+	assert.Equal(t, ConfigSourceFlag, key.Source(true))
+	assert.Equal(t, "api.example.com", EffectiveConfig().Ingress.API.Host)
+}
+
+func TestSetConfigValue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "foldy-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.yaml")
+
+	require.NoError(t, SetConfigValue(file, "ingress.api.host", "api.example.com"))
+	require.NoError(t, SetConfigValue(file, "componentTimeout", "15m"))
+	require.NoError(t, SetConfigValue(file, "parallelism", "8"))
+	config, err := LoadConfigFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "api.example.com", config.Ingress.API.Host)
+	assert.Equal(t, Duration(15*time.Minute), config.ComponentTimeout)
+	assert.Equal(t, 8, config.Parallelism)
+
+	assert.Error(t, SetConfigValue(file, "ingres.enabled", "true"))
+	assert.Error(t, SetConfigValue(file, "parallelism", "many"))
+	assert.Error(t, SetConfigValue(file, "mode", "kubectl"))
+	config, err = LoadConfigFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "", config.Mode)
+}
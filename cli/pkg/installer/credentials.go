@@ -0,0 +1,179 @@
+package installer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/homedir"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// credentialsFile is the credentials.yaml that was loaded by
+// ConfigureViper, if any
+var credentialsFile string
+
+// DefaultCredentialsFile returns the credentials.yaml loaded
+// by ConfigureViper, or ~/.foldy/credentials.yaml if there
+// wasn't one
+func DefaultCredentialsFile() string {
+	if credentialsFile != "" {
+		return credentialsFile
+	}
+	return filepath.Join(homedir.HomeDir(), ".foldy", "credentials.yaml")
+}
+
+// Credentials are the secrets kept in credentials.yaml,
+// which is only readable by the current user
+type Credentials struct {
+	Username        string     `json:"username,omitempty"`
+	Password        string     `json:"password,omitempty"`
+	CurrentEndpoint string     `json:"currentEndpoint,omitempty"` // Endpoint of the session used by default
+	Sessions        []*Session `json:"sessions,omitempty"`        // Obtained with 'foldy login'
+}
+
+// LoadCredentials reads the credentials file. A file that
+// doesn't exist yields empty credentials.
+func LoadCredentials(file string) (*Credentials, error) {
+	creds := &Credentials{}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return creds, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, creds); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return creds, nil
+}
+
+// SaveCredentials replaces the credentials file atomically,
+// so that it is never left partially written. The file is
+// created with 0600 permissions.
+func SaveCredentials(file string, creds *Credentials) error {
+	data, err := yaml.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	// The temporary file has to be on the same filesystem
+	// for the rename to be atomic
+	f, err := ioutil.TempFile(dir, ".credentials-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
+
+// GeneratePassword returns a random password with 192 bits
+// of entropy
+func GeneratePassword() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// EnsurePassword returns the password from the credentials
+// file, generating and saving a new one if it doesn't have
+// one yet. Nothing is saved in dry-run mode.
+func EnsurePassword(file string, dryRun bool) (password string, generated bool, err error) {
+	creds, err := LoadCredentials(file)
+	if err != nil {
+		return "", false, err
+	}
+	if creds.Password != "" {
+		return creds.Password, false, nil
+	}
+	if creds.Password, err = GeneratePassword(); err != nil {
+		return "", false, err
+	}
+	if !dryRun {
+		if err := SaveCredentials(file, creds); err != nil {
+			return "", false, err
+		}
+	}
+	return creds.Password, true, nil
+}
+
+// RotatePassword changes the Argo CD admin password to a new
+// random one. The credentials file is updated first and then
+// argocd-secret is patched. If patching fails, the previous
+// credentials are restored so that the file keeps matching
+// the cluster.
+func (s *Installer) RotatePassword(ctx context.Context, file string) error {
+	creds, err := LoadCredentials(file)
+	if err != nil {
+		return err
+	}
+	password, err := GeneratePassword()
+	if err != nil {
+		return err
+	}
+	hash := HashPassword(password)
+	shownHash := "${PASSWORD_HASH}"
+	if s.ShowSecrets {
+		shownHash = hash
+	}
+	mtime := time.Now().UTC().Format(time.RFC3339)
+	return s.mutate(ctx, func() error {
+		rotated := *creds
+		rotated.Password = password
+		if err := SaveCredentials(file, &rotated); err != nil {
+			return err
+		}
+		secret := &corev1.Secret{}
+		err := s.client.Get(ctx, types.NamespacedName{Name: "argocd-secret", Namespace: "argocd"}, secret)
+		if err == nil {
+			patch := client.MergeFrom(secret.DeepCopy())
+			if secret.Data == nil {
+				secret.Data = make(map[string][]byte)
+			}
+			secret.Data["admin.password"] = []byte(hash)
+			// Sessions issued before this time are invalidated
+			secret.Data["admin.passwordMtime"] = []byte(mtime)
+			err = s.client.Patch(ctx, secret, patch)
+		}
+		if err != nil {
+			if restoreErr := SaveCredentials(file, creds); restoreErr != nil {
+				return fmt.Errorf("%v (failed to restore %s: %v)", err, file, restoreErr)
+			}
+			return err
+		}
+		s.Password = password
+		if _, ok := s.ArgoCD.(*ArgoCDAPIClient); ok {
+			// Log in again with the new password
+			s.ArgoCD = nil
+		}
+		return nil
+	}, `kubectl -n argocd patch secret argocd-secret -p '{"stringData":{"admin.password": "%s","admin.passwordMtime": "%s"}}'`, shownHash, mtime)
+}
@@ -0,0 +1,91 @@
+package installer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func tempCredentialsFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "foldy-test")
+	require.NoError(t, err)
+	return filepath.Join(dir, "credentials.yaml"), func() { os.RemoveAll(dir) }
+}
+
+func TestEnsurePassword(t *testing.T) {
+	file, cleanup := tempCredentialsFile(t)
+	defer cleanup()
+	password, generated, err := EnsurePassword(file, false)
+	require.NoError(t, err)
+	assert.True(t, generated)
+	assert.Len(t, password, 32)
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	again, generated, err := EnsurePassword(file, false)
+	require.NoError(t, err)
+	assert.False(t, generated)
+	assert.Equal(t, password, again)
+}
+
+func TestEnsurePasswordDryRun(t *testing.T) {
+	file, cleanup := tempCredentialsFile(t)
+	defer cleanup()
+	_, generated, err := EnsurePassword(file, true)
+	require.NoError(t, err)
+	assert.True(t, generated)
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err), "nothing may be saved in dry-run mode")
+}
+
+func TestRotatePassword(t *testing.T) {
+	file, cleanup := tempCredentialsFile(t)
+	defer cleanup()
+	require.NoError(t, SaveCredentials(file, &Credentials{Username: "admin", Password: "password"}))
+	install, _ := newFakeInstaller(healthyArgoCD("password")...)
+	require.NoError(t, install.RotatePassword(context.TODO(), file))
+
+	creds, err := LoadCredentials(file)
+	require.NoError(t, err)
+	assert.Equal(t, "admin", creds.Username)
+	assert.NotEqual(t, "password", creds.Password)
+	assert.Equal(t, creds.Password, install.Password)
+	secret := &corev1.Secret{}
+	require.NoError(t, install.client.Get(context.TODO(), types.NamespacedName{Name: "argocd-secret", Namespace: "argocd"}, secret))
+	assert.True(t, ComparePasswordHash(creds.Password, secret.Data["admin.password"]))
+	assert.NotEmpty(t, secret.Data["admin.passwordMtime"])
+}
+
+func TestRotatePasswordRestoresCredentials(t *testing.T) {
+	file, cleanup := tempCredentialsFile(t)
+	defer cleanup()
+	require.NoError(t, SaveCredentials(file, &Credentials{Password: "password"}))
+	// Without argocd-secret, patching fails
+	install, _ := newFakeInstaller()
+	require.Error(t, install.RotatePassword(context.TODO(), file))
+	creds, err := LoadCredentials(file)
+	require.NoError(t, err)
+	assert.Equal(t, "password", creds.Password)
+}
+
+func TestRotatePasswordDryRun(t *testing.T) {
+	file, cleanup := tempCredentialsFile(t)
+	defer cleanup()
+	require.NoError(t, SaveCredentials(file, &Credentials{Password: "password"}))
+	install, _ := newFakeInstaller(healthyArgoCD("password")...)
+	install.DryRun = true
+	require.NoError(t, install.RotatePassword(context.TODO(), file))
+	require.Len(t, install.Plan.Steps, 1)
+	assert.Contains(t, install.Plan.Steps[0].Command, `"admin.password": "${PASSWORD_HASH}"`)
+	creds, err := LoadCredentials(file)
+	require.NoError(t, err)
+	assert.Equal(t, "password", creds.Password)
+}
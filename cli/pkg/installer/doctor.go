@@ -0,0 +1,466 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Masterminds/semver/v3"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// MinKubernetesVersion is the oldest Kubernetes version that
+// the components are known to work with. It is the first to
+// serve CRDs and webhook configurations at v1.
+const MinKubernetesVersion = "1.16.0"
+
+// MaxKubernetesVersion is the newest minor version that still
+// serves the beta APIs used by the charts, such as ingresses
+// at extensions/v1beta1, which were removed in 1.22
+const MaxKubernetesVersion = "1.21"
+
+// CheckResult is the outcome of a preflight check
+type CheckResult string
+
+const (
+	CheckPass CheckResult = "pass"
+	CheckWarn CheckResult = "warn" // Installing may work, but likely needs attention
+	CheckFail CheckResult = "fail" // Installing will fail
+)
+
+// Check is a single preflight check performed by Doctor
+type Check struct {
+	Name    string      `json:"name"`
+	Result  CheckResult `json:"result"`
+	Message string      `json:"message"`
+	Remedy  string      `json:"remedy,omitempty"` // What to do about a warning or failure
+}
+
+// PreflightError is returned by InstallAll if a preflight
+// check failed
+type PreflightError struct {
+	Failed []*Check
+}
+
+func (e *PreflightError) Error() string {
+	msg := "preflight checks failed (run 'foldy doctor' for details, or install with --skip-doctor):"
+	for _, check := range e.Failed {
+		msg += fmt.Sprintf("\n  %s: %s", check.Name, check.Message)
+		if check.Remedy != "" {
+			msg += " (" + check.Remedy + ")"
+		}
+	}
+	return msg
+}
+
+// accessRequirement is something the installer does through
+// the API server
+type accessRequirement struct {
+	Verb        string
+	Group       string
+	Resource    string
+	Subresource string
+	Namespace   string
+}
+
+// requiredAccess lists every kind of change the installer
+// makes in its mode, which Doctor verifies with
+// SelfSubjectAccessReviews
+func (s *Installer) requiredAccess(components []Component) []accessRequirement {
+	access := []accessRequirement{
+		{"create", "", "namespaces", "", ""},
+		{"delete", "", "namespaces", "", ""},
+		{"create", "apiextensions.k8s.io", "customresourcedefinitions", "", ""},
+		{"patch", "apiextensions.k8s.io", "customresourcedefinitions", "", ""},
+		{"delete", "apiextensions.k8s.io", "customresourcedefinitions", "", ""},
+		{"create", "", "configmaps", "", LedgerNamespace},
+		{"update", "", "configmaps", "", LedgerNamespace},
+		{"create", "rbac.authorization.k8s.io", "clusterroles", "", ""},
+		{"delete", "rbac.authorization.k8s.io", "clusterroles", "", ""},
+		{"create", "rbac.authorization.k8s.io", "clusterrolebindings", "", ""},
+		{"delete", "rbac.authorization.k8s.io", "clusterrolebindings", "", ""},
+		{"delete", "admissionregistration.k8s.io", "mutatingwebhookconfigurations", "", ""},
+		{"delete", "admissionregistration.k8s.io", "validatingwebhookconfigurations", "", ""},
+	}
+	if s.Mode != InstallModeHelm {
+		access = append(access,
+			accessRequirement{"create", "apps", "deployments", "", "argocd"},
+			accessRequirement{"patch", "apps", "deployments", "", "argocd"},
+			accessRequirement{"get", "", "secrets", "", "argocd"},
+			accessRequirement{"patch", "", "secrets", "", "argocd"},
+			accessRequirement{"update", "", "configmaps", "", "argocd"},
+			accessRequirement{"create", "", "pods", "portforward", "argocd"},
+			accessRequirement{"create", "argoproj.io", "applications", "", "argocd"},
+			accessRequirement{"patch", "argoproj.io", "applications", "", "argocd"},
+			accessRequirement{"delete", "argoproj.io", "applications", "", "argocd"},
+		)
+		if s.Registry != "" {
+			// Argo CD's workloads are pointed at the mirror
+			access = append(access,
+				accessRequirement{"patch", "apps", "statefulsets", "", "argocd"},
+				accessRequirement{"patch", "apps", "daemonsets", "", "argocd"},
+			)
+		}
+		if s.Bundle != nil {
+			// The bundle server, which the repositories are copied to
+			access = append(access,
+				accessRequirement{"create", "", "persistentvolumeclaims", "", "argocd"},
+				accessRequirement{"create", "", "services", "", "argocd"},
+				accessRequirement{"create", "", "pods", "exec", "argocd"},
+			)
+		}
+	}
+	for _, comp := range components {
+		if c, ok := comp.(*HelmComponent); ok {
+			// Helm keeps the releases in secrets
+			for _, verb := range []string{"get", "create", "update", "delete"} {
+				access = append(access, accessRequirement{verb, "", "secrets", "", c.Namespace})
+			}
+		}
+	}
+	config := EffectiveConfig()
+	if config.Ingress.Enabled {
+		certManager := config.CertManager.Enabled && !config.Ingress.Insecure
+		if certManager {
+			access = append(access,
+				accessRequirement{"create", "cert-manager.io", "clusterissuers", "", ""},
+				accessRequirement{"update", "cert-manager.io", "clusterissuers", "", ""},
+			)
+		}
+		if config.Ingress.Auto {
+			access = append(access, accessRequirement{"get", "", "services", "", "traefik"})
+		}
+		services := config.Ingress.Services()
+		for _, name := range IngressServices {
+			backend := ingressBackends[name]
+			if !services[name].Enabled || backend == nil {
+				continue
+			}
+			resources := []string{"ingressroutes.traefik.containo.us", "middlewares.traefik.containo.us"}
+			if certManager {
+				resources = append(resources, "certificates.cert-manager.io")
+			}
+			for _, resource := range resources {
+				parts := strings.SplitN(resource, ".", 2)
+				for _, verb := range []string{"create", "update"} {
+					access = append(access, accessRequirement{verb, parts[1], parts[0], "", backend.Namespace})
+				}
+			}
+		}
+	}
+	// Components may share namespaces
+	seen := make(map[accessRequirement]bool)
+	var unique []accessRequirement
+	for _, a := range access {
+		if !seen[a] {
+			seen[a] = true
+			unique = append(unique, a)
+		}
+	}
+	return unique
+}
+
+// lookPath finds executables, replaced in tests
+var lookPath = exec.LookPath
+
+// kubernetes returns the clientset used by Doctor, creating
+// one from RestConfig if there isn't one
+func (s *Installer) kubernetes() (kubernetes.Interface, error) {
+	s.kubernetesL.Lock()
+	defer s.kubernetesL.Unlock()
+	if s.Kubernetes == nil {
+		if s.RestConfig == nil {
+			return nil, fmt.Errorf("unable to run preflight checks: no Kubernetes config")
+		}
+		clientset, err := kubernetes.NewForConfig(s.RestConfig)
+		if err != nil {
+			return nil, err
+		}
+		s.Kubernetes = clientset
+	}
+	return s.Kubernetes, nil
+}
+
+// Doctor checks whether the cluster and the local machine
+// meet the requirements of installing the components. Every
+// check is performed even if an earlier one failed.
+func (s *Installer) Doctor(ctx context.Context) ([]*Check, error) {
+	clientset, err := s.kubernetes()
+	if err != nil {
+		return nil, err
+	}
+	g, err := s.Graph()
+	if err != nil {
+		return nil, err
+	}
+	checks := []*Check{s.checkServerVersion(clientset)}
+	checks = append(checks, s.checkAccess(clientset, s.requiredAccess(g.Components()))...)
+	checks = append(checks, s.checkStorageClass(clientset))
+	checks = append(checks, s.checkExecutables()...)
+	checks = append(checks, s.checkAllocatable(clientset, g.Components()))
+	return checks, nil
+}
+
+func (s *Installer) checkServerVersion(clientset kubernetes.Interface) *Check {
+	check := &Check{Name: "server version"}
+	info, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		check.Result = CheckFail
+		check.Message = fmt.Sprintf("unable to reach the API server: %v", err)
+		check.Remedy = "check that your kubeconfig and --context point at a running cluster"
+		return check
+	}
+	version, err := semver.NewVersion(info.GitVersion)
+	if err != nil {
+		check.Result = CheckWarn
+		check.Message = fmt.Sprintf("unrecognized version %s", info.GitVersion)
+		return check
+	}
+	min := semver.MustParse(MinKubernetesVersion)
+	// Ignore pre-release suffixes such as -gke.1
+	release, _ := semver.NewVersion(fmt.Sprintf("%d.%d.%d", version.Major(), version.Minor(), version.Patch()))
+	if release.LessThan(min) {
+		check.Result = CheckFail
+		check.Message = fmt.Sprintf("Kubernetes %s is older than %s", info.GitVersion, MinKubernetesVersion)
+		check.Remedy = "upgrade the cluster"
+		return check
+	}
+	max := semver.MustParse(MaxKubernetesVersion)
+	if release.Major() > max.Major() || release.Major() == max.Major() && release.Minor() > max.Minor() {
+		check.Result = CheckFail
+		check.Message = fmt.Sprintf("Kubernetes %s is newer than %s, which removed APIs that the charts use", info.GitVersion, MaxKubernetesVersion)
+		check.Remedy = fmt.Sprintf("install into a cluster running Kubernetes %s to %s", MinKubernetesVersion, MaxKubernetesVersion)
+		return check
+	}
+	check.Result = CheckPass
+	check.Message = fmt.Sprintf("Kubernetes %s", info.GitVersion)
+	return check
+}
+
+func (s *Installer) checkAccess(clientset kubernetes.Interface, required []accessRequirement) []*Check {
+	var denied []string
+	for _, access := range required {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Verb:        access.Verb,
+					Group:       access.Group,
+					Resource:    access.Resource,
+					Subresource: access.Subresource,
+					Namespace:   access.Namespace,
+				},
+			},
+		}
+		result, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(review)
+		if err != nil {
+			return []*Check{{
+				Name:    "permissions",
+				Result:  CheckFail,
+				Message: fmt.Sprintf("unable to review access: %v", err),
+			}}
+		}
+		if !result.Status.Allowed {
+			denied = append(denied, describeAccess(access))
+		}
+	}
+	if len(denied) > 0 {
+		return []*Check{{
+			Name:    "permissions",
+			Result:  CheckFail,
+			Message: "not allowed to " + strings.Join(denied, ", "),
+			Remedy:  "install as a cluster admin, e.g. kubectl create clusterrolebinding foldy-admin --clusterrole=cluster-admin --user=<you>",
+		}}
+	}
+	return []*Check{{
+		Name:    "permissions",
+		Result:  CheckPass,
+		Message: fmt.Sprintf("allowed to perform all %d kinds of changes", len(required)),
+	}}
+}
+
+func describeAccess(access accessRequirement) string {
+	resource := access.Resource
+	if access.Subresource != "" {
+		resource += "/" + access.Subresource
+	}
+	if access.Group != "" {
+		resource += "." + access.Group
+	}
+	if access.Namespace != "" {
+		return fmt.Sprintf("%s %s in %s", access.Verb, resource, access.Namespace)
+	}
+	return access.Verb + " " + resource
+}
+
+func (s *Installer) checkStorageClass(clientset kubernetes.Interface) *Check {
+	check := &Check{Name: "default storage class"}
+	classes, err := clientset.StorageV1().StorageClasses().List(metav1.ListOptions{})
+	if err != nil {
+		check.Result = CheckWarn
+		check.Message = fmt.Sprintf("unable to list storage classes: %v", err)
+		return check
+	}
+	for _, class := range classes.Items {
+		for _, key := range []string{
+			"storageclass.kubernetes.io/is-default-class",
+			"storageclass.beta.kubernetes.io/is-default-class",
+		} {
+			if class.Annotations[key] == "true" {
+				check.Result = CheckPass
+				check.Message = class.Name
+				return check
+			}
+		}
+	}
+	check.Result = CheckWarn
+	check.Message = "no default storage class, persistent volume claims will remain pending"
+	check.Remedy = "mark a storage class as default with the annotation storageclass.kubernetes.io/is-default-class=true"
+	return check
+}
+
+func (s *Installer) checkExecutables() []*Check {
+	if _, ok := s.Executor.(*BashExecutor); !ok {
+		// Commands aren't run by a shell
+		return nil
+	}
+	var checks []*Check
+	for _, name := range []string{"bash", "kubectl"} {
+		check := &Check{Name: name}
+		if path, err := lookPath(name); err != nil {
+			check.Result = CheckFail
+			check.Message = fmt.Sprintf("%s was not found in PATH", name)
+			check.Remedy = fmt.Sprintf("install %s", name)
+		} else {
+			check.Result = CheckPass
+			check.Message = path
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// componentRequests returns the CPU and memory requested by
+// the component once deployed, if known
+func componentRequests(comp Component) corev1.ResourceList {
+	switch c := comp.(type) {
+	case *ApplicationComponent:
+		return c.Requests
+	case *CustomComponent:
+		return c.Requests
+	default:
+		return nil
+	}
+}
+
+func (s *Installer) checkAllocatable(clientset kubernetes.Interface, components []Component) *Check {
+	check := &Check{Name: "allocatable resources"}
+	nodes, err := clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		check.Result = CheckWarn
+		check.Message = fmt.Sprintf("unable to list nodes: %v", err)
+		return check
+	}
+	allocatable := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.Quantity{},
+		corev1.ResourceMemory: resource.Quantity{},
+	}
+	for _, node := range nodes.Items {
+		if node.Spec.Unschedulable {
+			continue
+		}
+		for name, total := range allocatable {
+			if quantity, ok := node.Status.Allocatable[name]; ok {
+				total.Add(quantity)
+				allocatable[name] = total
+			}
+		}
+	}
+	needed := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.Quantity{},
+		corev1.ResourceMemory: resource.Quantity{},
+	}
+	for _, comp := range components {
+		for name, quantity := range componentRequests(comp) {
+			if total, ok := needed[name]; ok {
+				total.Add(quantity)
+				needed[name] = total
+			}
+		}
+	}
+	cpu := allocatable[corev1.ResourceCPU]
+	memory := allocatable[corev1.ResourceMemory]
+	neededCPU := needed[corev1.ResourceCPU]
+	neededMemory := needed[corev1.ResourceMemory]
+	check.Message = fmt.Sprintf("%s CPU and %s memory allocatable, %s CPU and %s memory needed",
+		cpu.String(), memory.String(), neededCPU.String(), neededMemory.String())
+	if cpu.Cmp(neededCPU) < 0 || memory.Cmp(neededMemory) < 0 {
+		// Requests are estimates, so pods may still fit
+		check.Result = CheckWarn
+		check.Remedy = "add nodes or use larger ones, otherwise pods may remain pending"
+		return check
+	}
+	check.Result = CheckPass
+	return check
+}
+
+// preflight runs Doctor, logging warnings and failing if any
+// check failed
+func (s *Installer) preflight(ctx context.Context) error {
+	checks, err := s.Doctor(ctx)
+	if err != nil {
+		return err
+	}
+	var failed []*Check
+	for _, check := range checks {
+		switch check.Result {
+		case CheckWarn:
+			s.logf(ctx, "Warning: %s: %s", check.Name, check.Message)
+		case CheckFail:
+			failed = append(failed, check)
+		}
+	}
+	if len(failed) > 0 {
+		return &PreflightError{Failed: failed}
+	}
+	return nil
+}
+
+// PrintChecks writes a table with a row per check, followed
+// by the remedies of those that didn't pass
+func PrintChecks(w io.Writer, checks []*Check) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tRESULT\tDETAILS")
+	for _, check := range checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", check.Name, strings.ToUpper(string(check.Result)), check.Message)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	first := true
+	for _, check := range checks {
+		if check.Remedy == "" {
+			continue
+		}
+		if first {
+			fmt.Fprintln(w)
+			first = false
+		}
+		fmt.Fprintf(w, "%s: %s\n", check.Name, check.Remedy)
+	}
+	return nil
+}
+
+// PrintChecksJSON writes the checks as an indented JSON array
+func PrintChecksJSON(w io.Writer, checks []*Check) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(checks)
+}
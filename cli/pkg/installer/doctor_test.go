@@ -0,0 +1,192 @@
+package installer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newFakeKubernetes(
+	serverVersion string,
+	allowed func(*authorizationv1.ResourceAttributes) bool,
+	objs ...runtime.Object,
+) *kubefake.Clientset {
+	clientset := kubefake.NewSimpleClientset(objs...)
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: serverVersion}
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = allowed(review.Spec.ResourceAttributes)
+		return true, review, nil
+	})
+	return clientset
+}
+
+func newNode(name string, cpu string, memory string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(cpu),
+				corev1.ResourceMemory: resource.MustParse(memory),
+			},
+		},
+	}
+}
+
+// newHealthyKubernetes returns a clientset for a cluster that
+// passes every preflight check
+func newHealthyKubernetes() *kubefake.Clientset {
+	return newFakeKubernetes("v1.16.2", func(*authorizationv1.ResourceAttributes) bool {
+		return true
+	}, &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "standard",
+			Annotations: map[string]string{"storageclass.kubernetes.io/is-default-class": "true"},
+		},
+	}, newNode("node-1", "4", "16Gi"))
+}
+
+func findCheck(checks []*Check, name string) *Check {
+	for _, check := range checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	return nil
+}
+
+func TestDoctorHealthy(t *testing.T) {
+	install, _ := newFakeInstaller()
+	checks, err := install.Doctor(context.TODO())
+	require.NoError(t, err)
+	for _, check := range checks {
+		assert.Equal(t, CheckPass, check.Result, "%s: %s", check.Name, check.Message)
+	}
+	assert.Equal(t, "Kubernetes v1.16.2", findCheck(checks, "server version").Message)
+	assert.Equal(t, "standard", findCheck(checks, "default storage class").Message)
+	assert.Nil(t, findCheck(checks, "kubectl"), "commands aren't run by a shell")
+}
+
+func TestDoctorProblems(t *testing.T) {
+	install, _ := newFakeInstaller()
+	unschedulable := newNode("node-2", "64", "256Gi")
+	unschedulable.Spec.Unschedulable = true
+	install.Kubernetes = newFakeKubernetes("v1.13.5-gke.10", func(attrs *authorizationv1.ResourceAttributes) bool {
+		return (attrs.Resource != "secrets" || attrs.Verb != "patch") && attrs.Subresource != "portforward"
+	}, newNode("node-1", "500m", "1Gi"), unschedulable)
+	checks, err := install.Doctor(context.TODO())
+	require.NoError(t, err)
+
+	version := findCheck(checks, "server version")
+	assert.Equal(t, CheckFail, version.Result)
+	assert.Contains(t, version.Message, "older than 1.16.0")
+
+	permissions := findCheck(checks, "permissions")
+	assert.Equal(t, CheckFail, permissions.Result)
+	assert.Equal(t, "not allowed to patch secrets in argocd, create pods/portforward in argocd", permissions.Message)
+
+	assert.Equal(t, CheckWarn, findCheck(checks, "default storage class").Result)
+
+	allocatable := findCheck(checks, "allocatable resources")
+	assert.Equal(t, CheckWarn, allocatable.Result)
+	assert.Equal(t, "500m CPU and 1Gi memory allocatable, 1500m CPU and 1536Mi memory needed", allocatable.Message)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, PrintChecks(buf, checks))
+	assert.Contains(t, buf.String(), "permissions: install as a cluster admin")
+}
+
+func TestDoctorServerTooNew(t *testing.T) {
+	install, _ := newFakeInstaller()
+	install.Kubernetes = newFakeKubernetes("v1.22.1", func(*authorizationv1.ResourceAttributes) bool {
+		return true
+	})
+	checks, err := install.Doctor(context.TODO())
+	require.NoError(t, err)
+	version := findCheck(checks, "server version")
+	assert.Equal(t, CheckFail, version.Result)
+	assert.Contains(t, version.Message, "newer than 1.21")
+}
+
+func TestDoctorAccessByMode(t *testing.T) {
+	var reviewed []string
+	allowed := func(attrs *authorizationv1.ResourceAttributes) bool {
+		reviewed = append(reviewed, describeAccess(accessRequirement{
+			Verb:        attrs.Verb,
+			Group:       attrs.Group,
+			Resource:    attrs.Resource,
+			Subresource: attrs.Subresource,
+			Namespace:   attrs.Namespace,
+		}))
+		return true
+	}
+	install, _ := newFakeInstaller()
+	install.Kubernetes = newFakeKubernetes("v1.16.2", allowed)
+	_, err := install.Doctor(context.TODO())
+	require.NoError(t, err)
+	assert.Contains(t, reviewed, "create applications.argoproj.io in argocd")
+	assert.Contains(t, reviewed, "delete applications.argoproj.io in argocd")
+	assert.Contains(t, reviewed, "delete validatingwebhookconfigurations.admissionregistration.k8s.io")
+	assert.Contains(t, reviewed, "delete clusterroles.rbac.authorization.k8s.io")
+
+	reviewed = nil
+	install.Mode = InstallModeHelm
+	_, err = install.Doctor(context.TODO())
+	require.NoError(t, err)
+	assert.NotContains(t, reviewed, "create applications.argoproj.io in argocd")
+	assert.NotContains(t, reviewed, "create pods/portforward in argocd")
+	g, err := install.Graph()
+	require.NoError(t, err)
+	for _, comp := range g.Components() {
+		if c, ok := comp.(*HelmComponent); ok {
+			assert.Contains(t, reviewed, "create secrets in "+c.Namespace)
+		}
+	}
+}
+
+func TestDoctorExecutables(t *testing.T) {
+	defer func(original func(string) (string, error)) {
+		lookPath = original
+	}(lookPath)
+	lookPath = func(name string) (string, error) {
+		if name == "kubectl" {
+			return "", fmt.Errorf("executable file not found in $PATH")
+		}
+		return "/bin/" + name, nil
+	}
+	install, _ := newFakeInstaller()
+	install.Executor = &BashExecutor{}
+	checks, err := install.Doctor(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, CheckPass, findCheck(checks, "bash").Result)
+	assert.Equal(t, CheckFail, findCheck(checks, "kubectl").Result)
+}
+
+func TestInstallAllPreflight(t *testing.T) {
+	install, executor := newFakeInstaller()
+	install.Kubernetes = newFakeKubernetes("v1.16.2", func(*authorizationv1.ResourceAttributes) bool {
+		return false
+	})
+	err := install.InstallAll(context.TODO())
+	require.IsType(t, &PreflightError{}, err)
+	assert.Contains(t, err.Error(), "not allowed to create namespaces")
+	assert.Empty(t, executor.Commands, "nothing may be changed if a check failed")
+
+	install.SkipDoctor = true
+	install.DryRun = true
+	assert.NoError(t, install.InstallAll(context.TODO()))
+}
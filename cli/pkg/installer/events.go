@@ -0,0 +1,214 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// EventType identifies what an Event reports
+type EventType string
+
+// Types of Event
+const (
+	EventComponentStarted EventType = "ComponentStarted" // Installing, uninstalling or upgrading began
+	EventComponentHealthy EventType = "ComponentHealthy" // Installed or upgraded successfully
+	EventComponentRemoved EventType = "ComponentRemoved" // Uninstalled successfully
+	EventComponentFailed  EventType = "ComponentFailed"  // Error holds the reason
+	EventWaiting          EventType = "Waiting"          // Still waiting on Message, emitted periodically
+	EventCommandStarted   EventType = "CommandStarted"   // Also emitted for commands only planned in dry-run mode
+	EventCommandExecuted  EventType = "CommandExecuted"  // Error is set if the command failed
+	EventMessage          EventType = "Message"          // Informational
+)
+
+// Operations of component events
+const (
+	OperationInstall   = "install"
+	OperationUninstall = "uninstall"
+	OperationUpgrade   = "upgrade"
+)
+
+// Event is a single step of the installer's progress. Durations
+// are encoded in nanoseconds.
+type Event struct {
+	Type      EventType     `json:"type"`
+	Time      time.Time     `json:"time"`
+	Cluster   string        `json:"cluster,omitempty"` // Kubeconfig context, if one was selected
+	Component string        `json:"component,omitempty"`
+	Operation string        `json:"operation,omitempty"`
+	Command   string        `json:"command,omitempty"`
+	Message   string        `json:"message,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration,omitempty"` // Time taken, or elapsed so far for EventWaiting
+}
+
+// EventBus delivers events to its subscribers in the order
+// they are emitted. Subscribers are called synchronously and
+// must not subscribe or unsubscribe from within the callback.
+type EventBus struct {
+	subscribers map[int]func(*Event)
+	next        int
+	l           sync.Mutex
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[int]func(*Event))}
+}
+
+// Subscribe calls f for every event until the returned
+// function is called
+func (b *EventBus) Subscribe(f func(*Event)) func() {
+	b.l.Lock()
+	defer b.l.Unlock()
+	id := b.next
+	b.next++
+	b.subscribers[id] = f
+	return func() {
+		b.l.Lock()
+		defer b.l.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+// Channel returns a channel receiving every event until the
+// returned function is called, which closes it. The installer
+// blocks while the channel's buffer is full.
+func (b *EventBus) Channel(size int) (<-chan *Event, func()) {
+	ch := make(chan *Event, size)
+	unsubscribe := b.Subscribe(func(event *Event) {
+		ch <- event
+	})
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			unsubscribe()
+			close(ch)
+		})
+	}
+}
+
+// Emit delivers the event to every subscriber
+func (b *EventBus) Emit(event *Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	b.l.Lock()
+	defer b.l.Unlock()
+	for i := 0; i < b.next; i++ {
+		if f, ok := b.subscribers[i]; ok {
+			f(event)
+		}
+	}
+}
+
+type contextKey int
+
+const componentContextKey contextKey = 0
+
+// withComponent marks everything done with the context as
+// being done on behalf of the component
+func withComponent(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, componentContextKey, name)
+}
+
+// componentOf returns the component the context was created
+// for, or "" if there isn't one
+func componentOf(ctx context.Context) string {
+	name, _ := ctx.Value(componentContextKey).(string)
+	return name
+}
+
+// emit sets the event's component from the context, unless
+// it is already set, and delivers it
+func (s *Installer) emit(ctx context.Context, event *Event) {
+	if event.Component == "" {
+		event.Component = componentOf(ctx)
+	}
+	if event.Cluster == "" {
+		event.Cluster = s.Context
+	}
+	s.Events.Emit(event)
+}
+
+// logf emits an informational message
+func (s *Installer) logf(ctx context.Context, format string, args ...interface{}) {
+	s.emit(ctx, &Event{Type: EventMessage, Message: fmt.Sprintf(format, args...)})
+}
+
+// waiting emits an EventWaiting every StatusUpdateInterval
+// until the returned function is called
+func (s *Installer) waiting(ctx context.Context, what string) func() {
+	exit := make(chan struct{})
+	go func() {
+		start := time.Now()
+		for {
+			select {
+			case <-exit:
+				return
+			case <-time.After(s.StatusUpdateInterval):
+				s.emit(ctx, &Event{
+					Type:     EventWaiting,
+					Message:  what,
+					Duration: time.Since(start).Round(time.Second),
+				})
+			}
+		}
+	}()
+	return func() { close(exit) }
+}
+
+// componentVerbs are how LogRenderer describes operations
+var componentVerbs = map[string][2]string{
+	OperationInstall:   {"Installing", "Installed"},
+	OperationUninstall: {"Uninstalling", "Uninstalled"},
+	OperationUpgrade:   {"Upgrading", "Upgraded"},
+}
+
+// LogRenderer returns a subscriber that logs events as human
+// readable lines. Commands and waiting are only shown if
+// verbose is true.
+func LogRenderer(verbose bool) func(*Event) {
+	return func(e *Event) {
+		printf := log.Printf
+		if e.Cluster != "" {
+			// Distinguish clusters installed to in parallel
+			printf = func(format string, args ...interface{}) {
+				log.Printf("[%s] "+format, append([]interface{}{e.Cluster}, args...)...)
+			}
+		}
+		verbs := componentVerbs[e.Operation]
+		switch e.Type {
+		case EventComponentStarted:
+			printf("%s %s", verbs[0], e.Component)
+		case EventComponentHealthy, EventComponentRemoved:
+			printf("%s %s (%v)", verbs[1], e.Component, e.Duration.Round(time.Second))
+		case EventComponentFailed:
+			printf("Failed to %s %s: %s", e.Operation, e.Component, e.Error)
+		case EventWaiting:
+			if verbose {
+				printf("Waiting for %s (%v elapsed)", e.Message, e.Duration)
+			}
+		case EventCommandStarted:
+			if verbose {
+				printf("> %s", e.Command)
+			}
+		case EventMessage:
+			printf("%s", e.Message)
+		}
+	}
+}
+
+// JSONLRenderer returns a subscriber that writes every event
+// to w as a line of JSON
+func JSONLRenderer(w io.Writer) func(*Event) {
+	encoder := json.NewEncoder(w)
+	return func(e *Event) {
+		if err := encoder.Encode(e); err != nil {
+			log.Printf("failed to write event: %v", err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package installer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func collectEvents(install *Installer) (func() []*Event, func()) {
+	ch, unsubscribe := install.Events.Channel(1000)
+	var events []*Event
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range ch {
+			events = append(events, event)
+		}
+	}()
+	return func() []*Event {
+		unsubscribe()
+		<-done
+		return events
+	}, unsubscribe
+}
+
+func findEvent(events []*Event, eventType EventType, component string) *Event {
+	for _, event := range events {
+		if event.Type == eventType && event.Component == component {
+			return event
+		}
+	}
+	return nil
+}
+
+func TestEvents(t *testing.T) {
+	install, _ := newFakeInstaller(helmModeCRDs()...)
+	defer install.Reuse()
+	install.Mode = InstallModeHelm
+	collect, unsubscribe := collectEvents(install)
+	defer unsubscribe()
+	require.NoError(t, install.InstallComponentsByName(context.TODO(), []string{"foldy"}))
+	events := collect()
+
+	started := findEvent(events, EventComponentStarted, "foldy")
+	require.NotNil(t, started)
+	assert.Equal(t, OperationInstall, started.Operation)
+	assert.False(t, started.Time.IsZero())
+
+	executed := findEvent(events, EventCommandExecuted, "foldy")
+	require.NotNil(t, executed, "commands must be attributed to their component")
+	assert.True(t, strings.HasPrefix(executed.Command, "kubectl apply -f "+testChartsDir+"/apps/crds/"))
+
+	healthy := findEvent(events, EventComponentHealthy, "foldy")
+	require.NotNil(t, healthy)
+	assert.True(t, healthy.Duration > 0)
+	assert.Nil(t, findEvent(events, EventComponentFailed, "foldy"))
+}
+
+func TestEventsFailure(t *testing.T) {
+	install, executor := newFakeInstaller()
+	defer install.Reuse()
+	install.Mode = InstallModeHelm
+	executor.Respond(`^kubectl apply`, fmt.Errorf("connection refused"))
+	collect, unsubscribe := collectEvents(install)
+	defer unsubscribe()
+	require.Error(t, install.InstallComponentsByName(context.TODO(), []string{"foldy"}))
+	events := collect()
+
+	executed := findEvent(events, EventCommandExecuted, "foldy")
+	require.NotNil(t, executed)
+	assert.Equal(t, "connection refused", executed.Error)
+	failed := findEvent(events, EventComponentFailed, "foldy")
+	require.NotNil(t, failed)
+	assert.Contains(t, failed.Error, "connection refused")
+	assert.Nil(t, findEvent(events, EventComponentHealthy, "foldy"))
+}
+
+func TestWaitingEvents(t *testing.T) {
+	install, _ := newFakeInstaller()
+	install.StatusUpdateInterval = time.Millisecond
+	collect, unsubscribe := collectEvents(install)
+	defer unsubscribe()
+	stop := install.waiting(withComponent(context.TODO(), "foldy"), "foldy to become healthy")
+	time.Sleep(20 * time.Millisecond)
+	stop()
+	waiting := findEvent(collect(), EventWaiting, "foldy")
+	require.NotNil(t, waiting)
+	assert.Equal(t, "foldy to become healthy", waiting.Message)
+}
+
+func TestJSONLRenderer(t *testing.T) {
+	buf := &bytes.Buffer{}
+	bus := NewEventBus()
+	bus.Subscribe(JSONLRenderer(buf))
+	bus.Emit(&Event{Type: EventComponentStarted, Component: "argocd", Operation: OperationInstall})
+	bus.Emit(&Event{Type: EventComponentHealthy, Component: "argocd", Operation: OperationInstall, Duration: time.Second})
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	event := &Event{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), event))
+	assert.Equal(t, EventComponentHealthy, event.Type)
+	assert.Equal(t, "argocd", event.Component)
+	assert.Equal(t, time.Second, event.Duration)
+}
@@ -0,0 +1,83 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+)
+
+// Executor runs the shell commands issued by the Installer.
+// Every mutating command goes through an Executor, which
+// allows the installer to be exercised without a cluster.
+type Executor interface {
+	// Exec runs the command with the given additional
+	// environment variables. The command is aborted when
+	// the context is done.
+	Exec(ctx context.Context, command string, env []string) error
+}
+
+// BashExecutor runs commands with `bash -c`
+type BashExecutor struct{}
+
+func (e *BashExecutor) Exec(ctx context.Context, command string, env []string) error {
+	cmd := exec.Command("bash", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	setProcessGroup(cmd)
+	r, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	resultStdout := make(chan interface{}, 1)
+	resultStderr := make(chan interface{}, 1)
+	go func() {
+		defer close(resultStderr)
+		stderr, err := ioutil.ReadAll(r)
+		if err != nil {
+			resultStderr <- err
+			return
+		}
+		resultStderr <- string(stderr)
+	}()
+	go func() {
+		defer close(resultStdout)
+		stdout, err := ioutil.ReadAll(stdout)
+		if err != nil {
+			resultStdout <- err
+			return
+		}
+		resultStdout <- string(stdout)
+	}()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	waited := make(chan error, 1)
+	go func() {
+		waited <- cmd.Wait()
+	}()
+	select {
+	case err = <-waited:
+	case <-ctx.Done():
+		// Take down everything bash spawned along with it
+		killProcessGroup(cmd)
+		<-waited
+		return fmt.Errorf("%s: %v", command, ctx.Err())
+	}
+	if err != nil {
+		stdoutStr, _ := (<-resultStdout).(string)
+		vStderr := <-resultStderr
+		if stderr, ok := vStderr.(string); ok {
+			return fmt.Errorf("%v: %s\n%s", err, stdoutStr, stderr)
+		} else if err2, ok := vStderr.(error); ok {
+			return fmt.Errorf("%v: %s\n%s", err, err2, stdoutStr)
+		} else {
+			panic("unreachable branch detected")
+		}
+	}
+	return nil
+}
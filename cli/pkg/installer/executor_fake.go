@@ -0,0 +1,70 @@
+package installer
+
+import (
+	"context"
+	"regexp"
+	"sync"
+)
+
+type fakeResponse struct {
+	pattern *regexp.Regexp
+	err     error
+}
+
+// FakeExecutor records every command it is given instead of
+// running it. Responses can be scripted so that matching
+// commands fail with a specific error, e.g. to simulate the
+// stderr of kubectl.
+type FakeExecutor struct {
+	Commands  []string
+	Envs      [][]string // Additional environment of each command
+	responses []*fakeResponse
+	l         sync.Mutex
+}
+
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{}
+}
+
+// Respond scripts the error returned for every command
+// matching the regular expression. The first matching
+// response wins. Commands without a response succeed.
+func (e *FakeExecutor) Respond(pattern string, err error) *FakeExecutor {
+	e.l.Lock()
+	defer e.l.Unlock()
+	e.responses = append(e.responses, &fakeResponse{
+		pattern: regexp.MustCompile(pattern),
+		err:     err,
+	})
+	return e
+}
+
+func (e *FakeExecutor) Exec(ctx context.Context, command string, env []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	e.l.Lock()
+	defer e.l.Unlock()
+	e.Commands = append(e.Commands, command)
+	e.Envs = append(e.Envs, env)
+	for _, response := range e.responses {
+		if response.pattern.MatchString(command) {
+			return response.err
+		}
+	}
+	return nil
+}
+
+// Index returns the position of the first executed command
+// matching the regular expression, or -1 if there is none.
+func (e *FakeExecutor) Index(pattern string) int {
+	e.l.Lock()
+	defer e.l.Unlock()
+	re := regexp.MustCompile(pattern)
+	for i, command := range e.Commands {
+		if re.MatchString(command) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,336 @@
+package installer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/foldy-project/foldy/cli/pkg/readiness"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func init() {
+	// Fixture passwords don't need to be hard to crack
+	passwordHashCost = bcrypt.MinCost
+}
+
+// healthyArgoCD returns the objects of an Argo CD installation
+// that requires no patching and reports every deployment as
+// available.
+func healthyArgoCD(password string) []runtime.Object {
+	objs := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "argocd"}},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "argocd-secret", Namespace: "argocd"},
+			Data: map[string][]byte{
+				"admin.password": []byte(HashPassword(password)),
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "argocd-server-abc123",
+				Namespace: "argocd",
+				Labels:    map[string]string{"app.kubernetes.io/name": "argocd-server"},
+			},
+			Status: corev1.PodStatus{Phase: "Running"},
+		},
+	}
+	for _, name := range []string{
+		"argocd-application-controller",
+		"argocd-dex-server",
+		"argocd-redis",
+		"argocd-repo-server",
+		"argocd-server",
+	} {
+		objs = append(objs, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "argocd"},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{
+							Name:    strings.TrimPrefix(name, "argocd-"),
+							Image:   "argoproj/argocd:v1.5.0-rc1",
+							Command: []string{"argocd-server", "--insecure"},
+						}},
+					},
+				},
+			},
+			Status: appsv1.DeploymentStatus{UpdatedReplicas: 1, AvailableReplicas: 1},
+		})
+	}
+	return objs
+}
+
+// establishedCRD returns a CRD that is being served, as if it
+// had been applied with kubectl
+func establishedCRD(name string) *unstructured.Unstructured {
+	crd, err := newObject("crd", name, "")
+	if err != nil {
+		panic(err)
+	}
+	crd.Object["status"] = map[string]interface{}{
+		"conditions": []interface{}{
+			map[string]interface{}{"type": "Established", "status": "True"},
+		},
+	}
+	return crd
+}
+
+// newFakeDynamicClient returns a dynamic client serving the
+// objects, which the fake client only lists as unstructured
+func newFakeDynamicClient(objs ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	var converted []runtime.Object
+	for _, obj := range objs {
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			converted = append(converted, u)
+			continue
+		}
+		gvks, _, err := scheme.Scheme.ObjectKinds(obj)
+		if err != nil {
+			panic(err)
+		}
+		data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			panic(err)
+		}
+		u := &unstructured.Unstructured{Object: data}
+		u.SetGroupVersionKind(gvks[0])
+		converted = append(converted, u)
+	}
+	return dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), converted...)
+}
+
+// testChartsDir holds the charts of this repository
+const testChartsDir = "../../../charts"
+
+func newFakeInstaller(objs ...runtime.Object) (*Installer, *FakeExecutor) {
+	executor := NewFakeExecutor()
+	install := NewInstaller(fake.NewFakeClientWithScheme(scheme.Scheme, objs...))
+	install.Executor = executor
+	dynamicClient := newFakeDynamicClient(objs...)
+	install.Readiness = readiness.NewWaiter(dynamicClient)
+	install.Dynamic = dynamicClient
+	install.Helm = NewFakeHelmClient()
+	install.ArgoCD = NewFakeArgoCDClient()
+	install.Kubernetes = newHealthyKubernetes()
+	install.Password = "password"
+	install.ChartsDir = testChartsDir
+	return install, executor
+}
+
+func TestCreateNamespaceAlreadyExists(t *testing.T) {
+	install, _ := newFakeInstaller(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "foo"}})
+	assert.NoError(t, install.createNamespace(context.TODO(), "foo"))
+	install.IgnoreAlreadyExists = false
+	assert.True(t, errors.IsAlreadyExists(install.createNamespace(context.TODO(), "foo")))
+	assert.NoError(t, install.createNamespace(context.TODO(), "bar"))
+}
+
+func TestDeleteNamespaceNotFound(t *testing.T) {
+	install, _ := newFakeInstaller(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "foo"}})
+	assert.NoError(t, install.deleteNamespace(context.TODO(), "foo"))
+	assert.NoError(t, install.deleteNamespace(context.TODO(), "foo"))
+	install.IgnoreDeleteNotFound = false
+	assert.True(t, errors.IsNotFound(install.deleteNamespace(context.TODO(), "foo")))
+}
+
+func TestWaitForDeletion(t *testing.T) {
+	install, _ := newFakeInstaller(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "foo"}})
+	assert.Equal(t, ErrDeletionTimeout, install.waitForDeletion(context.TODO(), "namespace", "foo", "", 100*time.Millisecond))
+	assert.NoError(t, install.waitForDeletion(context.TODO(), "namespace", "bar", "", 100*time.Millisecond))
+}
+
+func TestAsyncDelete(t *testing.T) {
+	crd, err := newObject("crd", "a", "")
+	require.NoError(t, err)
+	install, executor := newFakeInstaller(crd)
+	assert.NoError(t, install.AsyncDelete(context.TODO(), "crd", []string{"a", "b"}))
+	assert.Empty(t, executor.Commands)
+	assert.True(t, errors.IsNotFound(install.client.Get(context.TODO(), types.NamespacedName{Name: "a"}, crd)))
+	assert.Error(t, install.AsyncDelete(context.TODO(), "widget", []string{"a"}))
+}
+
+func TestCreateApplication(t *testing.T) {
+	install, executor := newFakeInstaller(healthyArgoCD("password")...)
+	argoCD := install.ArgoCD.(*FakeArgoCDClient)
+	require.NoError(t, install.CreateApplication(context.TODO(), "foo", "https://example.com/foo.git", "charts/foo", "HEAD", map[string]string{
+		"image": "foo:v1",
+	}))
+	assert.NoError(t, install.client.Get(context.TODO(), types.NamespacedName{Name: "foo"}, &corev1.Namespace{}))
+	assert.Empty(t, executor.Commands, "Argo CD must be reached through its API")
+	require.Contains(t, argoCD.Applications, "foo")
+	assert.Equal(t, ArgoCDApplicationSpec{
+		Source: ArgoCDApplicationSource{
+			RepoURL:        "https://example.com/foo.git",
+			Path:           "charts/foo",
+			TargetRevision: "HEAD",
+			Helm: &ArgoCDHelmSource{
+				Parameters: []ArgoCDHelmParameter{{Name: "image", Value: "foo:v1"}},
+			},
+		},
+		Destination: ArgoCDApplicationDestination{
+			Server:    "https://kubernetes.default.svc",
+			Namespace: "foo",
+		},
+		Project: "default",
+	}, argoCD.Applications["foo"].Spec)
+	assert.Equal(t, 1, argoCD.Synced["foo"])
+}
+
+func TestInstallDependencyOrder(t *testing.T) {
+	// A stale admin password forces argocd to issue a patch
+	install, _ := newFakeInstaller(healthyArgoCD("stale")...)
+	defer install.Reuse()
+	collect, unsubscribe := collectEvents(install)
+	defer unsubscribe()
+	require.NoError(t, install.InstallComponentsByName(context.TODO(), []string{"foldy"}))
+	argocd, foldy := -1, -1
+	for i, event := range collect() {
+		if event.Type != EventCommandStarted {
+			continue
+		}
+		if argocd == -1 && strings.Contains(event.Command, "patch secret argocd-secret") {
+			argocd = i
+		} else if foldy == -1 && strings.HasPrefix(event.Command, "argocd app create foldy ") {
+			foldy = i
+		}
+	}
+	require.NotEqual(t, -1, argocd)
+	require.NotEqual(t, -1, foldy)
+	assert.True(t, argocd < foldy, "argocd must be installed before foldy")
+}
+
+// orderedArgoCDClient checks that the argocd namespace is
+// still there whenever an Application is deleted
+type orderedArgoCDClient struct {
+	*FakeArgoCDClient
+	install *Installer
+	deleted map[string]bool // Whether the namespace existed, by application
+}
+
+func (c *orderedArgoCDClient) DeleteApplication(ctx context.Context, name string, cascade bool) error {
+	err := c.install.client.Get(ctx, types.NamespacedName{Name: "argocd"}, &corev1.Namespace{})
+	c.deleted[name] = err == nil
+	return c.FakeArgoCDClient.DeleteApplication(ctx, name, cascade)
+}
+
+func TestUninstallDependencyOrder(t *testing.T) {
+	install, _ := newFakeInstaller(healthyArgoCD("password")...)
+	defer install.Reuse()
+	argoCD := &orderedArgoCDClient{
+		FakeArgoCDClient: NewFakeArgoCDClient(),
+		install:          install,
+		deleted:          make(map[string]bool),
+	}
+	argoCD.Applications["foldy"] = &ArgoCDApplication{}
+	install.ArgoCD = argoCD
+	require.NoError(t, install.UninstallComponentsByName(context.TODO(), []string{"argocd"}))
+	existed, ok := argoCD.deleted["foldy"]
+	require.True(t, ok, "foldy was not deleted")
+	assert.True(t, existed, "foldy must be uninstalled before argocd")
+	assert.NotContains(t, argoCD.Applications, "foldy")
+	err := install.client.Get(context.TODO(), types.NamespacedName{Name: "argocd"}, &corev1.Namespace{})
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestUninstallKeepsUnrecordedCRDs(t *testing.T) {
+	objs := append(healthyArgoCD("password"), establishedCertManagerCRDs()...)
+	install, _ := newFakeInstaller(objs...)
+	defer install.Reuse()
+	fake := NewFakeArgoCDClient()
+	fake.Applications["foldy"] = &ArgoCDApplication{}
+	install.ArgoCD = fake
+	require.NoError(t, install.UninstallComponentsByName(context.TODO(), []string{"foldy"}))
+	crd, err := newObject("crd", "certificates.cert-manager.io", "")
+	require.NoError(t, err)
+	assert.NoError(t, install.client.Get(context.TODO(), types.NamespacedName{Name: crd.GetName()}, crd),
+		"cert-manager was not installed by foldy")
+}
+
+func TestUninstallDependencyOrderDryRun(t *testing.T) {
+	install, _ := newFakeInstaller(healthyArgoCD("password")...)
+	defer install.Reuse()
+	install.DryRun = true
+	require.NoError(t, install.UninstallComponentsByName(context.TODO(), []string{"argocd"}))
+	foldy, argocd := -1, -1
+	for i, step := range install.Plan.Steps {
+		if foldy == -1 && strings.HasSuffix(step.Command, "argocd app delete foldy --cascade") {
+			foldy = i
+		} else if argocd == -1 && step.Command == "kubectl delete namespace argocd" {
+			argocd = i
+		}
+	}
+	require.NotEqual(t, -1, foldy)
+	require.NotEqual(t, -1, argocd)
+	assert.True(t, foldy < argocd, "foldy must be uninstalled before argocd")
+}
+
+func TestDryRun(t *testing.T) {
+	install, executor := newFakeInstaller()
+	defer install.Reuse()
+	install.DryRun = true
+	require.NoError(t, install.InstallComponentsByName(context.TODO(), []string{"foldy"}))
+	assert.Empty(t, executor.Commands)
+	require.NotEmpty(t, install.Plan.Steps)
+	assert.Equal(t, "argocd", install.Plan.Steps[0].Component)
+	assert.Equal(t, "foldy", install.Plan.Steps[len(install.Plan.Steps)-1].Component)
+}
+
+func TestAtomicRollback(t *testing.T) {
+	install, _ := newFakeInstaller(healthyArgoCD("password")...)
+	defer install.Reuse()
+	install.Atomic = true
+	argoCD := install.ArgoCD.(*FakeArgoCDClient)
+	argoCD.Fail("sync", "foldy", assert.AnError)
+	require.Error(t, install.InstallComponentsByName(context.TODO(), []string{"foldy"}))
+	assert.NotContains(t, argoCD.Applications, "foldy", "foldy must be rolled back")
+	// argocd existed before the run, so it must be left alone
+	assert.NoError(t, install.client.Get(context.TODO(), types.NamespacedName{Name: "argocd"}, &corev1.Namespace{}))
+	assert.True(t, errors.IsNotFound(install.client.Get(context.TODO(), types.NamespacedName{Name: "foldy"}, &corev1.Namespace{})))
+}
+
+func TestBashExecutorCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := (&BashExecutor{}).Exec(ctx, "sleep 10", nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+	assert.True(t, time.Since(start) < 5*time.Second, "subprocess must be killed")
+}
+
+func TestComponentTimeout(t *testing.T) {
+	install, _ := newFakeInstaller()
+	install.ComponentTimeout = 10 * time.Millisecond
+	comp := &CustomComponent{Name: "slow"}
+	g, err := NewGraph([]Component{comp})
+	require.NoError(t, err)
+	err = install.schedule(context.TODO(), g, g.Components(), false, func(ctx context.Context, comp Component) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+}
+
+func TestWaitForArgoCDTimeout(t *testing.T) {
+	install, _ := newFakeInstaller()
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	err := install.WaitForArgoCD(ctx)
+	require.True(t, readiness.IsTimeout(err), "expected a timeout, got %v", err)
+	assert.Contains(t, err.Error(), "deployments argocd/argocd-server to be available (not found)")
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package installer
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup places the command in its own process group
+// so that any children it spawns can be killed along with it
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	// A negative pid signals the entire process group
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -1,9 +1,12 @@
 package installer
 
 import (
+	"context"
 	"os"
+	"strconv"
 
-	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 func init() {
@@ -17,6 +20,10 @@ func init() {
 		RepoURL:      foldyRepoURL,
 		Path:         "charts/apps",
 		Dependencies: []string{},
+		Namespaces: []string{
+			"argo",
+			"argo-events",
+		},
 		CRDs: []string{
 			// foldy
 			"backends.app.foldy.dev",
@@ -42,27 +49,121 @@ func init() {
 			"tlsoptions.traefik.containo.us",
 			"traefikservices.traefik.containo.us",
 		},
+		// cert-manager may also be managed by the user, so its
+		// CRDs are only checked and deleted if foldy applied them
+		OptionalCRDs: func(s *Installer) []string {
+			config := EffectiveConfig()
+			if config.Ingress.Enabled && config.CertManager.Enabled {
+				return certManagerCRDs
+			}
+			return nil
+		},
+		// Traefik or cert-manager may already be installed, so
+		// their namespaces are only deleted if foldy created them
+		OptionalNamespaces: func(s *Installer) []string {
+			config := EffectiveConfig()
+			var namespaces []string
+			if config.Ingress.Enabled {
+				namespaces = append(namespaces, "traefik")
+				if config.CertManager.Enabled {
+					namespaces = append(namespaces, "cert-manager")
+				}
+			}
+			return namespaces
+		},
+		RepoValues: map[string]string{
+			// Child Applications deployed by charts/apps
+			"controller.repoURL":  foldyRepoURL,
+			"ui.repoURL":          foldyRepoURL,
+			"traefik.repoURL":     foldyRepoURL,
+			"certmanager.repoURL": foldyRepoURL,
+			"argo.repoURL":        "https://github.com/argoproj/argo-helm.git",
+			"events.repoURL":      "https://github.com/argoproj/argo-helm.git",
+			"community.repoURL":   "https://github.com/foldy-project/foldy-community-server",
+		},
+		// controller.clientImage is left alone, as the controller
+		// doesn't start simulation pods from CLIENT_IMAGE yet
+		ImageValues: map[string]string{
+			"controller.image": "foldy/foldy-controller:latest",
+			"ui.image":         "foldy/foldy-ui:latest",
+		},
+		// The child charts build their images from these
+		PrefixValues: map[string]string{
+			"argo.imageNamespace":           "argoproj",
+			"events.imageNamespace":         "argoproj",
+			"traefik.imageName":             "traefik",
+			"certmanager.images.controller": "quay.io/jetstack/cert-manager-controller",
+			"certmanager.images.cainjector": "quay.io/jetstack/cert-manager-cainjector",
+			"certmanager.images.webhook":    "quay.io/jetstack/cert-manager-webhook",
+		},
+		// Traefik and cert-manager are only deployed when
+		// ingress is configured
+		Values: func(s *Installer) map[string]string {
+			config := EffectiveConfig()
+			return map[string]string{
+				"traefik.enabled":     strconv.FormatBool(config.Ingress.Enabled),
+				"certmanager.enabled": strconv.FormatBool(config.Ingress.Enabled && config.CertManager.Enabled),
+			}
+		},
+		// Pinned by the charts in this repository. Argo and Argo
+		// Events are deployed from the head of argo-helm, so their
+		// images are only known once they are running.
+		Images: []string{
+			"traefik:2.1.4",
+			"quay.io/jetstack/cert-manager-controller:v0.14.0",
+			"quay.io/jetstack/cert-manager-cainjector:v0.14.0",
+			"quay.io/jetstack/cert-manager-webhook:v0.14.0",
+		},
+		// The controller, UI, Argo, Argo Events and Traefik,
+		// without any simulations running
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("1"),
+			corev1.ResourceMemory: resource.MustParse("1Gi"),
+		},
+		Helm: &HelmComponent{
+			// Only the controller, for clusters without Argo CD
+			Chart: "controller",
+			CRDs: []string{
+				"backends.app.foldy.dev",
+				"datasets.app.foldy.dev",
+				"experiments.app.foldy.dev",
+				"models.app.foldy.dev",
+				"transforms.app.foldy.dev",
+			},
+			CRDManifests: []string{
+				"apps/crds/app.foldy.dev_backends_crd.yaml",
+				"apps/crds/app.foldy.dev_datasets_crd.yaml",
+				"apps/crds/app.foldy.dev_experiments_crd.yaml",
+				"apps/crds/app.foldy.dev_models_crd.yaml",
+				"apps/crds/app.foldy.dev_transforms_crd.yaml",
+			},
+			ImageValues: map[string]string{
+				"image": "foldy/foldy-controller:latest",
+			},
+		},
 		ExtraRepos: []*Repository{{
 			Name: "argo",
 			URL:  "https://github.com/argoproj/argo-helm.git",
 		}, {
 			Name: "traefik",
 			URL:  "https://github.com/containous/traefik-helm-chart.git",
-		}, {
-			Name: "jetstack",
-			URL:  "https://charts.jetstack.io",
 		}},
-		PreInstall: func(s *Installer) error {
-			ingressEnabled, _ := viper.Get("ingress.enabled").(bool)
-			if ingressEnabled {
-				if err := s.createNamespace("traefik"); err != nil {
+		PreInstall: func(ctx context.Context, s *Installer) error {
+			config := EffectiveConfig()
+			if config.Ingress.Enabled {
+				if err := s.createNamespace(ctx, "traefik"); err != nil {
 					return err
 				}
+				if config.CertManager.Enabled {
+					if err := s.createNamespace(ctx, "cert-manager"); err != nil {
+						return err
+					}
+				}
 			}
-			if err := s.createNamespace("argo"); err != nil {
+			if err := s.createNamespace(ctx, "argo"); err != nil {
 				return err
 			}
-			if err := s.createNamespace("argo-events"); err != nil {
+			if err := s.createNamespace(ctx, "argo-events"); err != nil {
 				return err
 			}
 			/*
@@ -89,18 +190,28 @@ func init() {
 					done := make(chan error, 1)
 					dones[i] = done
 					go func(crd string, done chan<- error) {
-						done <- s.exec("kubectl apply --validate=false -f %s", crd)
+						done <- s.exec(ctx, "kubectl apply --validate=false -f %s", crd)
 						close(done)
 					}(crd, done)
 				}*/
 			return nil
 		},
-		PostUninstall: func(s *Installer) error {
-			if err := s.AsyncDelete("namespace", []string{
-				"traefik",
+		PostInstall: func(ctx context.Context, s *Installer) error {
+			return s.configureIngress(ctx)
+		},
+		PostUninstall: func(ctx context.Context, s *Installer) error {
+			namespaces := []string{
 				"argo",
 				"argo-events",
-			}); err != nil {
+			}
+			records, err := s.ReadLedger(ctx)
+			if err != nil {
+				return err
+			}
+			if record := records["foldy"]; record != nil {
+				namespaces = append(namespaces, record.Namespaces...)
+			}
+			if err := s.AsyncDelete(ctx, "namespace", namespaces); err != nil {
 				return err
 			}
 			return nil
@@ -0,0 +1,215 @@
+package installer
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// CycleError is returned when component dependencies form a
+// cycle. Cycle begins and ends with the same component.
+type CycleError struct {
+	Cycle []string
+}
+
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("dependency cycle detected: %s", strings.Join(e.Cycle, " -> "))
+}
+
+// Graph is the validated dependency graph of a set of
+// components. It is guaranteed to be acyclic and to only
+// reference components that are part of it.
+type Graph struct {
+	sorted    []Component // dependencies before dependees
+	byName    map[string]Component
+	dependees map[string][]string
+}
+
+// NewGraph validates the dependencies of the components and
+// sorts them topologically. Ties are broken by the order in
+// which the components are given.
+func NewGraph(components []Component) (*Graph, error) {
+	g := &Graph{
+		byName:    make(map[string]Component),
+		dependees: make(map[string][]string),
+	}
+	var multi error
+	for _, comp := range components {
+		if _, ok := g.byName[comp.GetName()]; ok {
+			multi = multierror.Append(multi, fmt.Errorf("component '%s' is registered more than once", comp.GetName()))
+			continue
+		}
+		g.byName[comp.GetName()] = comp
+	}
+	for _, comp := range components {
+		for _, dep := range comp.GetDependencies() {
+			if _, ok := g.byName[dep]; !ok {
+				multi = multierror.Append(multi, fmt.Errorf("component '%s' depends on unknown component '%s'", comp.GetName(), dep))
+				continue
+			}
+			g.dependees[dep] = append(g.dependees[dep], comp.GetName())
+		}
+	}
+	if multi != nil {
+		return nil, multi
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var stack []string
+	var visit func(comp Component) error
+	visit = func(comp Component) error {
+		name := comp.GetName()
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			for i, other := range stack {
+				if other == name {
+					cycle := append([]string{}, stack[i:]...)
+					return &CycleError{Cycle: append(cycle, name)}
+				}
+			}
+			panic("unreachable branch detected")
+		}
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, dep := range comp.GetDependencies() {
+			if err := visit(g.byName[dep]); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+		g.sorted = append(g.sorted, comp)
+		return nil
+	}
+	for _, comp := range components {
+		if err := visit(comp); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// BuildGraph returns the graph of all registered components
+func BuildGraph() (*Graph, error) {
+	componentsL.Lock()
+	defer componentsL.Unlock()
+	return NewGraph(components)
+}
+
+// Components returns every component in topological order
+func (g *Graph) Components() []Component {
+	return append([]Component{}, g.sorted...)
+}
+
+// Component returns the named component, or nil if it is not
+// part of the graph
+func (g *Graph) Component(name string) Component {
+	return g.byName[name]
+}
+
+// Dependencies returns the names of the components that the
+// named component directly depends on
+func (g *Graph) Dependencies(name string) []string {
+	comp, ok := g.byName[name]
+	if !ok {
+		return nil
+	}
+	return comp.GetDependencies()
+}
+
+// Dependees returns the names of the components that directly
+// depend on the named component
+func (g *Graph) Dependees(name string) []string {
+	return g.dependees[name]
+}
+
+// Closure returns the named components along with everything
+// they transitively depend on in topological order. If reverse
+// is true, dependees are followed instead of dependencies and
+// the result is in reverse topological order, which is the
+// order in which components must be uninstalled.
+func (g *Graph) Closure(names []string, reverse bool) ([]Component, error) {
+	included := make(map[string]bool)
+	var include func(name string)
+	include = func(name string) {
+		if included[name] {
+			return
+		}
+		included[name] = true
+		next := g.Dependencies(name)
+		if reverse {
+			next = g.Dependees(name)
+		}
+		for _, other := range next {
+			include(other)
+		}
+	}
+	for _, name := range names {
+		if _, ok := g.byName[name]; !ok {
+			return nil, fmt.Errorf("unknown component '%s'", name)
+		}
+		include(name)
+	}
+	return g.order(included, reverse), nil
+}
+
+// Subset returns only the named components, sorted as Closure
+// would sort them
+func (g *Graph) Subset(names []string, reverse bool) ([]Component, error) {
+	included := make(map[string]bool)
+	for _, name := range names {
+		if _, ok := g.byName[name]; !ok {
+			return nil, fmt.Errorf("unknown component '%s'", name)
+		}
+		included[name] = true
+	}
+	return g.order(included, reverse), nil
+}
+
+func (g *Graph) order(included map[string]bool, reverse bool) []Component {
+	var result []Component
+	for _, comp := range g.sorted {
+		if included[comp.GetName()] {
+			result = append(result, comp)
+		}
+	}
+	if reverse {
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
+		}
+	}
+	return result
+}
+
+// WriteText prints each component in topological order along
+// with its direct dependencies
+func (g *Graph) WriteText(w io.Writer) {
+	for _, comp := range g.sorted {
+		if deps := comp.GetDependencies(); len(deps) > 0 {
+			fmt.Fprintf(w, "%s -> %s\n", comp.GetName(), strings.Join(deps, ", "))
+		} else {
+			fmt.Fprintln(w, comp.GetName())
+		}
+	}
+}
+
+// WriteDOT prints the graph in Graphviz DOT format. Edges
+// point from a component to its dependencies.
+func (g *Graph) WriteDOT(w io.Writer) {
+	fmt.Fprintln(w, "digraph foldy {")
+	for _, comp := range g.sorted {
+		fmt.Fprintf(w, "  %q;\n", comp.GetName())
+		for _, dep := range comp.GetDependencies() {
+			fmt.Fprintf(w, "  %q -> %q;\n", comp.GetName(), dep)
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
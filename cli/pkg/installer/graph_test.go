@@ -0,0 +1,110 @@
+package installer
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testComponent(name string, dependencies ...string) Component {
+	return &CustomComponent{
+		Name:         name,
+		Dependencies: dependencies,
+	}
+}
+
+func componentNames(components []Component) []string {
+	names := make([]string, len(components))
+	for i, comp := range components {
+		names[i] = comp.GetName()
+	}
+	return names
+}
+
+func TestGraphOrder(t *testing.T) {
+	g, err := NewGraph([]Component{
+		testComponent("app", "db", "cache"),
+		testComponent("db", "base"),
+		testComponent("cache", "base"),
+		testComponent("base"),
+		testComponent("other"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"base", "db", "cache", "app", "other"}, componentNames(g.Components()))
+	assert.Equal(t, []string{"db", "cache"}, g.Dependees("base"))
+
+	closure, err := g.Closure([]string{"db"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"base", "db"}, componentNames(closure))
+
+	closure, err = g.Closure([]string{"db"}, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app", "db"}, componentNames(closure))
+
+	subset, err := g.Subset([]string{"app", "base"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"base", "app"}, componentNames(subset))
+
+	_, err = g.Closure([]string{"missing"}, false)
+	assert.Error(t, err)
+}
+
+func TestGraphUnknownDependency(t *testing.T) {
+	_, err := NewGraph([]Component{
+		testComponent("app", "argcd"),
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "component 'app' depends on unknown component 'argcd'")
+}
+
+func TestGraphCycle(t *testing.T) {
+	_, err := NewGraph([]Component{
+		testComponent("base"),
+		testComponent("a", "base", "b"),
+		testComponent("b", "c"),
+		testComponent("c", "a"),
+	})
+	require.Error(t, err)
+	cycle, ok := err.(*CycleError)
+	require.True(t, ok)
+	assert.Equal(t, []string{"a", "b", "c", "a"}, cycle.Cycle)
+}
+
+func TestGraphDOT(t *testing.T) {
+	g, err := NewGraph([]Component{
+		testComponent("app", "base"),
+		testComponent("base"),
+	})
+	require.NoError(t, err)
+	buf := new(bytes.Buffer)
+	g.WriteDOT(buf)
+	assert.Equal(t, "digraph foldy {\n  \"base\";\n  \"app\";\n  \"app\" -> \"base\";\n}\n", buf.String())
+}
+
+func TestScheduleSkipsDependees(t *testing.T) {
+	g, err := NewGraph([]Component{
+		testComponent("app", "base"),
+		testComponent("base"),
+		testComponent("other"),
+	})
+	require.NoError(t, err)
+	install, _ := newFakeInstaller()
+	var ran []string
+	var ranL sync.Mutex
+	err = install.schedule(context.TODO(), g, g.Components(), false, func(ctx context.Context, comp Component) error {
+		ranL.Lock()
+		ran = append(ran, comp.GetName())
+		ranL.Unlock()
+		if comp.GetName() == "base" {
+			return assert.AnError
+		}
+		return nil
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "app: skipped because 'base' failed")
+	assert.ElementsMatch(t, []string{"base", "other"}, ran)
+}
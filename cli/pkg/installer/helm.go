@@ -0,0 +1,228 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// HelmRelease is a chart to be installed as a Helm release
+type HelmRelease struct {
+	Name      string
+	Namespace string
+	Chart     string // Local path or chart name in RepoURL
+	RepoURL   string // Optional chart repository
+	Version   string // Optional chart version
+	Values    map[string]interface{}
+	Timeout   time.Duration
+}
+
+// HelmClient manages Helm releases
+type HelmClient interface {
+	// Upgrade installs the release if it does not exist,
+	// otherwise it upgrades it
+	Upgrade(ctx context.Context, release *HelmRelease) error
+
+	// Uninstall removes the release. ErrReleaseNotFound is
+	// returned if it doesn't exist.
+	Uninstall(ctx context.Context, name string, namespace string) error
+}
+
+var ErrReleaseNotFound = fmt.Errorf("release not found")
+
+// sdkHelmClient manages releases with the Helm v3 SDK, which
+// stores releases in the cluster without requiring Tiller
+type sdkHelmClient struct {
+	settings *cli.EnvSettings
+	verbose  bool
+}
+
+// NewHelmClient returns a client that uses the given kubeconfig
+// and context, or the defaults if they are empty
+func NewHelmClient(kubeconfig string, context string, verbose bool) HelmClient {
+	settings := cli.New()
+	settings.KubeConfig = kubeconfig
+	if context != "" {
+		settings.KubeContext = context
+	}
+	return &sdkHelmClient{settings: settings, verbose: verbose}
+}
+
+func (h *sdkHelmClient) config(namespace string) (*action.Configuration, error) {
+	cfg := &action.Configuration{}
+	getter := kube.GetConfig(h.settings.KubeConfig, h.settings.KubeContext, namespace)
+	if err := cfg.Init(getter, namespace, "secrets", func(format string, v ...interface{}) {
+		if h.verbose {
+			log.Printf(format, v...)
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// run calls f without blocking past the context's deadline.
+// The Helm SDK doesn't support cancellation, so f may still
+// be running in the background when this returns.
+func (h *sdkHelmClient) run(ctx context.Context, f func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *sdkHelmClient) Upgrade(ctx context.Context, release *HelmRelease) error {
+	return h.run(ctx, func() error {
+		cfg, err := h.config(release.Namespace)
+		if err != nil {
+			return err
+		}
+		install := action.NewInstall(cfg)
+		install.ReleaseName = release.Name
+		install.Namespace = release.Namespace
+		install.RepoURL = release.RepoURL
+		install.Version = release.Version
+		install.Wait = true
+		install.Timeout = release.Timeout
+		chartPath, err := install.LocateChart(release.Chart, h.settings)
+		if err != nil {
+			return err
+		}
+		chart, err := loader.Load(chartPath)
+		if err != nil {
+			return err
+		}
+		history := action.NewHistory(cfg)
+		history.Max = 1
+		if _, err := history.Run(release.Name); err == driver.ErrReleaseNotFound {
+			_, err := install.Run(chart, release.Values)
+			return err
+		} else if err != nil {
+			return err
+		}
+		upgrade := action.NewUpgrade(cfg)
+		upgrade.Namespace = release.Namespace
+		upgrade.Wait = true
+		upgrade.Timeout = release.Timeout
+		_, err = upgrade.Run(release.Name, chart, release.Values)
+		return err
+	})
+}
+
+func (h *sdkHelmClient) Uninstall(ctx context.Context, name string, namespace string) error {
+	return h.run(ctx, func() error {
+		cfg, err := h.config(namespace)
+		if err != nil {
+			return err
+		}
+		if _, err := action.NewUninstall(cfg).Run(name); err != nil {
+			if strings.Contains(err.Error(), driver.ErrReleaseNotFound.Error()) {
+				return ErrReleaseNotFound
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// FakeHelmClient records releases instead of installing them
+type FakeHelmClient struct {
+	Releases map[string]*HelmRelease // Keyed by namespace/name
+	l        sync.Mutex
+}
+
+func NewFakeHelmClient() *FakeHelmClient {
+	return &FakeHelmClient{Releases: make(map[string]*HelmRelease)}
+}
+
+func (f *FakeHelmClient) Upgrade(ctx context.Context, release *HelmRelease) error {
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.Releases[release.Namespace+"/"+release.Name] = release
+	return nil
+}
+
+func (f *FakeHelmClient) Uninstall(ctx context.Context, name string, namespace string) error {
+	f.l.Lock()
+	defer f.l.Unlock()
+	if _, ok := f.Releases[namespace+"/"+name]; !ok {
+		return ErrReleaseNotFound
+	}
+	delete(f.Releases, namespace+"/"+name)
+	return nil
+}
+
+// normalizeValues converts the maps decoded from config.yaml,
+// which may have interface{} keys, to what Helm expects
+func normalizeValues(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = normalizeValues(value)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = normalizeValues(value)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			l[i] = normalizeValues(value)
+		}
+		return l
+	}
+	return value
+}
+
+// setValue sets a dotted key such as "ingress.host" in values
+func setValue(values map[string]interface{}, key string, value interface{}) {
+	parts := strings.Split(key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := values[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			values[part] = next
+		}
+		values = next
+	}
+	values[parts[len(parts)-1]] = value
+}
+
+// flattenValues returns the values as sorted --set arguments,
+// which is how they are shown in the equivalent helm command
+func flattenValues(values map[string]interface{}) []string {
+	var result []string
+	var flatten func(prefix string, values map[string]interface{})
+	flatten = func(prefix string, values map[string]interface{}) {
+		for key, value := range values {
+			if nested, ok := value.(map[string]interface{}); ok {
+				flatten(prefix+key+".", nested)
+				continue
+			}
+			result = append(result, fmt.Sprintf("%s%s=%v", prefix, key, value))
+		}
+	}
+	flatten("", values)
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,240 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// dockerHubHosts are the names Docker Hub images may be
+// qualified with, which are dropped when mirroring
+var dockerHubHosts = map[string]bool{
+	"docker.io":       true,
+	"index.docker.io": true,
+}
+
+// MirrorImage rewrites the image to be pulled from registry.
+// Images from Docker Hub keep their repository path, while
+// images from other registries are nested under their host:
+//
+//	argoproj/argocd:latest -> registry.internal/argoproj/argocd:latest
+//	quay.io/dexidp/dex:v2  -> registry.internal/quay.io/dexidp/dex:v2
+func MirrorImage(image string, registry string) string {
+	registry = strings.TrimSuffix(registry, "/")
+	if registry == "" || strings.HasPrefix(image, registry+"/") {
+		return image
+	}
+	if i := strings.Index(image, "/"); i != -1 && dockerHubHosts[image[:i]] {
+		image = image[i+1:]
+	}
+	return registry + "/" + image
+}
+
+// mirrorImage returns the image Kubernetes should pull, which
+// is rewritten if a mirror registry is configured
+func (s *Installer) mirrorImage(image string) string {
+	return MirrorImage(image, s.Registry)
+}
+
+// componentImages returns the images a component is known to
+// use regardless of whether it is installed
+func componentImages(s *Installer, comp Component) []string {
+	switch c := comp.(type) {
+	case *ApplicationComponent:
+		images := append([]string{}, c.Images...)
+		for _, image := range c.ImageValues {
+			images = append(images, image)
+		}
+		return images
+	case *HelmComponent:
+		var images []string
+		for _, image := range c.ImageValues {
+			images = append(images, image)
+		}
+		return images
+	case *CustomComponent:
+		if c.Images != nil {
+			return c.Images(s)
+		}
+	}
+	return nil
+}
+
+// ImageUse is a container image along with the components
+// that use it
+type ImageUse struct {
+	Image      string   `json:"image"`
+	Mirror     string   `json:"mirror,omitempty"`
+	Components []string `json:"components"`
+}
+
+// Images returns every image used by the registered components,
+// sorted by name. This includes the images declared by the
+// components and those found in the workloads of the components'
+// namespaces.
+func (s *Installer) Images(ctx context.Context) ([]*ImageUse, error) {
+	g, err := s.Graph()
+	if err != nil {
+		return nil, err
+	}
+	uses := make(map[string]*ImageUse)
+	add := func(image string, component string) {
+		use, ok := uses[image]
+		if !ok {
+			use = &ImageUse{Image: image}
+			if s.Registry != "" {
+				use.Mirror = s.mirrorImage(image)
+			}
+			uses[image] = use
+		}
+		for _, other := range use.Components {
+			if other == component {
+				return
+			}
+		}
+		use.Components = append(use.Components, component)
+	}
+	for _, comp := range g.Components() {
+		for _, image := range componentImages(s, comp) {
+			add(image, comp.GetName())
+		}
+		for _, namespace := range comp.GetNamespaces() {
+			images, err := s.workloadImages(ctx, namespace)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", comp.GetName(), err)
+			}
+			for _, image := range images {
+				add(image, comp.GetName())
+			}
+		}
+	}
+	result := make([]*ImageUse, 0, len(uses))
+	for _, use := range uses {
+		result = append(result, use)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Image < result[j].Image
+	})
+	return result, nil
+}
+
+// workload is a Deployment, StatefulSet or DaemonSet, named
+// as kubectl set image expects, e.g. deployment/argocd-server
+type workload struct {
+	Name string
+	Spec corev1.PodSpec
+}
+
+// workloads returns every Deployment, StatefulSet and
+// DaemonSet in the namespace
+func (s *Installer) workloads(ctx context.Context, namespace string) ([]workload, error) {
+	var result []workload
+	deployments := &appsv1.DeploymentList{}
+	if err := s.client.List(ctx, deployments, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for _, deployment := range deployments.Items {
+		result = append(result, workload{"deployment/" + deployment.Name, deployment.Spec.Template.Spec})
+	}
+	statefulSets := &appsv1.StatefulSetList{}
+	if err := s.client.List(ctx, statefulSets, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for _, statefulSet := range statefulSets.Items {
+		result = append(result, workload{"statefulset/" + statefulSet.Name, statefulSet.Spec.Template.Spec})
+	}
+	daemonSets := &appsv1.DaemonSetList{}
+	if err := s.client.List(ctx, daemonSets, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for _, daemonSet := range daemonSets.Items {
+		result = append(result, workload{"daemonset/" + daemonSet.Name, daemonSet.Spec.Template.Spec})
+	}
+	return result, nil
+}
+
+// workloadImages returns the images of every Deployment,
+// StatefulSet and DaemonSet in the namespace
+func (s *Installer) workloadImages(ctx context.Context, namespace string) ([]string, error) {
+	if exists, err := NamespaceExists(ctx, s.client, namespace); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+	workloads, err := s.workloads(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	var images []string
+	for _, workload := range workloads {
+		for _, container := range podContainers(workload.Spec) {
+			images = append(images, container.Image)
+		}
+	}
+	return images, nil
+}
+
+func podContainers(spec corev1.PodSpec) []corev1.Container {
+	return append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+}
+
+// mirrorWorkloads points every container of the namespace's
+// Deployments, StatefulSets and DaemonSets at the mirror
+// registry. This is necessary for manifests that are applied
+// verbatim, such as Argo CD's.
+func (s *Installer) mirrorWorkloads(ctx context.Context, namespace string) error {
+	if s.Registry == "" {
+		return nil
+	}
+	workloads, err := s.workloads(ctx, namespace)
+	if err != nil {
+		if s.DryRun && errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	for _, workload := range workloads {
+		var updates []string
+		for _, container := range podContainers(workload.Spec) {
+			if mirrored := s.mirrorImage(container.Image); mirrored != container.Image {
+				updates = append(updates, fmt.Sprintf("%s=%s", container.Name, mirrored))
+			}
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		if err := s.exec(ctx, "kubectl set image %s -n %s %s", workload.Name, namespace, strings.Join(updates, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrintImages writes one image per line, or a table including
+// the mirrored name and components if wide is true
+func PrintImages(w io.Writer, uses []*ImageUse, wide bool) error {
+	if !wide {
+		for _, use := range uses {
+			fmt.Fprintln(w, use.Image)
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "IMAGE\tMIRROR\tCOMPONENTS")
+	for _, use := range uses {
+		mirror := "-"
+		if use.Mirror != "" {
+			mirror = use.Mirror
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", use.Image, mirror, strings.Join(use.Components, ","))
+	}
+	return tw.Flush()
+}
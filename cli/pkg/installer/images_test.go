@@ -0,0 +1,71 @@
+package installer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMirrorImage(t *testing.T) {
+	for image, expected := range map[string]string{
+		"argoproj/argocd:latest":           "registry.internal/argoproj/argocd:latest",
+		"redis:5.0.8":                      "registry.internal/redis:5.0.8",
+		"docker.io/argoproj/argocd:latest": "registry.internal/argoproj/argocd:latest",
+		"quay.io/dexidp/dex:v2.22.0":       "registry.internal/quay.io/dexidp/dex:v2.22.0",
+		"registry.internal/redis:5.0.8":    "registry.internal/redis:5.0.8",
+	} {
+		assert.Equal(t, expected, MirrorImage(image, "registry.internal/"), image)
+	}
+	assert.Equal(t, "redis:5.0.8", MirrorImage("redis:5.0.8", ""))
+}
+
+func TestImages(t *testing.T) {
+	install, _ := newFakeInstaller(healthyArgoCD("password")...)
+	install.Registry = "registry.internal"
+	uses, err := install.Images(context.TODO())
+	require.NoError(t, err)
+	byImage := make(map[string]*ImageUse)
+	for _, use := range uses {
+		byImage[use.Image] = use
+	}
+	require.Contains(t, byImage, "argoproj/argocd:v1.5.0-rc1", "images must be discovered from workloads")
+	assert.Equal(t, []string{"argocd"}, byImage["argoproj/argocd:v1.5.0-rc1"].Components)
+	require.Contains(t, byImage, install.argoCDImage())
+	assert.NotContains(t, byImage, "thavlik/foldy-client:latest", "the controller doesn't read CLIENT_IMAGE")
+}
+
+func TestInstallMirrorsImages(t *testing.T) {
+	controller := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "argocd-application-controller", Namespace: "argocd"},
+		Spec: appsv1.StatefulSetSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "controller", Image: "argoproj/argocd:v1.5.0-rc1"}}},
+			},
+		},
+	}
+	install, executor := newFakeInstaller(append(healthyArgoCD("password"), controller)...)
+	defer install.Reuse()
+	install.Registry = "registry.internal"
+	require.NoError(t, install.InstallComponentsByName(context.TODO(), []string{"foldy"}))
+	assert.NotEqual(t, -1, executor.Index(`^kubectl set image deployment/argocd-redis -n argocd redis=registry.internal/argoproj/argocd:v1.5.0-rc1$`))
+	assert.NotEqual(t, -1, executor.Index(`^kubectl set image statefulset/argocd-application-controller -n argocd controller=registry.internal/argoproj/argocd:v1.5.0-rc1$`))
+	app := install.ArgoCD.(*FakeArgoCDClient).Applications["foldy"]
+	require.NotNil(t, app)
+	for name, value := range map[string]string{
+		"controller.image":              "registry.internal/foldy/foldy-controller:latest",
+		"argo.imageNamespace":           "registry.internal/argoproj",
+		"events.imageNamespace":         "registry.internal/argoproj",
+		"traefik.imageName":             "registry.internal/traefik",
+		"certmanager.images.controller": "registry.internal/quay.io/jetstack/cert-manager-controller",
+	} {
+		assert.Contains(t, app.Spec.Source.Helm.Parameters, ArgoCDHelmParameter{Name: name, Value: value})
+	}
+	for _, param := range app.Spec.Source.Helm.Parameters {
+		assert.NotEqual(t, "controller.clientImage", param.Name)
+	}
+}
@@ -0,0 +1,404 @@
+package installer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ClusterIssuerName is the cert-manager issuer of the
+	// certificates for every exposed service
+	ClusterIssuerName = "foldy-letsencrypt"
+
+	// IngressNameConfigMap remembers the name assigned by the
+	// community server, so that reinstalling keeps the same
+	// hosts. It lives next to the ledger to survive uninstall.
+	IngressNameConfigMap = "foldy-ingress"
+
+	// Traefik's entry points, as configured by its chart
+	entryPointWeb       = "web"
+	entryPointWebSecure = "websecure"
+
+	letsEncryptServer = "https://acme-v02.api.letsencrypt.org/directory"
+
+	// Shown instead of values that are only known once the
+	// community server has been contacted, e.g. in dry-run mode
+	autoDomainPlaceholder = "${INGRESS_DOMAIN}"
+	addressPlaceholder    = "${TRAEFIK_ADDRESS}"
+)
+
+// certManagerCRDs are the CRDs that charts/apps renders from
+// crds/00-crds.yaml, as the cert-manager chart doesn't ship them
+var certManagerCRDs = []string{
+	"certificaterequests.cert-manager.io",
+	"certificates.cert-manager.io",
+	"challenges.acme.cert-manager.io",
+	"clusterissuers.cert-manager.io",
+	"issuers.cert-manager.io",
+	"orders.acme.cert-manager.io",
+}
+
+// ingressBackend is the service that a host is routed to
+type ingressBackend struct {
+	Namespace string
+	Service   string
+	Port      int64
+}
+
+// ingressBackends are the services behind each of
+// IngressServices. The API isn't deployed by any chart yet, so
+// ingress.api has no effect.
+var ingressBackends = map[string]*ingressBackend{
+	"argocd": {Namespace: "argocd", Service: "argocd-server", Port: 80},
+	"events": {Namespace: "argo-events", Service: "github-gateway-svc", Port: 12000},
+	"ui":     {Namespace: "foldy", Service: "foldy-ui", Port: 80},
+}
+
+// managedLabels marks the resources created by the CLI
+// itself, as opposed to those deployed by the charts
+func managedLabels() map[string]interface{} {
+	return map[string]interface{}{"app.kubernetes.io/managed-by": "foldy"}
+}
+
+// IngressName is the name assigned by the community server.
+// Services are exposed as subdomains of Domain.
+type IngressName struct {
+	Name   string `json:"name"`
+	Domain string `json:"domain"`
+}
+
+// ingressNameRequest asks the community server for a name,
+// or to point an existing one at a new address
+type ingressNameRequest struct {
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// requestIngressName obtains a name from the community server
+// at the endpoint
+func requestIngressName(ctx context.Context, endpoint string, req *ingressNameRequest) (*IngressName, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, strings.TrimRight(endpoint, "/")+"/v1/names", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("community server returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	name := &IngressName{}
+	if err := json.Unmarshal(data, name); err != nil {
+		return nil, fmt.Errorf("community server: %v", err)
+	}
+	if name.Domain == "" {
+		return nil, fmt.Errorf("community server did not assign a domain")
+	}
+	return name, nil
+}
+
+// configureIngress exposes every enabled service through
+// Traefik, with certificates from cert-manager unless
+// ingress is insecure
+func (s *Installer) configureIngress(ctx context.Context) error {
+	config := EffectiveConfig()
+	if !config.Ingress.Enabled {
+		return nil
+	}
+	hosts, err := s.ingressHosts(ctx, config)
+	if err != nil {
+		return err
+	}
+	certManager := config.CertManager.Enabled && !config.Ingress.Insecure
+	if certManager {
+		// Argo CD may still be syncing the CRDs
+		if err := s.waitForCRDs(ctx, certManagerCRDs); err != nil {
+			return err
+		}
+		if err := s.applyObject(ctx, clusterIssuer(config.Ingress.Email)); err != nil {
+			return err
+		}
+	}
+	services := config.Ingress.Services()
+	for _, name := range IngressServices {
+		service := services[name]
+		if !service.Enabled {
+			continue
+		} else if ingressBackends[name] == nil {
+			s.logf(ctx, "Warning: not exposing ingress.%s, which isn't deployed yet", name)
+			continue
+		}
+		insecure := config.Ingress.Insecure || service.Insecure
+		for _, obj := range ingressObjects(name, hosts[name], insecure, certManager) {
+			if err := s.applyObject(ctx, obj); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ingressHosts returns the host of every enabled service.
+// Services without a configured host are named after the
+// domain assigned by the community server.
+func (s *Installer) ingressHosts(ctx context.Context, config *Config) (map[string]string, error) {
+	hosts := make(map[string]string)
+	services := config.Ingress.Services()
+	var domain string
+	for _, name := range IngressServices {
+		service := services[name]
+		if !service.Enabled || ingressBackends[name] == nil {
+			continue
+		}
+		if service.Host != "" {
+			hosts[name] = service.Host
+			continue
+		}
+		if !config.Ingress.Auto {
+			return nil, fmt.Errorf("ingress.%s.host is required unless ingress.auto is true", name)
+		}
+		if domain == "" {
+			var err error
+			if domain, err = s.autoDomain(ctx, config.CommunityAPIEndpoint); err != nil {
+				return nil, err
+			}
+		}
+		hosts[name] = name + "." + domain
+	}
+	return hosts, nil
+}
+
+// autoDomain requests a name from the community server,
+// reusing the one from a previous installation if any, and
+// remembers it in the cluster
+func (s *Installer) autoDomain(ctx context.Context, endpoint string) (string, error) {
+	if endpoint == "" {
+		return "", fmt.Errorf("ingress.auto requires communityApiEndpoint")
+	}
+	previous := &corev1.ConfigMap{}
+	err := s.client.Get(ctx, types.NamespacedName{Name: IngressNameConfigMap, Namespace: LedgerNamespace}, previous)
+	if err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
+	req := &ingressNameRequest{Name: previous.Data["name"]}
+	shown, _ := json.Marshal(&ingressNameRequest{Name: req.Name, Address: addressPlaceholder})
+	name := &IngressName{Domain: autoDomainPlaceholder}
+	if err := s.mutate(ctx, func() error {
+		if req.Address, err = s.ingressAddress(ctx); err != nil {
+			return err
+		}
+		name, err = requestIngressName(ctx, endpoint, req)
+		return err
+	}, "curl -X POST %s/v1/names -H 'Content-Type: application/json' -d '%s'", strings.TrimRight(endpoint, "/"), shown); err != nil {
+		return "", err
+	}
+	if err := s.saveIngressName(ctx, name); err != nil {
+		return "", err
+	}
+	return name.Domain, nil
+}
+
+// saveIngressName stores the name assigned by the community
+// server in IngressNameConfigMap
+func (s *Installer) saveIngressName(ctx context.Context, name *IngressName) error {
+	return s.mutate(ctx, func() error {
+		cm := &corev1.ConfigMap{}
+		err := s.client.Get(ctx, types.NamespacedName{Name: IngressNameConfigMap, Namespace: LedgerNamespace}, cm)
+		if errors.IsNotFound(err) {
+			return s.client.Create(ctx, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      IngressNameConfigMap,
+					Namespace: LedgerNamespace,
+					Labels:    map[string]string{"app.kubernetes.io/managed-by": "foldy"},
+				},
+				Data: map[string]string{"name": name.Name, "domain": name.Domain},
+			})
+		} else if err != nil {
+			return err
+		}
+		cm.Data = map[string]string{"name": name.Name, "domain": name.Domain}
+		return s.client.Update(ctx, cm)
+	}, "kubectl -n %s create configmap %s --from-literal=name=%s --from-literal=domain=%s", LedgerNamespace, IngressNameConfigMap, name.Name, name.Domain)
+}
+
+// ingressAddress waits for Traefik's load balancer to be
+// assigned an address, which the community server points the
+// assigned name at. Other types of services are never
+// assigned one, so they fail right away.
+func (s *Installer) ingressAddress(ctx context.Context) (string, error) {
+	defer s.waiting(ctx, "service traefik/traefik to be assigned an address")()
+	for {
+		svc := &corev1.Service{}
+		err := s.client.Get(ctx, types.NamespacedName{Name: "traefik", Namespace: "traefik"}, svc)
+		if err != nil && !errors.IsNotFound(err) {
+			return "", err
+		}
+		if err == nil && svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			return "", fmt.Errorf("service traefik/traefik is of type %s and won't be assigned an address for ingress.auto: set the host of every exposed service, e.g. ingress.ui.host, or expose traefik with a LoadBalancer", svc.Spec.Type)
+		}
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				return ingress.IP, nil
+			}
+			if ingress.Hostname != "" {
+				return ingress.Hostname, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("service traefik/traefik was not assigned an address: %v", ctx.Err())
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// clusterIssuer returns the issuer of Let's Encrypt
+// certificates, solving challenges through Traefik
+func clusterIssuer(email string) *unstructured.Unstructured {
+	acme := map[string]interface{}{
+		"server": letsEncryptServer,
+		"privateKeySecretRef": map[string]interface{}{
+			"name": ClusterIssuerName,
+		},
+		"solvers": []interface{}{
+			map[string]interface{}{
+				"http01": map[string]interface{}{
+					"ingress": map[string]interface{}{"class": "traefik2"},
+				},
+			},
+		},
+	}
+	if email != "" {
+		acme["email"] = email
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1alpha2",
+		"kind":       "ClusterIssuer",
+		"metadata": map[string]interface{}{
+			"name":   ClusterIssuerName,
+			"labels": managedLabels(),
+		},
+		"spec": map[string]interface{}{"acme": acme},
+	}}
+}
+
+// ingressObjects returns the resources exposing the service
+// at the host. Secure services redirect http to https and
+// get their certificate from cert-manager, if enabled, or
+// otherwise use Traefik's default one.
+func ingressObjects(service string, host string, insecure bool, certManager bool) []*unstructured.Unstructured {
+	backend := ingressBackends[service]
+	prefix := "foldy-" + service
+	route := func(name string, entryPoint string, middleware string, tls map[string]interface{}) *unstructured.Unstructured {
+		rule := map[string]interface{}{
+			"match": fmt.Sprintf("Host(`%s`) && PathPrefix(`/`)", host),
+			"kind":  "Rule",
+			"services": []interface{}{
+				map[string]interface{}{"name": backend.Service, "port": backend.Port},
+			},
+		}
+		if middleware != "" {
+			rule["middlewares"] = []interface{}{map[string]interface{}{"name": middleware}}
+		}
+		spec := map[string]interface{}{
+			"entryPoints": []interface{}{entryPoint},
+			"routes":      []interface{}{rule},
+		}
+		if tls != nil {
+			spec["tls"] = tls
+		}
+		return traefikObject("IngressRoute", name, backend.Namespace, spec)
+	}
+	if insecure {
+		return []*unstructured.Unstructured{route(prefix+"-http", entryPointWeb, "", nil)}
+	}
+	var objs []*unstructured.Unstructured
+	tls := map[string]interface{}{}
+	if certManager {
+		secretName := prefix + "-tls"
+		tls["secretName"] = secretName
+		objs = append(objs, &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "cert-manager.io/v1alpha2",
+			"kind":       "Certificate",
+			"metadata": map[string]interface{}{
+				"name":      secretName,
+				"namespace": backend.Namespace,
+				"labels":    managedLabels(),
+			},
+			"spec": map[string]interface{}{
+				"secretName": secretName,
+				"dnsNames":   []interface{}{host},
+				"issuerRef": map[string]interface{}{
+					"name": ClusterIssuerName,
+					"kind": "ClusterIssuer",
+				},
+			},
+		}})
+	}
+	redirect := prefix + "-https-only"
+	return append(objs,
+		traefikObject("Middleware", redirect, backend.Namespace, map[string]interface{}{
+			"redirectScheme": map[string]interface{}{"scheme": "https"},
+		}),
+		route(prefix+"-http", entryPointWeb, redirect, nil),
+		route(prefix+"-https", entryPointWebSecure, "", tls),
+	)
+}
+
+func traefikObject(kind string, name string, namespace string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "traefik.containo.us/v1alpha1",
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+			"labels":    managedLabels(),
+		},
+		"spec": spec,
+	}}
+}
+
+// applyObject creates the object, or replaces the existing
+// one. The manifest is shown as a kubectl apply heredoc.
+func (s *Installer) applyObject(ctx context.Context, obj *unstructured.Unstructured) error {
+	manifest, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return err
+	}
+	return s.mutate(ctx, func() error {
+		existing := &unstructured.Unstructured{}
+		existing.SetGroupVersionKind(obj.GroupVersionKind())
+		err := s.client.Get(ctx, types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}, existing)
+		if errors.IsNotFound(err) {
+			return s.client.Create(ctx, obj)
+		} else if err != nil {
+			return err
+		}
+		obj.SetResourceVersion(existing.GetResourceVersion())
+		return s.client.Update(ctx, obj)
+	}, "kubectl apply -f - <<EOF\n%sEOF", manifest)
+}
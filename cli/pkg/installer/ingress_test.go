@@ -0,0 +1,194 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// setIngressConfig overrides config.yaml for the test. The
+// returned function restores it.
+func setIngressConfig(settings map[string]interface{}) func() {
+	for key, value := range settings {
+		viper.Set(key, value)
+	}
+	return func() {
+		for key := range settings {
+			viper.Set(key, nil)
+		}
+	}
+}
+
+// newCommunityServer stubs the community server, assigning
+// crazy-badger.foldy.dev to every request
+func newCommunityServer(t *testing.T, requests *[]*ingressNameRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/v1/names", r.URL.Path)
+		req := &ingressNameRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(req))
+		*requests = append(*requests, req)
+		json.NewEncoder(w).Encode(&IngressName{Name: "crazy-badger", Domain: "crazy-badger.foldy.dev"})
+	}))
+}
+
+// establishedCertManagerCRDs returns the cert-manager CRDs as
+// if Argo CD had synced them
+func establishedCertManagerCRDs() []runtime.Object {
+	var crds []runtime.Object
+	for _, name := range certManagerCRDs {
+		crds = append(crds, establishedCRD(name))
+	}
+	return crds
+}
+
+func getObject(t *testing.T, install *Installer, apiVersion string, kind string, name string, namespace string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	require.NoError(t, install.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, obj), "%s %s/%s", kind, namespace, name)
+	return obj
+}
+
+func routeMatch(t *testing.T, route *unstructured.Unstructured) string {
+	routes, _, err := unstructured.NestedSlice(route.Object, "spec", "routes")
+	require.NoError(t, err)
+	require.Len(t, routes, 1)
+	return routes[0].(map[string]interface{})["match"].(string)
+}
+
+func TestConfigureIngressAuto(t *testing.T) {
+	var requests []*ingressNameRequest
+	srv := newCommunityServer(t, &requests)
+	defer srv.Close()
+	defer setIngressConfig(map[string]interface{}{
+		"ingress.enabled":        true,
+		"ingress.auto":           true,
+		"ingress.api.enabled":    true,
+		"ingress.argocd.enabled": true,
+		"ingress.ui.enabled":     true,
+		"ingress.ui.host":        "ui.example.com",
+		"certmanager.enabled":    true,
+		"communityApiEndpoint":   srv.URL,
+		"ingress.events.enabled": false,
+	})()
+	install, _ := newFakeInstaller(append(establishedCertManagerCRDs(), &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "traefik", Namespace: "traefik"},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+			Ingress: []corev1.LoadBalancerIngress{{IP: "203.0.113.7"}},
+		}},
+	})...)
+	require.NoError(t, install.configureIngress(context.TODO()))
+	require.Len(t, requests, 1)
+	assert.Equal(t, &ingressNameRequest{Address: "203.0.113.7"}, requests[0])
+
+	getObject(t, install, "cert-manager.io/v1alpha2", "ClusterIssuer", ClusterIssuerName, "")
+	cert := getObject(t, install, "cert-manager.io/v1alpha2", "Certificate", "foldy-argocd-tls", "argocd")
+	dnsNames, _, _ := unstructured.NestedStringSlice(cert.Object, "spec", "dnsNames")
+	assert.Equal(t, []string{"argocd.crazy-badger.foldy.dev"}, dnsNames)
+	https := getObject(t, install, "traefik.containo.us/v1alpha1", "IngressRoute", "foldy-argocd-https", "argocd")
+	assert.Equal(t, "Host(`argocd.crazy-badger.foldy.dev`) && PathPrefix(`/`)", routeMatch(t, https))
+	secretName, _, _ := unstructured.NestedString(https.Object, "spec", "tls", "secretName")
+	assert.Equal(t, "foldy-argocd-tls", secretName)
+	getObject(t, install, "traefik.containo.us/v1alpha1", "Middleware", "foldy-argocd-https-only", "argocd")
+	ui := getObject(t, install, "traefik.containo.us/v1alpha1", "IngressRoute", "foldy-ui-https", "foldy")
+	assert.Equal(t, "Host(`ui.example.com`) && PathPrefix(`/`)", routeMatch(t, ui))
+	assert.Equal(t, "foldy", ui.GetLabels()["app.kubernetes.io/managed-by"])
+
+	// Disabled services are not exposed
+	route := &unstructured.Unstructured{}
+	route.SetAPIVersion("traefik.containo.us/v1alpha1")
+	route.SetKind("IngressRoute")
+	assert.True(t, errors.IsNotFound(install.client.Get(context.TODO(), types.NamespacedName{Name: "foldy-events-https", Namespace: "argo-events"}, route)))
+
+	// Nor is the API, which has no service to route to yet
+	assert.True(t, errors.IsNotFound(install.client.Get(context.TODO(), types.NamespacedName{Name: "foldy-api-https", Namespace: "foldy"}, route)))
+
+	// Reinstalling keeps the assigned name
+	require.NoError(t, install.configureIngress(context.TODO()))
+	require.Len(t, requests, 2)
+	assert.Equal(t, "crazy-badger", requests[1].Name)
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, install.client.Get(context.TODO(), types.NamespacedName{Name: IngressNameConfigMap, Namespace: LedgerNamespace}, cm))
+	assert.Equal(t, "crazy-badger.foldy.dev", cm.Data["domain"])
+}
+
+func TestIngressAddressWithoutLoadBalancer(t *testing.T) {
+	install, _ := newFakeInstaller(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "traefik", Namespace: "traefik"},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort},
+	})
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	_, err := install.ingressAddress(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "of type NodePort")
+	assert.NoError(t, ctx.Err(), "must not wait for an address that never comes")
+}
+
+func TestConfigureIngressInsecure(t *testing.T) {
+	defer setIngressConfig(map[string]interface{}{
+		"ingress.enabled":         true,
+		"ingress.argocd.enabled":  true,
+		"ingress.argocd.host":     "argocd.example.com",
+		"ingress.argocd.insecure": true,
+		"certmanager.enabled":     true,
+	})()
+	install, _ := newFakeInstaller(establishedCertManagerCRDs()...)
+	require.NoError(t, install.configureIngress(context.TODO()))
+	route := getObject(t, install, "traefik.containo.us/v1alpha1", "IngressRoute", "foldy-argocd-http", "argocd")
+	assert.Equal(t, "Host(`argocd.example.com`) && PathPrefix(`/`)", routeMatch(t, route))
+	routes, _, _ := unstructured.NestedSlice(route.Object, "spec", "routes")
+	assert.NotContains(t, routes[0], "middlewares", "insecure services must not redirect to https")
+	cert := &unstructured.Unstructured{}
+	cert.SetAPIVersion("cert-manager.io/v1alpha2")
+	cert.SetKind("Certificate")
+	assert.True(t, errors.IsNotFound(install.client.Get(context.TODO(), types.NamespacedName{Name: "foldy-argocd-tls", Namespace: "argocd"}, cert)))
+}
+
+func TestConfigureIngressHostRequired(t *testing.T) {
+	defer setIngressConfig(map[string]interface{}{
+		"ingress.enabled":    true,
+		"ingress.ui.enabled": true,
+	})()
+	install, _ := newFakeInstaller()
+	err := install.configureIngress(context.TODO())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ingress.ui.host is required")
+}
+
+func TestConfigureIngressDryRun(t *testing.T) {
+	defer setIngressConfig(map[string]interface{}{
+		"ingress.enabled":        true,
+		"ingress.auto":           true,
+		"ingress.argocd.enabled": true,
+		"certmanager.enabled":    true,
+		"communityApiEndpoint":   "http://127.0.0.1:1",
+	})()
+	install, _ := newFakeInstaller()
+	install.DryRun = true
+	require.NoError(t, install.configureIngress(context.TODO()))
+	var commands []string
+	for _, step := range install.Plan.Steps {
+		commands = append(commands, step.Command)
+	}
+	plan := strings.Join(commands, "\n")
+	assert.Contains(t, plan, `curl -X POST http://127.0.0.1:1/v1/names`)
+	assert.Contains(t, plan, "Host(`argocd.${INGRESS_DOMAIN}`)")
+	assert.Contains(t, plan, "kind: ClusterIssuer")
+}
@@ -3,16 +3,20 @@ package installer
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"os"
-	"os/exec"
-	"strings"
+	"sort"
 	"sync"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
 
+	"github.com/foldy-project/foldy/cli/pkg/readiness"
 	"github.com/hashicorp/go-multierror"
 	"github.com/spf13/viper"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -31,7 +35,47 @@ type Installer struct {
 	argocdL              sync.Mutex    //
 	RestartArgoCD        bool          // If true, reapply Argo CD manifest, causing restart
 	StatusUpdateInterval time.Duration // frequency to print periodic updates for asynchronous tasks
-	argoCDPodName        string        //
+	DryRun               bool          // If true, record mutations to Plan instead of running them
+	Plan                 *Plan         // Mutations recorded in dry-run mode
+	Executor             Executor      // Runs every shell command issued by the installer
+	Parallelism          int           // Maximum number of components handled concurrently
+	handled              map[string]bool
+	handledL             sync.Mutex
+	Atomic               bool          // If true, uninstall newly created components when any component fails
+	ComponentTimeout     time.Duration // Maximum duration for installing or uninstalling a single component
+	ApplicationTimeout   time.Duration // Maximum duration to wait for an Application to become Synced and Healthy, 0 to not wait
+	Version              string        // CLI version recorded in the install ledger
+	ledgerL              sync.Mutex
+	Bundle               *Bundle // If set, install from the bundle instead of the internet
+	bundleL              sync.Mutex
+	bundleServed         bool
+	Registry             string               // If set, pull every image from this registry instead
+	Mode                 string               // How ApplicationComponents are installed, see InstallModeArgoCD and InstallModeHelm
+	ChartsDir            string               // Local charts of HelmComponents are resolved relative to this directory
+	Helm                 HelmClient           // Manages the releases of HelmComponents
+	Upgrading            bool                 // If true, replace what's deployed instead of leaving it alone
+	Events               *EventBus            // Progress of the installer, see LogRenderer and JSONLRenderer
+	ArgoCD               ArgoCDClient         // Manages Applications, connected to argocd-server on first use
+	RestConfig           *restclient.Config   // Used to port-forward to argocd-server from outside the cluster
+	InCluster            bool                 // If true, RestConfig is the service account of the pod running the CLI, so argocd-server is reached through its service
+	Readiness            *readiness.Waiter    // Waits on resources, created from RestConfig on first use
+	Kubeconfig           string               // Kubeconfig used by kubectl and Helm, or the default loading rules if empty
+	Context              string               // Kubeconfig context used by kubectl and Helm, or the current one if empty
+	Session              *Session             // If set and routed to this cluster's argocd-server, Argo CD is reached at the session's endpoint instead of port-forwarding
+	Remote               bool                 // If true, there is no access to the cluster and only the Argo CD API is reached through Session, see NewSessionInstaller
+	Kubernetes           kubernetes.Interface // Used by Doctor, created from RestConfig on first use
+	SkipDoctor           bool                 // If true, InstallAll doesn't run Doctor first
+	Dynamic              dynamic.Interface    // Used for custom resources, created from RestConfig on first use
+	BackupDir            string               // If set, foldy resources are backed up here before their CRDs are deleted
+	readinessL           sync.Mutex
+	tunnel               *argoCDTunnel
+	tunnelL              sync.Mutex
+	kubeconfigFile       string
+	kubeconfigL          sync.Mutex
+	kubernetesL          sync.Mutex
+	dynamicL             sync.Mutex
+	backedUp             bool
+	backupL              sync.Mutex
 }
 
 func NewInstaller(cl client.Client) *Installer {
@@ -42,266 +86,309 @@ func NewInstaller(cl client.Client) *Installer {
 		IgnoreDeleteNotFound: true,
 		ShowSecrets:          false,
 		Force:                false,
-		Password:             "password",
 		RepoURL:              "https://github.com/foldy-project/foldy.git",
 		StatusUpdateInterval: 5 * time.Second,
+		Plan:                 &Plan{},
+		Executor:             &BashExecutor{},
+		Parallelism:          4,
+		ComponentTimeout:     10 * time.Minute,
+		ApplicationTimeout:   5 * time.Minute,
+		ChartsDir:            "charts",
+		handled:              make(map[string]bool),
+		Events:               NewEventBus(),
 	}
 	s.ConfigureEnv()
+	s.Helm = NewHelmClient(s.Kubeconfig, s.Context, s.Verbose)
 	return s
 }
 
 func (s *Installer) ConfigureEnv() {
-	s.SkipDependencies, _ = viper.Get("skipDependencies").(bool)
-	s.Password, _ = viper.Get("password").(string)
-	s.ShowSecrets, _ = viper.Get("showSecrets").(bool)
-	s.Verbose, _ = viper.Get("verbose").(bool)
+	config := EffectiveConfig()
+	s.SkipDependencies = viper.GetBool("skipDependencies")
+	s.Password = viper.GetString("password")
+	s.ShowSecrets = config.ShowSecrets
+	s.Verbose = config.Verbose
+	s.DryRun = viper.GetBool("dryRun")
+	s.Atomic = viper.GetBool("atomic")
+	s.Registry = config.Images.Registry
+	s.Mode = config.Mode
+	if config.Helm.ChartsDir != "" {
+		s.ChartsDir = config.Helm.ChartsDir
+	}
+	s.SkipDoctor = viper.GetBool("skipDoctor")
+	s.Kubeconfig = config.Kubeconfig
+	s.Context = config.Context
+	if config.ComponentTimeout > 0 {
+		s.ComponentTimeout = time.Duration(config.ComponentTimeout)
+	}
+	if viper.IsSet("applicationTimeout") {
+		s.ApplicationTimeout = time.Duration(config.ApplicationTimeout)
+	}
+	if config.Parallelism > 0 {
+		s.Parallelism = config.Parallelism
+	}
 }
 
+// Reuse allows components that were already handled by the
+// installer to be installed or uninstalled again
 func (s *Installer) Reuse() {
-	for _, comp := range components {
-		comp.reuse()
-	}
+	s.handledL.Lock()
+	defer s.handledL.Unlock()
+	s.handled = make(map[string]bool)
 }
 
-var ErrArgoCDNotInstalled = fmt.Errorf("Argo CD is not installed")
-
-// ArgoCDSession retrieves and pins a port forward to Argo CD
-func (s *Installer) ArgoCDSession(requireArgoCDExistImmediately bool) error {
-	s.argocdL.Lock()
-	defer s.argocdL.Unlock()
-	if requireArgoCDExistImmediately {
-		if exists, err := NamespaceExists(s.client, "argocd"); err != nil {
-			return err
-		} else if !exists {
-			return ErrArgoCDNotInstalled
-		}
-	}
-	if err := s.WaitForArgoCD(); err != nil {
-		return err
-	}
-	// Login into the server
-	pods := &corev1.PodList{}
-	if err := s.client.List(
-		context.TODO(),
-		pods,
-		client.InNamespace("argocd"),
-	); err != nil {
-		return err
-	}
-	var podName string
-	for _, pod := range pods.Items {
-		appName, ok := pod.ObjectMeta.Labels["app.kubernetes.io/name"]
-		if !ok {
-			continue
-		} else if appName == "argocd-server" && pod.Status.Phase == "Running" {
-			podName = pod.ObjectMeta.Name
-			break
-		}
-	}
-	if podName == "" {
-		return fmt.Errorf("did not find ready argocd-server pod")
-	} else if podName != s.argoCDPodName {
-		// Pod changed
-		if s.ShowSecrets {
-			if err := s.exec("kubectl exec -n argocd %s -- argocd login localhost:8080 --plaintext --username admin --password %s", podName, s.Password); err != nil {
-				return err
-			}
-		} else {
-			if err := os.Setenv("ARGO_USERNAME", "admin"); err != nil {
-				return nil
-			}
-			if err := os.Setenv("ARGO_PASSWORD", s.Password); err != nil {
-				return nil
-			}
-			if err := s.exec("kubectl exec -n argocd %s -- argocd login localhost:8080 --plaintext --username $ARGO_USERNAME --password $ARGO_PASSWORD", podName); err != nil {
-				return err
-			}
-			if err := os.Unsetenv("ARGO_USERNAME"); err != nil {
-				return nil
-			}
-			if err := os.Unsetenv("ARGO_PASSWORD"); err != nil {
-				return nil
-			}
-		}
-		s.argoCDPodName = podName
-	}
-	return nil
+func (s *Installer) exec(ctx context.Context, command string, args ...interface{}) error {
+	return s.execEnv(ctx, nil, command, args...)
 }
 
-func (s *Installer) exec(command string, args ...interface{}) error {
+// execEnv runs the command with additional environment
+// variables, which is how secrets are passed to commands
+// without printing them
+func (s *Installer) execEnv(ctx context.Context, env []string, command string, args ...interface{}) error {
 	var interpolated string
 	if len(args) > 0 {
 		interpolated = fmt.Sprintf(command, args...)
 	} else {
 		interpolated = command
 	}
-	cmd := exec.Command("bash", "-c", interpolated)
-	if s.Verbose {
-		log.Printf("> %s", interpolated)
+	if !s.announce(ctx, interpolated) {
+		return nil
 	}
-	r, err := cmd.StderrPipe()
+	kubeEnv, err := s.kubeconfigEnv()
 	if err != nil {
 		return err
 	}
-	stdout, err := cmd.StdoutPipe()
+	stop := s.waiting(ctx, interpolated)
+	start := time.Now()
+	err = s.Executor.Exec(ctx, interpolated, append(env, kubeEnv...))
+	stop()
+	event := &Event{
+		Type:     EventCommandExecuted,
+		Command:  interpolated,
+		Duration: time.Since(start),
+	}
 	if err != nil {
+		event.Error = err.Error()
+	}
+	s.emit(ctx, event)
+	return err
+}
+
+// mutate performs a mutation through the API server. The
+// equivalent kubectl command is printed in verbose mode so
+// that every mutation remains observable.
+func (s *Installer) mutate(ctx context.Context, f func() error, command string, args ...interface{}) error {
+	if !s.announce(ctx, fmt.Sprintf(command, args...)) {
+		return nil
+	}
+	return f()
+}
+
+// announce emits the command and records it to the plan in
+// dry-run mode. It returns false if the command should not
+// actually be performed.
+func (s *Installer) announce(ctx context.Context, command string) bool {
+	s.emit(ctx, &Event{Type: EventCommandStarted, Command: command})
+	if s.DryRun {
+		s.Plan.add(componentOf(ctx), command)
+		return false
+	}
+	return true
+}
+
+// runWithTimeout calls f, aborting it if the component takes
+// longer than ComponentTimeout
+func (s *Installer) runWithTimeout(
+	ctx context.Context,
+	comp Component,
+	f func(ctx context.Context, comp Component) error,
+) error {
+	if err := ctx.Err(); err != nil {
+		// Don't bother starting anything new
 		return err
 	}
-	resultStdout := make(chan interface{}, 1)
-	resultStderr := make(chan interface{}, 1)
-	go func() {
-		defer close(resultStderr)
-		stderr, err := ioutil.ReadAll(r)
-		if err != nil {
-			resultStderr <- err
-			return
-		}
-		resultStderr <- string(stderr)
-	}()
-	go func() {
-		defer close(resultStdout)
-		stdout, err := ioutil.ReadAll(stdout)
-		if err != nil {
-			resultStdout <- err
-			return
-		}
-		resultStdout <- string(stdout)
-	}()
-	if s.Verbose {
-		defer NewWaitingMessage(interpolated, s.StatusUpdateInterval).Stop()
-	}
-	if err := cmd.Run(); err != nil {
-		stdoutStr, _ := (<-resultStdout).(string)
-		vStderr := <-resultStderr
-		if stderr, ok := vStderr.(string); ok {
-			return fmt.Errorf("%v: %s\n%s", err, stdoutStr, stderr)
-		} else if err2, ok := vStderr.(error); ok {
-			return fmt.Errorf("%v: %s\n%s", err, err2, stdoutStr)
-		} else {
-			panic("unreachable branch detected")
-		}
+	if s.ComponentTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.ComponentTimeout)
+		defer cancel()
 	}
-	return nil
+	return f(ctx, comp)
 }
 
-func (s *Installer) installComponent(comp Component) error {
-	comp.Lock()
-	defer comp.Unlock()
-	if comp.IsHandled() {
+// markHandled returns false if the component has already
+// been handled by this installer since the last Reuse()
+func (s *Installer) markHandled(comp Component) bool {
+	s.handledL.Lock()
+	defer s.handledL.Unlock()
+	if s.handled[comp.GetName()] {
+		return false
+	}
+	s.handled[comp.GetName()] = true
+	return true
+}
+
+func (s *Installer) installComponent(ctx context.Context, comp Component) error {
+	if !s.markHandled(comp) {
 		return nil
 	}
-	if len(comp.GetDependencies()) > 0 && !s.SkipDependencies {
-		if err := s.InstallComponentsByName(comp.GetDependencies()); err != nil {
+	operation := OperationInstall
+	if s.Upgrading {
+		operation = OperationUpgrade
+	}
+	return s.track(ctx, comp, operation, EventComponentHealthy, func(ctx context.Context) error {
+		if s.Remote {
+			// The ledger can't be written without the cluster
+			return s.installRemote(ctx, comp)
+		}
+		if err := comp.RunInstall(ctx, s); err != nil {
 			return err
 		}
+		return s.updateLedger(ctx, comp.GetName(), s.newInstallRecord(comp))
+	})
+}
+
+func (s *Installer) uninstallComponent(ctx context.Context, comp Component) error {
+	if !s.markHandled(comp) {
+		return nil
 	}
-	log.Printf("Installing %s", comp.GetName())
-	if err := comp.RunInstall(s); err != nil {
+	return s.track(ctx, comp, OperationUninstall, EventComponentRemoved, func(ctx context.Context) error {
+		if err := s.backupBeforeUninstall(ctx, comp); err != nil {
+			return err
+		}
+		// Only delete the CRDs that were applied by foldy
+		records, err := s.ReadLedger(ctx)
+		if err != nil {
+			return err
+		}
+		if err := comp.RunUninstall(ctx, s); err != nil {
+			return err
+		}
+		if err := s.AsyncDelete(ctx, "crd", recordedCRDs(comp, records[comp.GetName()])); err != nil {
+			return err
+		}
+		return s.updateLedger(ctx, comp.GetName(), nil)
+	})
+}
+
+// track calls f on behalf of the component, emitting when it
+// starts and whether it succeeded or failed along with how
+// long it took
+func (s *Installer) track(
+	ctx context.Context,
+	comp Component,
+	operation string,
+	succeeded EventType,
+	f func(ctx context.Context) error,
+) error {
+	ctx = withComponent(ctx, comp.GetName())
+	s.emit(ctx, &Event{Type: EventComponentStarted, Operation: operation})
+	start := time.Now()
+	if err := f(ctx); err != nil {
+		s.emit(ctx, &Event{
+			Type:      EventComponentFailed,
+			Operation: operation,
+			Error:     err.Error(),
+			Duration:  time.Since(start),
+		})
 		return err
 	}
-	log.Printf("Installed %s", comp.GetName())
+	s.emit(ctx, &Event{Type: succeeded, Operation: operation, Duration: time.Since(start)})
 	return nil
 }
 
-func (s *Installer) installComponents(components []Component) error {
-	dones := make([]<-chan int, len(components), len(components))
-	var errL sync.Mutex
-	var multi error
-	for i, comp := range components {
-		done := make(chan int, 1)
-		dones[i] = done
-		go func(comp Component, done chan<- int) {
-			defer close(done) // I much prefer this syntax
-			err := s.installComponent(comp)
-			if err != nil {
-				// disambiguate the error just a little
-				err = fmt.Errorf("%s: %v", comp.GetName(), err)
-				errL.Lock()
-				multi = multierror.Append(multi, err)
-				errL.Unlock()
-			}
-			done <- 0
-		}(comp, done)
+// schedule calls f for each of the components once everything
+// it has to wait on is finished. When installing, components
+// wait on their dependencies. When uninstalling (reverse),
+// they wait on their dependees instead. At most Parallelism
+// components are handled at once, and components are skipped
+// when something they wait on has failed. The components must
+// be sorted the way Graph.Closure sorts them.
+func (s *Installer) schedule(
+	ctx context.Context,
+	g *Graph,
+	components []Component,
+	reverse bool,
+	f func(ctx context.Context, comp Component) error,
+) error {
+	type result struct {
+		err  error
+		done chan struct{}
 	}
-	for _, done := range dones {
-		<-done
+	results := make(map[string]*result, len(components))
+	for _, comp := range components {
+		results[comp.GetName()] = &result{done: make(chan struct{})}
 	}
-	return multi
-}
-
-func (s *Installer) uninstallComponent(comp Component) error {
-	comp.Lock()
-	defer comp.Unlock()
-	if comp.IsHandled() {
-		return nil
+	parallelism := s.Parallelism
+	if parallelism < 1 || s.DryRun {
+		// Serialize so the plan is recorded in dependency order
+		parallelism = 1
 	}
-	if !s.SkipDependencies {
-		dependees := GetDirectDependees(comp.GetName())
-		if numDependees := len(dependees); numDependees > 0 {
-			dones := make([]chan int, numDependees, numDependees)
-			var multi error
-			var multiL sync.Mutex
-			for i, dependee := range dependees {
-				done := make(chan int, 1)
-				dones[i] = done
-				go func(dependee string, done chan<- int) {
-					defer close(done)
-					if err := s.uninstallComponent(GetComponentByName(dependee)); err != nil {
-						wrapped := fmt.Errorf("%v: %v", dependee, err)
-						multiL.Lock()
-						log.Printf("%v", wrapped)
-						multi = multierror.Append(multi, wrapped)
-						multiL.Unlock()
-					}
-					done <- 0
-				}(dependee, done)
-			}
-			for _, done := range dones {
-				<-done
+	sem := make(chan struct{}, parallelism)
+	var errL sync.Mutex
+	var multi error
+	run := func(comp Component) {
+		r := results[comp.GetName()]
+		defer close(r.done)
+		waitOn := g.Dependencies(comp.GetName())
+		if reverse {
+			waitOn = g.Dependees(comp.GetName())
+		}
+		for _, name := range waitOn {
+			other, ok := results[name]
+			if !ok {
+				// Not part of this run
+				continue
 			}
-			if multi != nil {
-				return multi
+			<-other.done
+			if other.err != nil {
+				r.err = fmt.Errorf("skipped because '%s' failed", name)
+				break
 			}
 		}
+		if r.err == nil {
+			sem <- struct{}{}
+			r.err = s.runWithTimeout(ctx, comp, f)
+			<-sem
+		}
+		if r.err != nil {
+			// disambiguate the error just a little
+			errL.Lock()
+			multi = multierror.Append(multi, fmt.Errorf("%s: %v", comp.GetName(), r.err))
+			errL.Unlock()
+		}
 	}
-	if err := comp.RunUninstall(s); err != nil {
-		return err
+	if parallelism == 1 {
+		for _, comp := range components {
+			run(comp)
+		}
+		return multi
 	}
-	if err := s.AsyncDelete("crd", comp.GetCRDs()); err != nil {
-		return err
+	var wg sync.WaitGroup
+	wg.Add(len(components))
+	for _, comp := range components {
+		go func(comp Component) {
+			defer wg.Done()
+			run(comp)
+		}(comp)
 	}
-	return nil
+	wg.Wait()
+	return multi
 }
 
-func (s *Installer) AsyncDelete(resource string, names []string) error {
+func (s *Installer) AsyncDelete(ctx context.Context, resource string, names []string) error {
 	dones := make([]chan error, len(names), len(names))
 	for i, name := range names {
 		done := make(chan error, 1)
 		dones[i] = done
-		go func(name string, done chan<- error) {
+		del := func(name string, done chan<- error) {
 			defer close(done)
-			done <- func() error {
-				if err := s.exec("kubectl delete %s %s", resource, name); err != nil {
-					if !s.IgnoreDeleteNotFound {
-						return err
-					}
-					if err := func() error {
-						// Weird code structure so we get coverage
-						msg := strings.TrimSpace(err.Error())
-						if strings.Contains(msg, `Error from server (NotFound):`) {
-							return nil
-						}
-						if strings.Contains(msg, `Error from server (Conflict):`) {
-							return nil
-						}
-						return err
-					}(); err != nil {
-						return err
-					}
-				}
-				return nil
-			}()
-		}(name, done)
+			done <- s.deleteResource(ctx, resource, name, "")
+		}
+		if s.DryRun {
+			del(name, done)
+		} else {
+			go del(name, done)
+		}
 	}
 	var multi error
 	for _, done := range dones {
@@ -312,110 +399,255 @@ func (s *Installer) AsyncDelete(resource string, names []string) error {
 	return multi
 }
 
-func (s *Installer) uninstallComponents(components []Component) error {
-	dones := make([]<-chan int, len(components), len(components))
-	var errL sync.Mutex
-	var multi error
-	for i, comp := range components {
-		done := make(chan int, 1)
-		dones[i] = done
-		go func(comp Component, done chan<- int) {
-			defer func() {
-				done <- 0
-				close(done)
-			}()
-			if err := s.uninstallComponent(comp); err != nil {
-				errL.Lock()
-				log.Printf("Error uninstalling %s: %v", comp.GetName(), err)
-				multi = multierror.Append(multi, err)
-				errL.Unlock()
-			}
-		}(comp, done)
+// deleteResource deletes a single resource by its kubectl
+// resource name, e.g. "crd" or "namespace"
+func (s *Installer) deleteResource(ctx context.Context, resource string, name string, namespace string) error {
+	obj, err := newObject(resource, name, namespace)
+	if err != nil {
+		return err
 	}
-	for _, done := range dones {
-		<-done
+	command := fmt.Sprintf("kubectl delete %s %s", resource, name)
+	if namespace != "" {
+		command = fmt.Sprintf("kubectl delete %s -n %s %s", resource, namespace, name)
 	}
-	return multi
+	return s.mutate(ctx, func() error {
+		if err := s.client.Delete(ctx, obj); err != nil {
+			if !s.IgnoreDeleteNotFound {
+				return err
+			}
+			if errors.IsNotFound(err) || errors.IsConflict(err) || meta.IsNoMatchError(err) {
+				return nil
+			}
+			return err
+		}
+		return nil
+	}, "%s", command)
 }
 
-func (s *Installer) InstallComponentsByName(names []string) error {
-	components, err := GetComponentsByName(names)
+func (s *Installer) InstallComponentsByName(ctx context.Context, names []string) error {
+	g, err := s.Graph()
 	if err != nil {
 		return err
 	}
-	return s.installComponents(components)
-}
-
-func (s *Installer) UninstallComponentsByName(names []string) error {
-	components, err := GetComponentsByName(names)
+	var components []Component
+	if s.SkipDependencies {
+		components, err = g.Subset(names, false)
+	} else {
+		components, err = g.Closure(names, false)
+	}
 	if err != nil {
 		return err
 	}
-	return s.uninstallComponents(components)
+	return s.installComponents(ctx, g, components)
 }
 
-func (s *Installer) InstallAll() error {
-	return s.installComponents(components)
+func (s *Installer) UninstallComponentsByName(ctx context.Context, names []string) error {
+	g, err := s.Graph()
+	if err != nil {
+		return err
+	}
+	var components []Component
+	if s.SkipDependencies {
+		components, err = g.Subset(names, true)
+	} else {
+		components, err = g.Closure(names, true)
+	}
+	if err != nil {
+		return err
+	}
+	return s.schedule(ctx, g, components, true, s.uninstallComponent)
 }
 
-func (s *Installer) UninstallAll() error {
-	return s.uninstallComponents(components)
+func (s *Installer) InstallAll(ctx context.Context) error {
+	g, err := s.Graph()
+	if err != nil {
+		return err
+	}
+	if !s.SkipDoctor && !s.Remote {
+		if err := s.preflight(ctx); err != nil {
+			return err
+		}
+	}
+	return s.installComponents(ctx, g, g.Components())
 }
 
-func (s *Installer) createNamespace(namespace string) error {
-	if err := s.exec("kubectl create namespace %s", namespace); err != nil {
-		if !s.IgnoreAlreadyExists {
+// installComponents installs the components in dependency
+// order. In atomic mode, if anything fails, the components
+// that did not exist before are uninstalled again.
+func (s *Installer) installComponents(ctx context.Context, g *Graph, components []Component) error {
+	if !s.Atomic || s.DryRun {
+		return s.schedule(ctx, g, components, false, s.installComponent)
+	}
+	existing := make(map[string]bool)
+	for _, comp := range components {
+		exists, err := s.componentExists(ctx, comp)
+		if err != nil {
 			return err
 		}
-		msg := fmt.Sprintf(`Error from server (AlreadyExists): namespaces "%s" already exists`, namespace)
-		if !strings.Contains(strings.TrimSpace(err.Error()), msg) {
-			return err
+		existing[comp.GetName()] = exists
+	}
+	var attemptedL sync.Mutex
+	attempted := make(map[string]bool)
+	err := s.schedule(ctx, g, components, false, func(ctx context.Context, comp Component) error {
+		attemptedL.Lock()
+		attempted[comp.GetName()] = true
+		attemptedL.Unlock()
+		return s.installComponent(ctx, comp)
+	})
+	if err == nil {
+		return nil
+	}
+	var created []Component
+	for i := len(components) - 1; i >= 0; i-- {
+		name := components[i].GetName()
+		if attempted[name] && !existing[name] {
+			created = append(created, components[i])
 		}
 	}
-	return nil
+	if len(created) == 0 {
+		return err
+	}
+	s.logf(ctx, "Installation failed. Rolling back %d component(s) created by this run...", len(created))
+	s.Reuse()
+	if ctx.Err() != nil {
+		// The run was interrupted, but the rollback still has
+		// to happen. Each component is bounded by its timeout.
+		ctx = context.Background()
+	}
+	if rollbackErr := s.schedule(ctx, g, created, true, s.uninstallComponent); rollbackErr != nil {
+		return multierror.Append(err, fmt.Errorf("rollback: %v", rollbackErr))
+	}
+	s.logf(ctx, "Rollback complete")
+	return err
 }
 
-func (s *Installer) deleteNamespace(namespace string) error {
-	if err := s.exec("kubectl delete namespace %s", namespace); err != nil {
-		if !s.IgnoreDeleteNotFound {
-			return err
+// componentExists returns true if the component has an install
+// record or any of its namespaces exist. It errs on the side of
+// caution, as existing components must never be rolled back.
+func (s *Installer) componentExists(ctx context.Context, comp Component) (bool, error) {
+	if s.Remote {
+		return s.remoteExists(ctx, comp)
+	}
+	records, err := s.ReadLedger(ctx)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := records[comp.GetName()]; ok {
+		return true, nil
+	}
+	for _, namespace := range comp.GetNamespaces() {
+		if exists, err := NamespaceExists(ctx, s.client, namespace); err != nil {
+			return false, err
+		} else if exists {
+			return true, nil
 		}
-		if err := func() error {
-			// Weird code structure so we get coverage
-			msg := strings.TrimSpace(err.Error())
-			if strings.Contains(msg, fmt.Sprintf(`Error from server (NotFound): namespaces "%s" not found`, namespace)) {
-				return nil
-			}
-			if strings.Contains(msg, fmt.Sprintf(`Error from server (Conflict): Operation cannot be fulfilled on namespaces "%s": The system is ensuring all content is removed from this namespace.  Upon completion, this namespace will automatically be purged by the system.`, namespace)) {
-				return nil
+	}
+	return false, nil
+}
+
+func (s *Installer) UninstallAll(ctx context.Context) error {
+	g, err := s.Graph()
+	if err != nil {
+		return err
+	}
+	components := g.Components()
+	for i, j := 0, len(components)-1; i < j; i, j = i+1, j-1 {
+		components[i], components[j] = components[j], components[i]
+	}
+	return s.schedule(ctx, g, components, true, s.uninstallComponent)
+}
+
+func (s *Installer) createNamespace(ctx context.Context, namespace string) error {
+	return s.mutate(ctx, func() error {
+		if err := s.client.Create(
+			ctx,
+			&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: namespace},
+			},
+		); err != nil {
+			if !s.IgnoreAlreadyExists || !errors.IsAlreadyExists(err) {
+				return err
 			}
-			return err
-		}(); err != nil {
-			return err
 		}
-	}
-	return nil
+		return nil
+	}, "kubectl create namespace %s", namespace)
+}
+
+func (s *Installer) deleteNamespace(ctx context.Context, namespace string) error {
+	return s.deleteResource(ctx, "namespace", namespace, "")
 }
 
 func (s *Installer) CreateApplication(
+	ctx context.Context,
 	name string,
 	repoURL string,
 	path string,
+	revision string,
+	values map[string]string,
 ) error {
-	if err := s.createNamespace(name); err != nil {
-		return err
+	if !s.Remote {
+		if err := s.createNamespace(ctx, name); err != nil {
+			return err
+		}
 	}
-	if err := s.RunCommandInArgoCDServer("argocd app create %s --repo %s --path %s --dest-namespace %s --dest-server https://kubernetes.default.svc", name, repoURL, path, name); err != nil {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var flags string
+	if s.Upgrading {
+		// Replace the spec of the existing Application
+		flags += " --upsert"
+	}
+	app := &ArgoCDApplication{
+		Metadata: metav1.ObjectMeta{Name: name, Namespace: "argocd"},
+		Spec: ArgoCDApplicationSpec{
+			Source: ArgoCDApplicationSource{
+				RepoURL:        repoURL,
+				Path:           path,
+				TargetRevision: revision,
+			},
+			Destination: ArgoCDApplicationDestination{
+				Server:    "https://kubernetes.default.svc",
+				Namespace: name,
+			},
+			Project: "default",
+		},
+	}
+	if len(keys) > 0 {
+		app.Spec.Source.Helm = &ArgoCDHelmSource{}
+	}
+	for _, key := range keys {
+		flags += fmt.Sprintf(" --helm-set %s=%s", key, values[key])
+		app.Spec.Source.Helm.Parameters = append(app.Spec.Source.Helm.Parameters, ArgoCDHelmParameter{
+			Name:  key,
+			Value: values[key],
+		})
+	}
+	if err := s.mutate(ctx, func() error {
+		argoCD, err := s.argoCD(ctx)
+		if err != nil {
+			return err
+		}
+		return argoCD.CreateApplication(ctx, app, s.Upgrading)
+	}, "argocd app create %s --repo %s --path %s --revision %s --dest-namespace %s --dest-server https://kubernetes.default.svc%s", name, repoURL, path, revision, name, flags); err != nil {
 		return err
 	}
-	if err := s.RunCommandInArgoCDServer("argocd app sync %s", name); err != nil {
+	if err := s.mutate(ctx, func() error {
+		argoCD, err := s.argoCD(ctx)
+		if err != nil {
+			return err
+		}
+		return argoCD.SyncApplication(ctx, name)
+	}, "argocd app sync %s", name); err != nil {
 		return err
 	}
-	return nil
+	return s.waitForApplication(ctx, name)
 }
 
-func (s *Installer) DeleteApplication(name string) error {
-	exists, err := NamespaceExists(s.client, "argocd")
+func (s *Installer) DeleteApplication(ctx context.Context, name string) error {
+	exists, err := NamespaceExists(ctx, s.client, "argocd")
 	if err != nil {
 		return err
 	}
@@ -423,63 +655,132 @@ func (s *Installer) DeleteApplication(name string) error {
 		// Do gentle uninstallation with Argo CD --cascade delete
 		// This causes sub-applications to also be deleted
 		if exists {
-			if err := s.RunCommandInArgoCDServer("argocd app delete %s --cascade", name); err != nil {
+			if err := s.mutate(ctx, func() error {
+				argoCD, err := s.argoCD(ctx)
+				if err != nil {
+					return err
+				}
+				if err := argoCD.DeleteApplication(ctx, name, true); err != ErrApplicationNotFound || !s.IgnoreDeleteNotFound {
+					return err
+				}
+				return nil
+			}, "argocd app delete %s --cascade", name); err != nil {
 				return err
 			}
 		} else if s.Verbose {
-			log.Printf("Argo CD not installed. Proceeding with cleanup...")
+			s.logf(ctx, "Argo CD not installed. Proceeding with cleanup...")
 		}
 	}
 
-	if err := s.deleteNamespace(name); err != nil {
+	if err := s.deleteNamespace(ctx, name); err != nil {
 		return err
 	}
 
 	if exists {
-		deleted := make(chan error, 1)
-		go func() {
-			defer close(deleted)
-			deleted <- func() error {
-				if err := s.exec("kubectl delete application -n argocd %s", name); err != nil {
-					msg := strings.TrimSpace(err.Error())
-					if strings.Contains(msg, `error: the server doesn't have a resource type "application"`) {
-						return nil
-					}
-					if strings.Contains(msg, `Error from server (NotFound):`) {
-						return nil
-					}
-					return err
-				}
-				return nil
-			}()
-		}()
+		if err := s.deleteResource(ctx, "application", name, "argocd"); err != nil {
+			return err
+		}
+		if s.DryRun {
+			return nil
+		}
 		delay := 10 * time.Second
-		select {
-		case err := <-deleted:
-			if err != nil {
-				return err
-			}
-		case <-time.After(delay):
+		if err := s.waitForDeletion(ctx, "application", name, "argocd", delay); err == ErrDeletionTimeout {
 			if s.Force {
 				if s.Verbose {
-					log.Printf("Removing finalizers for application argocd/%s", name)
+					s.logf(ctx, "Removing finalizers for application argocd/%s", name)
 				}
-				if err := s.RemoveFinalizers("application", name, "argocd"); err != nil {
+				if err := s.RemoveFinalizers(ctx, "application", name, "argocd"); err != nil {
 					return err
 				}
 			} else {
 				return fmt.Errorf("application argocd/%s was not deleted after %v. Maybe try --force?", name, delay)
 			}
+		} else if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+var ErrDeletionTimeout = fmt.Errorf("timed out waiting for deletion")
+
+// waitForDeletion blocks until the resource is gone, i.e.
+// until all of its finalizers have run
+func (s *Installer) waitForDeletion(
+	ctx context.Context,
+	resource string,
+	name string,
+	namespace string,
+	timeout time.Duration,
+) error {
+	obj, err := newObject(resource, name, namespace)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Get(
+		ctx,
+		types.NamespacedName{Name: name, Namespace: namespace},
+		obj,
+	); err != nil {
+		if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil
+		}
+		return err
+	}
+	waiter, err := s.waiter()
+	if err != nil {
+		return err
+	}
+	gvr, _ := meta.UnsafeGuessKindToResource(obj.GroupVersionKind())
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := waiter.Wait(waitCtx, readiness.Gone(gvr, namespace, name)); readiness.IsTimeout(err) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return ErrDeletionTimeout
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// waitForCRDs blocks until the CRDs applied with kubectl are
+// established, so that resources of them can be created
+func (s *Installer) waitForCRDs(ctx context.Context, names []string) error {
+	if s.DryRun || len(names) == 0 {
+		return nil
+	}
+	waiter, err := s.waiter()
+	if err != nil {
+		return err
+	}
+	conditions := make([]readiness.Condition, len(names))
+	for i, name := range names {
+		conditions[i] = readiness.CRDEstablished(name)
+	}
+	return waiter.Wait(ctx, conditions...)
+}
+
 func (s *Installer) RemoveFinalizers(
+	ctx context.Context,
 	resource string,
 	name string,
 	namespace string,
 ) error {
-	return s.exec(`kubectl patch %s %s -n %s -p '{"metadata":{"finalizers": []}}' --type=merge`, resource, name, namespace)
+	obj, err := newObject(resource, name, namespace)
+	if err != nil {
+		return err
+	}
+	return s.mutate(ctx, func() error {
+		if err := s.client.Patch(
+			ctx,
+			obj,
+			client.ConstantPatch(types.MergePatchType, []byte(`{"metadata":{"finalizers":[]}}`)),
+		); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		return nil
+	}, `kubectl patch %s %s -n %s -p '{"metadata":{"finalizers": []}}' --type=merge`, resource, name, namespace)
 }
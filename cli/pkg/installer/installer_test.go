@@ -1,6 +1,8 @@
 package installer
 
 import (
+	"context"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -13,6 +15,9 @@ import (
 
 func TestInstall(t *testing.T) {
 	kubeconfig := filepath.Join(homedir.HomeDir(), ".kube", "config")
+	if _, err := os.Stat(kubeconfig); os.IsNotExist(err) {
+		t.Skipf("requires a cluster, %s does not exist", kubeconfig)
+	}
 	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
 	require.NoError(t, err)
 	cl, err := client.New(config, client.Options{})
@@ -21,12 +26,12 @@ func TestInstall(t *testing.T) {
 	install := NewInstaller(cl)
 	t.Run("install", func(t *testing.T) {
 		defer install.Reuse()
-		assert.NoError(t, install.InstallAll())
+		assert.NoError(t, install.InstallAll(context.TODO()))
 		assert.NoError(t, install.CleanUp())
 	})
 	t.Run("uninstall", func(t *testing.T) {
 		defer install.Reuse()
-		assert.NoError(t, install.UninstallAll())
+		assert.NoError(t, install.UninstallAll(context.TODO()))
 		assert.NoError(t, install.CleanUp())
 	})
 }
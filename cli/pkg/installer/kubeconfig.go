@@ -0,0 +1,90 @@
+package installer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	clientcmdlatest "k8s.io/client-go/tools/clientcmd/api/latest"
+	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// kubeconfigEnv returns the environment variables that point
+// kubectl at the same cluster as the installer. kubectl has no
+// variable for the context, so a kubeconfig that only contains
+// the selected context is written to a temporary file.
+func (s *Installer) kubeconfigEnv() ([]string, error) {
+	if s.Context == "" {
+		if s.Kubeconfig == "" {
+			// Default loading rules, or in-cluster
+			return nil, nil
+		}
+		return []string{"KUBECONFIG=" + s.Kubeconfig}, nil
+	}
+	s.kubeconfigL.Lock()
+	defer s.kubeconfigL.Unlock()
+	if s.kubeconfigFile == "" {
+		path, err := writeContextKubeconfig(s.Kubeconfig, s.Context)
+		if err != nil {
+			return nil, err
+		}
+		s.kubeconfigFile = path
+	}
+	return []string{"KUBECONFIG=" + s.kubeconfigFile}, nil
+}
+
+// writeContextKubeconfig writes a kubeconfig whose current
+// context is the given one, along with only its cluster and
+// user, and returns its path. The file is only readable by
+// the current user because it holds credentials.
+func writeContextKubeconfig(kubeconfig string, context string) (string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	config, err := rules.Load()
+	if err != nil {
+		return "", err
+	}
+	if _, ok := config.Contexts[context]; !ok {
+		return "", fmt.Errorf("context '%s' does not exist in kubeconfig", context)
+	}
+	config.CurrentContext = context
+	if err := clientcmdapi.MinifyConfig(config); err != nil {
+		return "", err
+	}
+	versioned := &clientcmdv1.Config{}
+	if err := clientcmdlatest.Scheme.Convert(config, versioned, nil); err != nil {
+		return "", err
+	}
+	versioned.APIVersion = "v1"
+	versioned.Kind = "Config"
+	data, err := yaml.Marshal(versioned)
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile("", "foldy-kubeconfig-")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// removeKubeconfig deletes the kubeconfig written for kubectl
+func (s *Installer) removeKubeconfig() {
+	s.kubeconfigL.Lock()
+	defer s.kubeconfigL.Unlock()
+	if s.kubeconfigFile != "" {
+		os.Remove(s.kubeconfigFile)
+		s.kubeconfigFile = ""
+	}
+}
@@ -0,0 +1,84 @@
+package installer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: a
+clusters:
+- name: a
+  cluster:
+    server: https://a.example.com
+- name: b
+  cluster:
+    server: https://b.example.com
+contexts:
+- name: a
+  context:
+    cluster: a
+    user: a
+- name: b
+  context:
+    cluster: b
+    user: b
+users:
+- name: a
+  user:
+    token: token-a
+- name: b
+  user:
+    token: token-b
+`
+
+func TestKubeconfigEnvContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "foldy-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	kubeconfig := filepath.Join(dir, "config")
+	require.NoError(t, ioutil.WriteFile(kubeconfig, []byte(testKubeconfig), 0600))
+
+	install, executor := newFakeInstaller()
+	install.Kubeconfig = kubeconfig
+	install.Context = "b"
+	require.NoError(t, install.exec(context.TODO(), "kubectl get pods"))
+	require.Len(t, executor.Envs, 1)
+	require.Len(t, executor.Envs[0], 1)
+	path := strings.TrimPrefix(executor.Envs[0][0], "KUBECONFIG=")
+	config, err := clientcmd.LoadFromFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "b", config.CurrentContext)
+	assert.Equal(t, "https://b.example.com", config.Clusters["b"].Server)
+	assert.NotContains(t, config.AuthInfos, "a", "credentials of other contexts must not be copied")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	install.Close()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestKubeconfigEnvUnknownContext(t *testing.T) {
+	install, _ := newFakeInstaller()
+	install.Kubeconfig = filepath.Join("testdata", "missing")
+	install.Context = "missing"
+	err := install.exec(context.TODO(), "kubectl get pods")
+	require.Error(t, err)
+}
+
+func TestKubeconfigEnvDefault(t *testing.T) {
+	install, executor := newFakeInstaller()
+	require.NoError(t, install.exec(context.TODO(), "kubectl get pods"))
+	assert.Empty(t, executor.Envs[0])
+}
@@ -0,0 +1,245 @@
+package installer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+// The ledger lives outside of every component's namespaces
+// so that it survives uninstallation.
+const (
+	LedgerName      = "foldy-install-ledger"
+	LedgerNamespace = "kube-system"
+)
+
+// InstallRecord describes the last successful installation
+// of a component
+type InstallRecord struct {
+	Component   string    `json:"component"`
+	RepoURL     string    `json:"repoURL,omitempty"`
+	Revision    string    `json:"revision,omitempty"`
+	ConfigHash  string    `json:"configHash"`
+	InstalledAt time.Time `json:"installedAt"`
+	InstalledBy string    `json:"installedBy"`
+	CLIVersion  string    `json:"cliVersion"`
+	CRDs        []string  `json:"crds,omitempty"`       // Applied besides the component's own, depending on the config
+	Namespaces  []string  `json:"namespaces,omitempty"` // Created besides the component's own, depending on the config
+}
+
+// ConfigHash returns a digest of the settings that change
+// what is deployed. Everything else, such as timeouts or the
+// kubeconfig, only affects how the CLI runs and is left out.
+func ConfigHash() string {
+	config := EffectiveConfig()
+	settings := struct {
+		ArgoCD      ArgoCDConfig           `json:"argocd"`
+		Images      ImagesConfig           `json:"images"`
+		Ingress     IngressConfig          `json:"ingress"`
+		CertManager CertManagerConfig      `json:"certmanager"`
+		HelmValues  map[string]interface{} `json:"helmValues"`
+	}{
+		ArgoCD:      config.ArgoCD,
+		Images:      config.Images,
+		Ingress:     config.Ingress,
+		CertManager: config.CertManager,
+		HelmValues:  config.Helm.Values,
+	}
+	// encoding/json sorts map keys, so this is deterministic
+	data, err := json.Marshal(settings)
+	if err != nil {
+		panic(err)
+	}
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
+
+// componentSource returns the repository and revision that
+// the component is deployed from, if known
+func componentSource(s *Installer, comp Component) (string, string) {
+	switch c := comp.(type) {
+	case *ApplicationComponent:
+		return c.RepoURL, c.Revision
+	case *HelmComponent:
+		if c.RepoURL != "" {
+			return c.RepoURL, c.Chart + "@" + c.Version
+		}
+		return c.Chart, c.Version
+	case *CustomComponent:
+		if c.Source != nil {
+			return c.Source(s)
+		}
+	}
+	return "", ""
+}
+
+func currentUser() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		name = fmt.Sprintf("%s@%s", name, hostname)
+	}
+	return name
+}
+
+func (s *Installer) newInstallRecord(comp Component) *InstallRecord {
+	repoURL, revision := componentSource(s, comp)
+	var crds, namespaces []string
+	if app, ok := comp.(*ApplicationComponent); ok {
+		if app.OptionalCRDs != nil {
+			crds = app.OptionalCRDs(s)
+		}
+		if app.OptionalNamespaces != nil {
+			namespaces = app.OptionalNamespaces(s)
+		}
+	}
+	return &InstallRecord{
+		Component:   comp.GetName(),
+		RepoURL:     repoURL,
+		Revision:    revision,
+		ConfigHash:  ConfigHash(),
+		InstalledAt: time.Now().UTC(),
+		InstalledBy: currentUser(),
+		CLIVersion:  s.Version,
+		CRDs:        crds,
+		Namespaces:  namespaces,
+	}
+}
+
+// recordedCRDs returns the CRDs of the component along with
+// those that its last installation applied
+func recordedCRDs(comp Component, record *InstallRecord) []string {
+	crds := comp.GetCRDs()
+	if record == nil || len(record.CRDs) == 0 {
+		return crds
+	}
+	return append(append([]string{}, crds...), record.CRDs...)
+}
+
+// recordedNamespaces returns the namespaces of the component
+// along with those that its last installation created
+func recordedNamespaces(comp Component, record *InstallRecord) []string {
+	namespaces := comp.GetNamespaces()
+	if record == nil || len(record.Namespaces) == 0 {
+		return namespaces
+	}
+	return append(append([]string{}, namespaces...), record.Namespaces...)
+}
+
+// ReadLedger returns the install records of every component
+// that is known to be installed, keyed by component name
+func (s *Installer) ReadLedger(ctx context.Context) (map[string]*InstallRecord, error) {
+	records := make(map[string]*InstallRecord)
+	ledger := &corev1.ConfigMap{}
+	if err := s.client.Get(
+		ctx,
+		types.NamespacedName{Name: LedgerName, Namespace: LedgerNamespace},
+		ledger,
+	); errors.IsNotFound(err) {
+		return records, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for name, data := range ledger.Data {
+		record := &InstallRecord{}
+		if err := json.Unmarshal([]byte(data), record); err != nil {
+			return nil, fmt.Errorf("corrupt ledger entry for '%s': %v", name, err)
+		}
+		records[name] = record
+	}
+	return records, nil
+}
+
+// updateLedger sets (or removes, if record is nil) the entry
+// for a component, retrying if another component updated the
+// ledger concurrently
+func (s *Installer) updateLedger(ctx context.Context, name string, record *InstallRecord) error {
+	var value interface{}
+	if record != nil {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"data": map[string]interface{}{name: value},
+	})
+	if err != nil {
+		return err
+	}
+	return s.mutate(ctx, func() error {
+		s.ledgerL.Lock()
+		defer s.ledgerL.Unlock()
+		return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			ledger := &corev1.ConfigMap{}
+			if err := s.client.Get(
+				ctx,
+				types.NamespacedName{Name: LedgerName, Namespace: LedgerNamespace},
+				ledger,
+			); errors.IsNotFound(err) {
+				if record == nil {
+					return nil
+				}
+				return s.client.Create(ctx, &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      LedgerName,
+						Namespace: LedgerNamespace,
+						Labels: map[string]string{
+							"app.kubernetes.io/managed-by": "foldy",
+						},
+					},
+					Data: map[string]string{name: value.(string)},
+				})
+			} else if err != nil {
+				return err
+			}
+			if record == nil {
+				if _, ok := ledger.Data[name]; !ok {
+					return nil
+				}
+				delete(ledger.Data, name)
+			} else {
+				if ledger.Data == nil {
+					ledger.Data = make(map[string]string)
+				}
+				ledger.Data[name] = value.(string)
+			}
+			return s.client.Update(ctx, ledger)
+		})
+	}, `kubectl patch configmap %s -n %s --type=merge -p '%s'`, LedgerName, LedgerNamespace, string(patch))
+}
+
+// Drift compares the ledger entry of a component with what
+// the local configuration would install. It returns a list of
+// human readable differences, which is empty if there are none.
+func (s *Installer) Drift(comp Component, record *InstallRecord) []string {
+	if record == nil {
+		return []string{"no install record"}
+	}
+	var drift []string
+	repoURL, revision := componentSource(s, comp)
+	if record.RepoURL != repoURL {
+		drift = append(drift, fmt.Sprintf("repo %s -> %s", record.RepoURL, repoURL))
+	}
+	if record.Revision != revision {
+		drift = append(drift, fmt.Sprintf("revision %s -> %s", record.Revision, revision))
+	}
+	if record.ConfigHash != ConfigHash() {
+		drift = append(drift, "config changed")
+	}
+	return drift
+}
@@ -0,0 +1,83 @@
+package installer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLedger(t *testing.T) {
+	install, _ := newFakeInstaller()
+	install.Version = "1.2.3"
+	comp := &ApplicationComponent{
+		Name:     "app",
+		RepoURL:  "https://example.com/app.git",
+		Revision: "v1",
+	}
+	require.NoError(t, install.updateLedger(context.TODO(), "app", install.newInstallRecord(comp)))
+	require.NoError(t, install.updateLedger(context.TODO(), "other", install.newInstallRecord(comp)))
+	records, err := install.ReadLedger(context.TODO())
+	require.NoError(t, err)
+	require.Contains(t, records, "app")
+	assert.Equal(t, "v1", records["app"].Revision)
+	assert.Equal(t, "1.2.3", records["app"].CLIVersion)
+	assert.Empty(t, install.Drift(comp, records["app"]))
+
+	comp.Revision = "v2"
+	viper.Set("ingress.enabled", true)
+	defer viper.Set("ingress.enabled", nil)
+	assert.Equal(t, []string{"revision v1 -> v2", "config changed"}, install.Drift(comp, records["app"]))
+
+	require.NoError(t, install.updateLedger(context.TODO(), "app", nil))
+	records, err = install.ReadLedger(context.TODO())
+	require.NoError(t, err)
+	assert.NotContains(t, records, "app")
+	assert.Contains(t, records, "other")
+}
+
+func TestConfigHashIgnoresFlags(t *testing.T) {
+	hash := ConfigHash()
+	for key, value := range map[string]interface{}{
+		"timeout":          "1h",
+		"componentTimeout": "30m",
+		"atomic":           true,
+		"skipDoctor":       true,
+		"kubeconfig":       "/tmp/kubeconfig",
+		"context":          "other",
+		"mode":             InstallModeHelm,
+	} {
+		viper.Set(key, value)
+		defer viper.Set(key, nil)
+		assert.Equal(t, hash, ConfigHash(), key)
+	}
+	viper.Set("helm.values", map[string]interface{}{"app": map[string]interface{}{"replicas": 2}})
+	defer viper.Set("helm.values", nil)
+	assert.NotEqual(t, hash, ConfigHash())
+}
+
+func TestLedgerOptionalCRDs(t *testing.T) {
+	install, _ := newFakeInstaller()
+	comp := &ApplicationComponent{
+		Name: "app",
+		CRDs: []string{"apps.example.com"},
+		OptionalCRDs: func(s *Installer) []string {
+			if EffectiveConfig().Ingress.Enabled {
+				return []string{"routes.example.com"}
+			}
+			return nil
+		},
+	}
+	record := install.newInstallRecord(comp)
+	assert.Empty(t, record.CRDs)
+	assert.Equal(t, []string{"apps.example.com"}, recordedCRDs(comp, record))
+
+	viper.Set("ingress.enabled", true)
+	defer viper.Set("ingress.enabled", nil)
+	record = install.newInstallRecord(comp)
+	assert.Equal(t, []string{"routes.example.com"}, record.CRDs)
+	assert.Equal(t, []string{"apps.example.com", "routes.example.com"}, recordedCRDs(comp, record))
+	assert.Equal(t, []string{"apps.example.com"}, comp.CRDs)
+}
@@ -0,0 +1,52 @@
+package installer
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// PlanStep is a single mutation the installer would have
+// performed had it not been running in dry-run mode.
+type PlanStep struct {
+	Component string
+	Command   string
+}
+
+// Plan is the ordered list of mutations recorded by an
+// Installer in dry-run mode.
+type Plan struct {
+	Steps []*PlanStep
+	l     sync.Mutex
+}
+
+func (p *Plan) add(component string, command string) {
+	p.l.Lock()
+	defer p.l.Unlock()
+	p.Steps = append(p.Steps, &PlanStep{
+		Component: component,
+		Command:   command,
+	})
+}
+
+// Print writes the plan to w, grouping consecutive steps
+// under the name of the component that produced them.
+func (p *Plan) Print(w io.Writer) {
+	p.l.Lock()
+	defer p.l.Unlock()
+	if len(p.Steps) == 0 {
+		fmt.Fprintln(w, "# no changes")
+		return
+	}
+	var component string
+	for i, step := range p.Steps {
+		if i == 0 || step.Component != component {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			component = step.Component
+			fmt.Fprintf(w, "# %s\n", component)
+		}
+		fmt.Fprintln(w, step.Command)
+	}
+}
@@ -0,0 +1,143 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+)
+
+// NewSessionInstaller returns an installer that only talks to
+// the Argo CD API at the endpoint of the session, for when
+// there is no access to the cluster. It can report the status
+// of the components and install or upgrade their Applications,
+// but nothing that needs the cluster itself.
+func NewSessionInstaller(session *Session) *Installer {
+	s := NewInstaller(nil)
+	s.Session = session
+	s.Remote = true
+	s.ArgoCD = NewArgoCDTokenClient(session.Endpoint, session.Token)
+	return s
+}
+
+// errNeedsCluster is returned for what can't be done through
+// the Argo CD API alone
+func (s *Installer) errNeedsCluster(what string) error {
+	return fmt.Errorf("%s needs access to the cluster, but there is only the session for %s", what, s.Session.Endpoint)
+}
+
+// installRemote creates or updates the Application of the
+// component through the session. Its hooks are skipped, as
+// they create namespaces and ingress objects directly, so the
+// namespaces have to exist already, e.g. from an installation
+// with access to the cluster.
+func (s *Installer) installRemote(ctx context.Context, comp Component) error {
+	app, ok := comp.(*ApplicationComponent)
+	if !ok {
+		if comp.GetName() == "argocd" {
+			// It serves the session, so it's up
+			return nil
+		}
+		return s.errNeedsCluster("installing " + comp.GetName())
+	}
+	if s.Bundle != nil {
+		return s.errNeedsCluster("installing from a bundle")
+	}
+	if app.PreInstall != nil || app.PostInstall != nil {
+		s.logf(ctx, "Warning: skipping the hooks of %s, which need access to the cluster", app.Name)
+	}
+	repoURL, values, err := app.source(ctx, s)
+	if err != nil {
+		return err
+	}
+	return s.CreateApplication(ctx, app.Name, repoURL, app.Path, app.Revision, values)
+}
+
+// remoteStatus reports the components' Applications as seen
+// by Argo CD, which is all that the session can see. Argo CD
+// is healthy if it answers, and other components that aren't
+// Applications are left out.
+func (s *Installer) remoteStatus(ctx context.Context, g *Graph) ([]*ComponentStatus, error) {
+	argoCD, err := s.argoCD(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := []*ComponentStatus{}
+	var argocd *ComponentStatus
+	for _, comp := range g.Components() {
+		status := &ComponentStatus{Name: comp.GetName()}
+		if _, ok := comp.(*ApplicationComponent); !ok {
+			if comp.GetName() == "argocd" {
+				argocd = status
+				statuses = append(statuses, status)
+			}
+			continue
+		}
+		app, err := argoCD.GetApplication(ctx, comp.GetName())
+		if err == ErrApplicationNotFound {
+			status.NotInstalled = true
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %v", comp.GetName(), err)
+		} else {
+			status.Application = &ApplicationStatus{Sync: "Unknown", Health: "Unknown"}
+			if app.Status != nil && app.Status.Sync.Status != "" {
+				status.Application.Sync = app.Status.Sync.Status
+			}
+			if app.Status != nil && app.Status.Health.Status != "" {
+				status.Application.Health = app.Status.Health.Status
+			}
+			status.Healthy = status.Application.IsHealthy()
+		}
+		statuses = append(statuses, status)
+	}
+	if argocd != nil {
+		// Argo CD is up if it accepts the session
+		if _, err := argoCD.GetApplication(ctx, "argocd"); err != nil && err != ErrApplicationNotFound {
+			return nil, fmt.Errorf("argocd: %v", err)
+		}
+		argocd.Healthy = true
+	}
+	return statuses, nil
+}
+
+// remoteUpgrade compares the revision of the component's
+// Application with the desired one. Components that aren't
+// Applications can't be upgraded through the session, so nil
+// is returned for them.
+func (s *Installer) remoteUpgrade(ctx context.Context, comp Component) (*ComponentUpgrade, error) {
+	if _, ok := comp.(*ApplicationComponent); !ok {
+		return nil, nil
+	}
+	argoCD, err := s.argoCD(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, desired := componentSource(s, comp)
+	upgrade := &ComponentUpgrade{Component: comp.GetName(), To: desired}
+	app, err := argoCD.GetApplication(ctx, comp.GetName())
+	if err == ErrApplicationNotFound {
+		upgrade.Action = UpgradeActionNotInstalled
+		return upgrade, nil
+	} else if err != nil {
+		return nil, err
+	}
+	upgrade.From = app.Spec.Source.TargetRevision
+	upgrade.Action = upgradeAction(upgrade.From, upgrade.To)
+	return upgrade, nil
+}
+
+// remoteExists returns true if the component's Application
+// exists. Argo CD serving the session exists by definition.
+func (s *Installer) remoteExists(ctx context.Context, comp Component) (bool, error) {
+	if _, ok := comp.(*ApplicationComponent); !ok {
+		return comp.GetName() == "argocd", nil
+	}
+	argoCD, err := s.argoCD(ctx)
+	if err != nil {
+		return false, err
+	}
+	if _, err := argoCD.GetApplication(ctx, comp.GetName()); err == ErrApplicationNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,66 @@
+package installer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeSessionInstaller returns an installer without access
+// to the cluster, as its client is nil
+func newFakeSessionInstaller() (*Installer, *FakeArgoCDClient) {
+	install := NewSessionInstaller(&Session{Endpoint: "https://argocd.example.com", Token: "token"})
+	argoCD := NewFakeArgoCDClient()
+	install.ArgoCD = argoCD
+	return install, argoCD
+}
+
+func TestSessionInstallerInstall(t *testing.T) {
+	install, argoCD := newFakeSessionInstaller()
+	require.NoError(t, install.InstallAll(context.TODO()))
+	require.Contains(t, argoCD.Applications, "foldy")
+	assert.Equal(t, 1, argoCD.Synced["foldy"])
+	assert.Equal(t, "charts/apps", argoCD.Applications["foldy"].Spec.Source.Path)
+}
+
+func TestSessionInstallerStatus(t *testing.T) {
+	install, argoCD := newFakeSessionInstaller()
+	statuses, err := install.Status(context.TODO())
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "argocd", statuses[0].Name)
+	assert.True(t, statuses[0].Healthy)
+	assert.True(t, statuses[1].NotInstalled)
+
+	argoCD.Applications["foldy"] = &ArgoCDApplication{Status: &ArgoCDApplicationStatus{
+		Sync:   ArgoCDSyncStatus{Status: "OutOfSync"},
+		Health: ArgoCDHealthStatus{Status: "Healthy"},
+	}}
+	statuses, err = install.Status(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, &ApplicationStatus{Sync: "OutOfSync", Health: "Healthy"}, statuses[1].Application)
+	assert.True(t, statuses[1].Degraded())
+
+	argoCD.Fail("get", "argocd", ErrSessionExpired)
+	_, err = install.Status(context.TODO())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ErrSessionExpired.Error())
+}
+
+func TestSessionInstallerUpgrade(t *testing.T) {
+	install, argoCD := newFakeSessionInstaller()
+	argoCD.Applications["foldy"] = &ArgoCDApplication{Spec: ArgoCDApplicationSpec{
+		Source: ArgoCDApplicationSource{TargetRevision: "v0.1.0"},
+	}}
+	upgrades, err := install.PlanUpgrade(context.TODO(), nil)
+	require.NoError(t, err)
+	require.Len(t, upgrades, 1, "argocd can't be upgraded through the session")
+	assert.Equal(t, "foldy", upgrades[0].Component)
+	assert.Equal(t, "v0.1.0", upgrades[0].From)
+	assert.Equal(t, UpgradeActionUpgrade, upgrades[0].Action)
+	_, err = install.Upgrade(context.TODO(), nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, "HEAD", argoCD.Applications["foldy"].Spec.Source.TargetRevision)
+}
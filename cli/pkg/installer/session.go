@@ -0,0 +1,161 @@
+package installer
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Session is a token obtained with 'foldy login', which
+// lets the CLI talk to a deployment without a kubeconfig
+type Session struct {
+	Endpoint  string     `json:"endpoint"`
+	Username  string     `json:"username,omitempty"`
+	Token     string     `json:"token"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"` // Unset if the token doesn't expire
+}
+
+// Expired returns true if the token is past its expiry
+func (s *Session) Expired(now time.Time) bool {
+	return s.ExpiresAt != nil && !now.Before(*s.ExpiresAt)
+}
+
+// SessionExpiredError is returned for a stored session whose
+// token has expired
+type SessionExpiredError struct {
+	Endpoint  string
+	ExpiresAt time.Time
+}
+
+func (e *SessionExpiredError) Error() string {
+	return fmt.Sprintf("session for %s expired at %s, log in again with 'foldy login %s'",
+		e.Endpoint, e.ExpiresAt.Local().Format(time.RFC1123), e.Endpoint)
+}
+
+// NormalizeEndpoint returns the endpoint as a base URL,
+// defaulting to https if it has no scheme
+func NormalizeEndpoint(endpoint string) (string, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme '%s' in endpoint %s", u.Scheme, endpoint)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("endpoint %s has no host", endpoint)
+	}
+	return strings.TrimRight(u.String(), "/"), nil
+}
+
+// tokenExpiry returns the exp claim of a JWT, or nil if the
+// token isn't a JWT or doesn't expire. The signature isn't
+// verified, the server does that.
+func tokenExpiry(token string) *time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return nil
+	}
+	expiresAt := time.Unix(claims.Exp, 0).UTC()
+	return &expiresAt
+}
+
+// Login authenticates against the Argo CD API at the endpoint
+// and returns the new session
+func Login(ctx context.Context, endpoint string, username string, password string) (*Session, error) {
+	endpoint, err := NormalizeEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	argoCD := NewArgoCDAPIClient(func(ctx context.Context) (string, error) {
+		return endpoint, nil
+	}, username, password)
+	token, err := argoCD.Login(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		Endpoint:  endpoint,
+		Username:  username,
+		Token:     token,
+		ExpiresAt: tokenExpiry(token),
+	}, nil
+}
+
+// Logout revokes the session's token on the server
+func Logout(ctx context.Context, session *Session) error {
+	return NewArgoCDTokenClient(session.Endpoint, session.Token).Logout(ctx)
+}
+
+// Session returns the stored session for the endpoint, or
+// the current one if the endpoint is empty. It returns nil
+// if there is no such session.
+func (c *Credentials) Session(endpoint string) *Session {
+	if endpoint == "" {
+		endpoint = c.CurrentEndpoint
+	}
+	for _, session := range c.Sessions {
+		if session.Endpoint == endpoint {
+			return session
+		}
+	}
+	return nil
+}
+
+// SetSession stores the session, replacing any previous one
+// for its endpoint, and makes it the current session
+func (c *Credentials) SetSession(session *Session) {
+	c.RemoveSession(session.Endpoint)
+	c.Sessions = append(c.Sessions, session)
+	c.CurrentEndpoint = session.Endpoint
+}
+
+// RemoveSession forgets the session for the endpoint. It
+// returns false if there was none.
+func (c *Credentials) RemoveSession(endpoint string) bool {
+	for i, session := range c.Sessions {
+		if session.Endpoint == endpoint {
+			c.Sessions = append(c.Sessions[:i], c.Sessions[i+1:]...)
+			if c.CurrentEndpoint == endpoint {
+				c.CurrentEndpoint = ""
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveSession returns the current session stored in the
+// credentials file, or nil if not logged in. An expired
+// session yields a SessionExpiredError.
+func ActiveSession(file string) (*Session, error) {
+	creds, err := LoadCredentials(file)
+	if err != nil {
+		return nil, err
+	}
+	session := creds.Session("")
+	if session == nil {
+		return nil, nil
+	}
+	if session.Expired(time.Now()) {
+		return nil, &SessionExpiredError{Endpoint: session.Endpoint, ExpiresAt: *session.ExpiresAt}
+	}
+	return session, nil
+}
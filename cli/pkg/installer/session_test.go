@@ -0,0 +1,123 @@
+package installer
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenExpiry(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","exp":1600000000}`))
+	expiresAt := tokenExpiry("eyJhbGciOiJIUzI1NiJ9." + payload + ".signature")
+	require.NotNil(t, expiresAt)
+	assert.Equal(t, time.Unix(1600000000, 0).UTC(), *expiresAt)
+	assert.Nil(t, tokenExpiry("token-1"), "opaque tokens don't expire")
+}
+
+func TestNormalizeEndpoint(t *testing.T) {
+	endpoint, err := NormalizeEndpoint("argocd.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, "https://argocd.example.com", endpoint)
+	endpoint, err = NormalizeEndpoint("http://localhost:8080")
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080", endpoint)
+	_, err = NormalizeEndpoint("ftp://example.com")
+	assert.Error(t, err)
+}
+
+func TestLoginLogout(t *testing.T) {
+	server := &fakeArgoCDServer{apps: make(map[string]*ArgoCDApplication)}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	file, cleanup := tempCredentialsFile(t)
+	defer cleanup()
+
+	_, err := Login(context.TODO(), httpServer.URL, "admin", "wrong")
+	require.Error(t, err)
+	session, err := Login(context.TODO(), httpServer.URL, "admin", "password")
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", session.Token)
+	creds := &Credentials{Password: "password"}
+	creds.SetSession(session)
+	require.NoError(t, SaveCredentials(file, creds))
+
+	active, err := ActiveSession(file)
+	require.NoError(t, err)
+	require.NotNil(t, active)
+	assert.Equal(t, httpServer.URL, active.Endpoint)
+
+	// The stored token is used without logging in again, as
+	// the cluster routes the endpoint to argocd-server
+	install, _ := newFakeInstaller(ingressObjects("argocd", "127.0.0.1", true, false)[0])
+	install.ArgoCD = nil
+	install.Session = active
+	argoCD, err := install.argoCD(context.TODO())
+	require.NoError(t, err)
+	app := &ArgoCDApplication{}
+	app.Metadata.Name = "foo"
+	require.NoError(t, argoCD.CreateApplication(context.TODO(), app, false))
+	assert.Equal(t, 1, server.logins)
+
+	require.NoError(t, Logout(context.TODO(), active))
+	assert.Equal(t, ErrSessionExpired, argoCD.SyncApplication(context.TODO(), "foo"))
+	assert.Equal(t, 1, server.logins, "a token client must not log in by itself")
+
+	creds, err = LoadCredentials(file)
+	require.NoError(t, err)
+	assert.True(t, creds.RemoveSession(httpServer.URL))
+	assert.Empty(t, creds.CurrentEndpoint)
+	assert.Equal(t, "password", creds.Password)
+}
+
+func TestActiveSessionExpired(t *testing.T) {
+	file, cleanup := tempCredentialsFile(t)
+	defer cleanup()
+	expiresAt := time.Now().Add(-time.Minute)
+	creds := &Credentials{}
+	creds.SetSession(&Session{Endpoint: "https://argocd.example.com", Token: "token", ExpiresAt: &expiresAt})
+	require.NoError(t, SaveCredentials(file, creds))
+	session, err := ActiveSession(file)
+	assert.Nil(t, session)
+	require.IsType(t, &SessionExpiredError{}, err)
+	assert.Contains(t, err.Error(), "foldy login https://argocd.example.com")
+}
+
+func TestSessionForOtherCluster(t *testing.T) {
+	server := &fakeArgoCDServer{apps: make(map[string]*ArgoCDApplication)}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	session, err := Login(context.TODO(), httpServer.URL, "admin", "password")
+	require.NoError(t, err)
+
+	// Nothing routes the endpoint to this cluster's Argo CD
+	install, _ := newFakeInstaller(healthyArgoCD("password")...)
+	install.ArgoCD = nil
+	install.Session = session
+	argoCD, err := install.argoCD(context.TODO())
+	require.NoError(t, err)
+	require.IsType(t, &ArgoCDAPIClient{}, argoCD)
+	assert.Equal(t, "admin", argoCD.(*ArgoCDAPIClient).Username)
+	assert.Empty(t, server.apps)
+}
+
+func TestSessionRejected(t *testing.T) {
+	server := &fakeArgoCDServer{apps: make(map[string]*ArgoCDApplication)}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	session, err := Login(context.TODO(), httpServer.URL, "admin", "password")
+	require.NoError(t, err)
+	require.NoError(t, Logout(context.TODO(), session))
+
+	objs := append(healthyArgoCD("password"), ingressObjects("argocd", "127.0.0.1", true, false)[0])
+	install, _ := newFakeInstaller(objs...)
+	install.ArgoCD = nil
+	install.Session = session
+	argoCD, err := install.argoCD(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, "admin", argoCD.(*ArgoCDAPIClient).Username, "a rejected token must fall back to the admin password")
+}
@@ -0,0 +1,220 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ErrDegraded = fmt.Errorf("one or more components are degraded")
+
+// ApplicationStatus is the state of a component's Argo CD
+// Application as reported by the application controller
+type ApplicationStatus struct {
+	Sync   string `json:"sync"`
+	Health string `json:"health"`
+}
+
+func (a *ApplicationStatus) IsHealthy() bool {
+	return a.Sync == "Synced" && a.Health == "Healthy"
+}
+
+// Degraded returns true if the component is installed but
+// not healthy. Components that were never installed, such as
+// optional ones, are not degraded.
+func (c *ComponentStatus) Degraded() bool {
+	return !c.Healthy && !c.NotInstalled
+}
+
+// ComponentStatus summarizes the health of an installed
+// component
+type ComponentStatus struct {
+	Name                   string             `json:"name"`
+	Healthy                bool               `json:"healthy"`
+	NotInstalled           bool               `json:"notInstalled,omitempty"` // Neither recorded in the ledger nor any of its namespaces exist
+	MissingNamespaces      []string           `json:"missingNamespaces,omitempty"`
+	MissingCRDs            []string           `json:"missingCRDs,omitempty"`
+	Application            *ApplicationStatus `json:"application,omitempty"`
+	UnavailableDeployments []string           `json:"unavailableDeployments,omitempty"`
+	Record                 *InstallRecord     `json:"record,omitempty"`
+	Drift                  []string           `json:"drift,omitempty"`
+}
+
+// Status reports the health of every registered component
+// without changing anything in the cluster
+func (s *Installer) Status(ctx context.Context) ([]*ComponentStatus, error) {
+	g, err := s.Graph()
+	if err != nil {
+		return nil, err
+	}
+	if s.Remote {
+		return s.remoteStatus(ctx, g)
+	}
+	records, err := s.ReadLedger(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := []*ComponentStatus{}
+	for _, comp := range g.Components() {
+		status, err := s.componentStatus(ctx, comp, records[comp.GetName()])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", comp.GetName(), err)
+		}
+		status.Record = records[comp.GetName()]
+		status.NotInstalled = status.Record == nil &&
+			len(status.MissingNamespaces) == len(comp.GetNamespaces())
+		if !status.NotInstalled {
+			status.Drift = s.Drift(comp, status.Record)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// componentStatus checks the CRDs of the component along with
+// those recorded by its last installation, if any
+func (s *Installer) componentStatus(ctx context.Context, comp Component, record *InstallRecord) (*ComponentStatus, error) {
+	status := &ComponentStatus{Name: comp.GetName()}
+	for _, namespace := range comp.GetNamespaces() {
+		exists, err := NamespaceExists(ctx, s.client, namespace)
+		if err != nil {
+			return nil, err
+		} else if !exists {
+			status.MissingNamespaces = append(status.MissingNamespaces, namespace)
+			continue
+		}
+		deployments := &appsv1.DeploymentList{}
+		if err := s.client.List(
+			ctx,
+			deployments,
+			client.InNamespace(namespace),
+		); err != nil {
+			return nil, err
+		}
+		for _, deployment := range deployments.Items {
+			if err := DeploymentIsHealthy(ctx,
+				s.client,
+				deployment.Name,
+				namespace,
+			); err == ErrDeploymentNotReady {
+				status.UnavailableDeployments = append(
+					status.UnavailableDeployments,
+					fmt.Sprintf("%s/%s", namespace, deployment.Name))
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, crd := range recordedCRDs(comp, record) {
+		obj, err := newObject("crd", crd, "")
+		if err != nil {
+			return nil, err
+		}
+		if err := s.client.Get(
+			ctx,
+			types.NamespacedName{Name: crd},
+			obj,
+		); errors.IsNotFound(err) {
+			status.MissingCRDs = append(status.MissingCRDs, crd)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := comp.(*ApplicationComponent); ok {
+		app, err := s.applicationStatus(ctx, comp.GetName())
+		if err != nil {
+			return nil, err
+		}
+		status.Application = app
+	}
+	status.Healthy = len(status.MissingNamespaces) == 0 &&
+		len(status.MissingCRDs) == 0 &&
+		len(status.UnavailableDeployments) == 0 &&
+		(status.Application == nil || status.Application.IsHealthy())
+	return status, nil
+}
+
+func (s *Installer) applicationStatus(ctx context.Context, name string) (*ApplicationStatus, error) {
+	if err := s.IsArgoCDHealthy(ctx); err != nil {
+		if err != ErrDeploymentNotReady && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		// Whatever the Application says is stale
+		return &ApplicationStatus{Sync: "Unknown", Health: "Unknown"}, nil
+	}
+	obj, err := newObject("application", name, "argocd")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.client.Get(
+		ctx,
+		types.NamespacedName{Name: name, Namespace: "argocd"},
+		obj,
+	); errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return &ApplicationStatus{Sync: "Missing", Health: "Missing"}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sync, _, _ := unstructured.NestedString(obj.Object, "status", "sync", "status")
+	health, _, _ := unstructured.NestedString(obj.Object, "status", "health", "status")
+	if sync == "" {
+		sync = "Unknown"
+	}
+	if health == "" {
+		health = "Unknown"
+	}
+	return &ApplicationStatus{Sync: sync, Health: health}, nil
+}
+
+// PrintStatusTable writes the statuses as a human readable table
+func PrintStatusTable(w io.Writer, statuses []*ComponentStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "COMPONENT\tSTATUS\tNAMESPACES\tCRDS\tAPPLICATION\tUNAVAILABLE\tDRIFT")
+	for _, status := range statuses {
+		health := "Healthy"
+		if status.NotInstalled {
+			health = "NotInstalled"
+		} else if !status.Healthy {
+			health = "Degraded"
+		}
+		namespaces := "ok"
+		if len(status.MissingNamespaces) > 0 {
+			namespaces = "missing " + strings.Join(status.MissingNamespaces, ",")
+		}
+		crds := "ok"
+		if len(status.MissingCRDs) > 0 {
+			crds = fmt.Sprintf("%d missing", len(status.MissingCRDs))
+		}
+		app := "-"
+		if status.Application != nil {
+			app = fmt.Sprintf("%s/%s", status.Application.Sync, status.Application.Health)
+		}
+		unavailable := "-"
+		if len(status.UnavailableDeployments) > 0 {
+			unavailable = strings.Join(status.UnavailableDeployments, ",")
+		}
+		drift := "-"
+		if len(status.Drift) > 0 {
+			drift = strings.Join(status.Drift, ",")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", status.Name, health, namespaces, crds, app, unavailable, drift)
+	}
+	return tw.Flush()
+}
+
+// PrintStatusJSON writes the statuses as an indented JSON array
+func PrintStatusJSON(w io.Writer, statuses []*ComponentStatus) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(statuses)
+}
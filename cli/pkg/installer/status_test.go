@@ -0,0 +1,74 @@
+package installer
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestStatus(t *testing.T) {
+	app, err := newObject("application", "foldy", "argocd")
+	require.NoError(t, err)
+	require.NoError(t, unstructured.SetNestedField(app.Object, "Synced", "status", "sync", "status"))
+	require.NoError(t, unstructured.SetNestedField(app.Object, "Progressing", "status", "health", "status"))
+	objs := append(healthyArgoCD("password"),
+		app,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "foldy"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "argo"}})
+	install, _ := newFakeInstaller(objs...)
+	statuses, err := install.Status(context.TODO())
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+
+	argocd := statuses[0]
+	assert.Equal(t, "argocd", argocd.Name)
+	assert.Empty(t, argocd.MissingNamespaces)
+	assert.Empty(t, argocd.UnavailableDeployments)
+	assert.Nil(t, argocd.Application)
+	assert.Len(t, argocd.MissingCRDs, 2)
+	assert.False(t, argocd.Healthy)
+
+	foldy := statuses[1]
+	assert.Equal(t, "foldy", foldy.Name)
+	assert.Equal(t, []string{"argo-events"}, foldy.MissingNamespaces)
+	assert.Equal(t, &ApplicationStatus{Sync: "Synced", Health: "Progressing"}, foldy.Application)
+	assert.NotContains(t, foldy.MissingCRDs, "certificates.cert-manager.io", "cert-manager was not installed by foldy")
+	assert.False(t, foldy.Healthy)
+}
+
+func TestStatusRecordedCRDs(t *testing.T) {
+	install, _ := newFakeInstaller(healthyArgoCD("password")...)
+	require.NoError(t, install.updateLedger(context.TODO(), "foldy", &InstallRecord{
+		Component: "foldy",
+		CRDs:      certManagerCRDs,
+	}))
+	statuses, err := install.Status(context.TODO())
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.Contains(t, statuses[1].MissingCRDs, "certificates.cert-manager.io")
+}
+
+func TestStatusNotInstalled(t *testing.T) {
+	// Only Argo CD was installed
+	install, _ := newFakeInstaller(healthyArgoCD("password")...)
+	require.NoError(t, install.updateLedger(context.TODO(), "argocd", install.newInstallRecord(GetComponentByName("argocd"))))
+	statuses, err := install.Status(context.TODO())
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.False(t, statuses[0].NotInstalled)
+	assert.True(t, statuses[0].Degraded(), "argocd is missing its CRDs")
+
+	foldy := statuses[1]
+	assert.True(t, foldy.NotInstalled)
+	assert.False(t, foldy.Degraded())
+	assert.Empty(t, foldy.Drift)
+	var table bytes.Buffer
+	require.NoError(t, PrintStatusTable(&table, statuses))
+	assert.Contains(t, table.String(), "NotInstalled")
+}
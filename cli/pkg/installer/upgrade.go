@@ -0,0 +1,271 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Actions of a ComponentUpgrade
+const (
+	UpgradeActionNone         = "none"          // Desired version is deployed
+	UpgradeActionUpgrade      = "upgrade"       // Newer, or not comparable
+	UpgradeActionDowngrade    = "downgrade"     // Older than what's deployed
+	UpgradeActionNotInstalled = "not-installed" // Left alone, use install
+)
+
+// ComponentUpgrade is the difference between the deployed and
+// the desired version of a component
+type ComponentUpgrade struct {
+	Component string `json:"component"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Action    string `json:"action"`
+}
+
+// DowngradeError is returned when upgrading would downgrade
+// components and downgrades were not allowed
+type DowngradeError struct {
+	Downgrades []*ComponentUpgrade
+}
+
+func (e *DowngradeError) Error() string {
+	var descs []string
+	for _, u := range e.Downgrades {
+		descs = append(descs, fmt.Sprintf("%s %s -> %s", u.Component, u.From, u.To))
+	}
+	return fmt.Sprintf("refusing to downgrade %s (use --allow-downgrade)", strings.Join(descs, ", "))
+}
+
+// versionOf extracts a semantic version from a revision, which
+// may be a git tag, a chart version or an image reference
+func versionOf(revision string) (*semver.Version, bool) {
+	tag := revision
+	if i := strings.LastIndex(tag, ":"); i != -1 && i > strings.LastIndex(tag, "/") {
+		tag = tag[i+1:]
+	} else if i := strings.LastIndex(tag, "@"); i != -1 {
+		tag = tag[i+1:]
+	}
+	version, err := semver.NewVersion(tag)
+	if err != nil {
+		return nil, false
+	}
+	return version, true
+}
+
+// upgradeAction decides how to get from one revision to the
+// other. Revisions that aren't versions, such as HEAD or
+// latest, can't be ordered, so they are upgraded unless they
+// are the same, in which case redeploy decides.
+func upgradeAction(from string, to string) string {
+	if from == to {
+		return UpgradeActionNone
+	}
+	fromVersion, ok := versionOf(from)
+	if !ok {
+		return UpgradeActionUpgrade
+	}
+	toVersion, ok := versionOf(to)
+	if !ok {
+		return UpgradeActionUpgrade
+	}
+	switch toVersion.Compare(fromVersion) {
+	case 0:
+		return UpgradeActionNone
+	case -1:
+		return UpgradeActionDowngrade
+	}
+	return UpgradeActionUpgrade
+}
+
+// redeploy returns true if a component whose revision didn't
+// change must be installed again anyway. That is the case if
+// its config changed, or if it tracks a branch such as HEAD
+// and was installed by another version of the CLI, which may
+// have been released along with a new head.
+func (s *Installer) redeploy(comp Component, record *InstallRecord, revision string) bool {
+	if record == nil {
+		return false
+	}
+	if len(s.Drift(comp, record)) > 0 {
+		return true
+	}
+	_, versioned := versionOf(revision)
+	return !versioned && record.CLIVersion != s.Version
+}
+
+// deployedRevision returns the revision of the component that
+// is running in the cluster, or "" if it isn't known
+func (s *Installer) deployedRevision(ctx context.Context, comp Component, record *InstallRecord) (string, error) {
+	if record != nil {
+		return record.Revision, nil
+	}
+	if comp.GetName() != "argocd" {
+		return "", nil
+	}
+	// Argo CD may have been installed before the ledger existed
+	deployment := &appsv1.Deployment{}
+	if err := s.client.Get(
+		ctx,
+		types.NamespacedName{Name: "argocd-server", Namespace: "argocd"},
+		deployment,
+	); errors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return deployment.Spec.Template.Spec.Containers[0].Image, nil
+}
+
+// PlanUpgrade compares the desired version of each component
+// with the deployed one, in dependency order. If names is
+// empty, every component is considered.
+func (s *Installer) PlanUpgrade(ctx context.Context, names []string) ([]*ComponentUpgrade, error) {
+	g, err := s.Graph()
+	if err != nil {
+		return nil, err
+	}
+	components := g.Components()
+	if len(names) > 0 {
+		if components, err = g.Subset(names, false); err != nil {
+			return nil, err
+		}
+	}
+	var upgrades []*ComponentUpgrade
+	if s.Remote {
+		for _, comp := range components {
+			upgrade, err := s.remoteUpgrade(ctx, comp)
+			if err != nil {
+				return nil, err
+			} else if upgrade != nil {
+				upgrades = append(upgrades, upgrade)
+			}
+		}
+		return upgrades, nil
+	}
+	records, err := s.ReadLedger(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, comp := range components {
+		_, desired := componentSource(s, comp)
+		upgrade := &ComponentUpgrade{Component: comp.GetName(), To: desired}
+		exists, err := s.componentExists(ctx, comp)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			upgrade.Action = UpgradeActionNotInstalled
+			upgrades = append(upgrades, upgrade)
+			continue
+		}
+		record := records[comp.GetName()]
+		if upgrade.From, err = s.deployedRevision(ctx, comp, record); err != nil {
+			return nil, err
+		}
+		upgrade.Action = upgradeAction(upgrade.From, upgrade.To)
+		if upgrade.Action == UpgradeActionNone && s.redeploy(comp, record, upgrade.To) {
+			upgrade.Action = UpgradeActionUpgrade
+		}
+		upgrades = append(upgrades, upgrade)
+	}
+	return upgrades, nil
+}
+
+// Upgrade brings every installed component to its desired
+// version, one at a time in dependency order, waiting for each
+// to become healthy before moving on to its dependees.
+func (s *Installer) Upgrade(ctx context.Context, names []string, allowDowngrade bool) ([]*ComponentUpgrade, error) {
+	g, err := s.Graph()
+	if err != nil {
+		return nil, err
+	}
+	upgrades, err := s.PlanUpgrade(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	if !allowDowngrade {
+		var downgrades []*ComponentUpgrade
+		for _, upgrade := range upgrades {
+			if upgrade.Action == UpgradeActionDowngrade {
+				downgrades = append(downgrades, upgrade)
+			}
+		}
+		if len(downgrades) > 0 {
+			return upgrades, &DowngradeError{Downgrades: downgrades}
+		}
+	}
+	s.Upgrading = true
+	defer func() { s.Upgrading = false }()
+	for _, upgrade := range upgrades {
+		if upgrade.Action != UpgradeActionUpgrade && upgrade.Action != UpgradeActionDowngrade {
+			continue
+		}
+		comp := g.Component(upgrade.Component)
+		if err := s.runWithTimeout(ctx, comp, func(ctx context.Context, comp Component) error {
+			if err := s.installComponent(ctx, comp); err != nil {
+				return err
+			}
+			return s.waitForHealthy(ctx, comp)
+		}); err != nil {
+			return upgrades, fmt.Errorf("%s: %v", upgrade.Component, err)
+		}
+	}
+	return upgrades, nil
+}
+
+// waitForHealthy polls the component's status until it is healthy
+func (s *Installer) waitForHealthy(ctx context.Context, comp Component) error {
+	if s.DryRun || s.Remote {
+		// Without the cluster, only the Application can be
+		// seen, which CreateApplication already waited for
+		return nil
+	}
+	defer s.waiting(ctx, comp.GetName()+" to become healthy")()
+	records, err := s.ReadLedger(ctx)
+	if err != nil {
+		return err
+	}
+	for {
+		status, err := s.componentStatus(ctx, comp, records[comp.GetName()])
+		if err != nil {
+			return err
+		} else if status.Healthy {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("not healthy: %v", ctx.Err())
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func displayRevision(revision string) string {
+	if revision == "" {
+		return "unknown"
+	}
+	return revision
+}
+
+// PrintUpgradePlan writes the upgrades as a human readable table
+func PrintUpgradePlan(w io.Writer, upgrades []*ComponentUpgrade) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "COMPONENT\tDEPLOYED\tDESIRED\tACTION")
+	for _, upgrade := range upgrades {
+		from := displayRevision(upgrade.From)
+		if upgrade.Action == UpgradeActionNotInstalled {
+			from = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", upgrade.Component, from, displayRevision(upgrade.To), upgrade.Action)
+	}
+	return tw.Flush()
+}
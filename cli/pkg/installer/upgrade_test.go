@@ -0,0 +1,111 @@
+package installer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpgradeAction(t *testing.T) {
+	for _, test := range []struct {
+		from, to, action string
+	}{
+		{"argoproj/argocd:v1.4.2", "argoproj/argocd:v1.5.0", UpgradeActionUpgrade},
+		{"argoproj/argocd:v1.5.0", "argoproj/argocd:v1.5.0-rc1", UpgradeActionDowngrade},
+		{"registry.internal:5000/argocd:v1.5.0", "registry.internal:5000/argocd:v1.5", UpgradeActionNone},
+		{"charts/controller@0.2.0", "charts/controller@0.1.0", UpgradeActionDowngrade},
+		{"v1.0.0", "HEAD", UpgradeActionUpgrade},
+		{"HEAD", "HEAD", UpgradeActionNone},
+		{"", "argoproj/argocd:latest", UpgradeActionUpgrade},
+	} {
+		assert.Equal(t, test.action, upgradeAction(test.from, test.to), "%s -> %s", test.from, test.to)
+	}
+}
+
+func TestUpgradeRefusesDowngrade(t *testing.T) {
+	viper.Set("argocd.image", "argoproj/argocd:v1.4.3")
+	defer viper.Set("argocd.image", nil)
+	install, executor := newFakeInstaller(healthyArgoCD("password")...)
+	defer install.Reuse()
+	install.DryRun = true
+	upgrades, err := install.Upgrade(context.TODO(), []string{"argocd"}, false)
+	require.IsType(t, &DowngradeError{}, err)
+	require.Len(t, upgrades, 1)
+	assert.Equal(t, &ComponentUpgrade{
+		Component: "argocd",
+		From:      "argoproj/argocd:v1.5.0-rc1",
+		To:        "argoproj/argocd:v1.4.3",
+		Action:    UpgradeActionDowngrade,
+	}, upgrades[0])
+	assert.Empty(t, install.Plan.Steps)
+
+	_, err = install.Upgrade(context.TODO(), []string{"argocd"}, true)
+	require.NoError(t, err)
+	assert.Empty(t, executor.Commands)
+	var commands []string
+	for _, step := range install.Plan.Steps {
+		commands = append(commands, step.Command)
+	}
+	assert.Contains(t, commands, "kubectl apply -n argocd -f "+ArgoCDManifestURL)
+	assert.Contains(t, commands, `kubectl patch deployment argocd-server -n argocd --type=json -p='[{"op": "add", "path": "/spec/template/spec/containers/0/image", "value": "argoproj/argocd:v1.4.3"}]'`)
+}
+
+func TestUpgradeTracksHead(t *testing.T) {
+	install, _ := newFakeInstaller(healthyArgoCD("password")...)
+	install.Version = "0.1.0"
+	foldy := GetComponentByName("foldy")
+	require.Equal(t, "HEAD", foldy.(*ApplicationComponent).Revision)
+	require.NoError(t, install.updateLedger(context.TODO(), "foldy", install.newInstallRecord(foldy)))
+	action := func() string {
+		upgrades, err := install.PlanUpgrade(context.TODO(), []string{"foldy"})
+		require.NoError(t, err)
+		require.Len(t, upgrades, 1)
+		assert.Equal(t, "HEAD", upgrades[0].From)
+		return upgrades[0].Action
+	}
+	assert.Equal(t, UpgradeActionNone, action())
+
+	install.Version = "0.2.0"
+	assert.Equal(t, UpgradeActionUpgrade, action(), "a new CLI may come with a new head")
+
+	install.Version = "0.1.0"
+	viper.Set("ingress.enabled", true)
+	defer viper.Set("ingress.enabled", nil)
+	assert.Equal(t, UpgradeActionUpgrade, action(), "changed config must be deployed")
+}
+
+func TestUpgradeApplication(t *testing.T) {
+	install, executor := newFakeInstaller(healthyArgoCD("password")...)
+	defer install.Reuse()
+	install.DryRun = true
+	foldy := GetComponentByName("foldy")
+	record := install.newInstallRecord(foldy)
+	record.Revision = "v0.1.0"
+	install.DryRun = false
+	require.NoError(t, install.updateLedger(context.TODO(), "foldy", record))
+	install.DryRun = true
+	upgrades, err := install.PlanUpgrade(context.TODO(), nil)
+	require.NoError(t, err)
+	actions := make(map[string]string)
+	for _, upgrade := range upgrades {
+		actions[upgrade.Component] = upgrade.Action
+	}
+	assert.Equal(t, map[string]string{
+		"argocd": UpgradeActionUpgrade,
+		"foldy":  UpgradeActionUpgrade,
+	}, actions)
+	_, err = install.Upgrade(context.TODO(), []string{"foldy"}, false)
+	require.NoError(t, err)
+	assert.Empty(t, executor.Commands)
+	found := false
+	for _, step := range install.Plan.Steps {
+		if step.Component == "foldy" && strings.Contains(step.Command, "argocd app create foldy ") && strings.Contains(step.Command, " --upsert") {
+			found = true
+		}
+	}
+	assert.True(t, found, "the existing Application must be replaced")
+}
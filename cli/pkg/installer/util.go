@@ -5,13 +5,14 @@ import (
 	"fmt"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/spf13/viper"
 	"golang.org/x/crypto/bcrypt"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -21,18 +22,80 @@ func ComparePasswordHash(password string, hash []byte) bool {
 	return err == nil
 }
 
+// passwordHashCost is the bcrypt cost of HashPassword. Tests
+// lower it, as hashing at the full cost takes about a second.
+var passwordHashCost = 14
+
 func HashPassword(password string) string {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
 	if err != nil {
 		panic(err)
 	}
 	return string(bytes)
 }
 
-func NamespaceExists(cl client.Client, namespace string) (bool, error) {
+// resourceKinds maps the kubectl resource names used by the
+// installer to the kinds they refer to
+var resourceKinds = map[string]schema.GroupVersionKind{
+	"namespace": {
+		Version: "v1",
+		Kind:    "Namespace",
+	},
+	"crd": {
+		Group:   "apiextensions.k8s.io",
+		Version: "v1",
+		Kind:    "CustomResourceDefinition",
+	},
+	"application": {
+		Group:   "argoproj.io",
+		Version: "v1alpha1",
+		Kind:    "Application",
+	},
+	"clusterrole": {
+		Group:   "rbac.authorization.k8s.io",
+		Version: "v1",
+		Kind:    "ClusterRole",
+	},
+	"clusterrolebinding": {
+		Group:   "rbac.authorization.k8s.io",
+		Version: "v1",
+		Kind:    "ClusterRoleBinding",
+	},
+	"mutatingwebhookconfiguration": {
+		Group:   "admissionregistration.k8s.io",
+		Version: "v1",
+		Kind:    "MutatingWebhookConfiguration",
+	},
+	"validatingwebhookconfiguration": {
+		Group:   "admissionregistration.k8s.io",
+		Version: "v1",
+		Kind:    "ValidatingWebhookConfiguration",
+	},
+	"clusterissuer": {
+		Group:   "cert-manager.io",
+		Version: "v1alpha2",
+		Kind:    "ClusterIssuer",
+	},
+}
+
+// newObject returns an empty object of the given kubectl
+// resource type, suitable for passing to the client
+func newObject(resource string, name string, namespace string) (*unstructured.Unstructured, error) {
+	gvk, ok := resourceKinds[resource]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource type '%s'", resource)
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetName(name)
+	obj.SetNamespace(namespace)
+	return obj, nil
+}
+
+func NamespaceExists(ctx context.Context, cl client.Client, namespace string) (bool, error) {
 	if err := cl.Get(
-		context.TODO(),
-		types.NamespacedName{Name: "argocd"},
+		ctx,
+		types.NamespacedName{Name: namespace},
 		&corev1.Namespace{},
 	); err == nil {
 		return true, nil
@@ -43,40 +106,10 @@ func NamespaceExists(cl client.Client, namespace string) (bool, error) {
 	}
 }
 
-func WaitForDeployment(
-	cl client.Client,
-	name string,
-	namespace string,
-	retryInterval time.Duration,
-	timeout time.Duration,
-) error {
-	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); {
-		// Wait for argocd-server deployment to good
-		deployment := &appsv1.Deployment{}
-		if err := cl.Get(
-			context.TODO(),
-			types.NamespacedName{Name: name, Namespace: namespace},
-			deployment,
-		); err == nil {
-			var replicas int32 = 1
-			if deployment.Spec.Replicas != nil {
-				replicas = *deployment.Spec.Replicas
-			}
-			if deployment.Status.AvailableReplicas >= replicas {
-				// All replicas are available
-				return nil
-			}
-		} else if err != nil && !errors.IsNotFound(err) {
-			return err
-		}
-		<-time.After(retryInterval)
-	}
-	return nil
-}
-
 var ErrDeploymentNotReady = fmt.Errorf("deployment is not ready")
 
 func DeploymentIsHealthy(
+	ctx context.Context,
 	cl client.Client,
 	name string,
 	namespace string,
@@ -84,7 +117,7 @@ func DeploymentIsHealthy(
 	// Wait for argocd-server deployment to good
 	deployment := &appsv1.Deployment{}
 	if err := cl.Get(
-		context.TODO(),
+		ctx,
 		types.NamespacedName{Name: name, Namespace: namespace},
 		deployment,
 	); err != nil {
@@ -112,6 +145,8 @@ func ConfigureViper() {
 	err := viper.ReadInConfig()
 	if err != nil && !strings.Contains(strings.TrimSpace(err.Error()), `Config File "config" Not Found in`) {
 		panic(fmt.Errorf("fatal error config file: %v", err))
+	} else if err == nil {
+		configFile = viper.ConfigFileUsed()
 	}
 
 	// Load in credentials.yaml
@@ -119,8 +154,13 @@ func ConfigureViper() {
 	err = viper.MergeInConfig()
 	if err != nil && !strings.Contains(strings.TrimSpace(err.Error()), `Config File "credentials" Not Found in`) {
 		panic(fmt.Errorf("fatal error credentials file: %v", err))
+	} else if err == nil {
+		credentialsFile = viper.ConfigFileUsed()
 	}
 
+	// Allow environment override of every config.yaml key
+	bindConfigEnv()
+
 	// Allow environment override of username
 	if username, ok := os.LookupEnv("FOLDY_USERNAME"); ok {
 		viper.Set("username", username)
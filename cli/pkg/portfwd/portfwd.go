@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"path"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -39,10 +40,63 @@ func RunPortForward(
 	stopChan <-chan struct{},
 	verbose bool,
 ) error {
-	cl, err := client.New(config, client.Options{})
+	forwarder, err := newForwarder(config, serviceName, namespace, localPort, remotePort, stopChan, make(chan struct{}, 1))
 	if err != nil {
 		return err
 	}
+	return forwarder.ForwardPorts()
+}
+
+// Forward forwards a free local port to the service in the
+// background. It returns the local port once the forward is
+// ready, along with a channel that is closed when forwarding
+// stops, either because it failed or stopChan was closed.
+func Forward(
+	config *restclient.Config,
+	serviceName string,
+	namespace string,
+	remotePort int,
+	stopChan <-chan struct{},
+) (int, <-chan struct{}, error) {
+	readyChan := make(chan struct{})
+	forwarder, err := newForwarder(config, serviceName, namespace, 0, remotePort, stopChan, readyChan)
+	if err != nil {
+		return 0, nil, err
+	}
+	done := make(chan struct{})
+	errChan := make(chan error, 1)
+	go func() {
+		defer close(done)
+		errChan <- forwarder.ForwardPorts()
+	}()
+	select {
+	case <-readyChan:
+	case err := <-errChan:
+		if err == nil {
+			err = fmt.Errorf("port forward to %s/%s stopped", namespace, serviceName)
+		}
+		return 0, nil, err
+	}
+	ports, err := forwarder.GetPorts()
+	if err != nil {
+		return 0, nil, err
+	}
+	return int(ports[0].Local), done, nil
+}
+
+func newForwarder(
+	config *restclient.Config,
+	serviceName string,
+	namespace string,
+	localPort int,
+	remotePort int,
+	stopChan <-chan struct{},
+	readyChan chan struct{},
+) (*portforward.PortForwarder, error) {
+	cl, err := client.New(config, client.Options{})
+	if err != nil {
+		return nil, err
+	}
 	service := &corev1.Service{}
 	if err := cl.Get(
 		context.TODO(),
@@ -52,7 +106,7 @@ func RunPortForward(
 		},
 		service,
 	); err != nil {
-		return err
+		return nil, err
 	}
 	var containerPort *intstr.IntOrString
 	for _, svcPort := range service.Spec.Ports {
@@ -61,16 +115,17 @@ func RunPortForward(
 		}
 	}
 	if containerPort == nil {
-		return fmt.Errorf("unable to resolve containerPort for %v", remotePort)
+		return nil, fmt.Errorf("unable to resolve containerPort for %v", remotePort)
 	}
 	selector := service.Spec.Selector
 	pods := &corev1.PodList{}
 	if err := cl.List(
 		context.TODO(),
 		pods,
+		client.InNamespace(namespace),
 		client.MatchingLabels(selector),
 	); err != nil {
-		return err
+		return nil, err
 	}
 	actualPort := containerPort.IntVal
 	var podName string
@@ -93,35 +148,43 @@ func RunPortForward(
 		}
 	}
 	if podName == "" {
-		return fmt.Errorf("unable to resolve pod")
+		return nil, fmt.Errorf("unable to resolve pod")
 	}
 	if actualPort == 0 {
-		return fmt.Errorf("unable to resolve port")
+		return nil, fmt.Errorf("unable to resolve port")
+	}
+	serverURL, err := portForwardURL(config.Host, namespace, podName)
+	if err != nil {
+		return nil, err
 	}
-	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/portforward", namespace, podName)
-	hostIP := strings.TrimLeft(config.Host, "htps:/")
-	serverURL := url.URL{Scheme: "https", Path: path, Host: hostIP}
 	roundTripper, upgrader, err := spdy.RoundTripperFor(config)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: roundTripper}, http.MethodPost, &serverURL)
-	readyChan := make(chan struct{}, 1)
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: roundTripper}, http.MethodPost, serverURL)
 	out, errOut := new(bytes.Buffer), new(bytes.Buffer)
-	forwarder, err := portforward.New(
+	return portforward.New(
 		dialer,
 		[]string{fmt.Sprintf("%d:%d", localPort, actualPort)},
 		stopChan,
 		readyChan,
 		out,
 		errOut)
-	if err != nil {
-		return err
+}
+
+// portForwardURL returns the URL of the pod's portforward
+// subresource. The API server may be served under a path, e.g.
+// behind a proxy, which the subresource is relative to.
+func portForwardURL(host string, namespace string, podName string) (*url.URL, error) {
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
 	}
-	if err := forwarder.ForwardPorts(); err != nil {
-		return err
+	serverURL, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API server address '%s': %v", host, err)
 	}
-	return nil
+	serverURL.Path = path.Join("/", serverURL.Path, "api", "v1", "namespaces", namespace, "pods", podName, "portforward")
+	return serverURL, nil
 }
 
 func NewFoldyPortForwarder(config *restclient.Config) (*FoldyPortForwarder, error) {
@@ -0,0 +1,219 @@
+package readiness
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Resources the conditions are defined for
+var (
+	Deployments  = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	StatefulSets = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}
+	DaemonSets   = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}
+	Jobs         = schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}
+	CRDs         = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+	Namespaces   = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+)
+
+// CheckFunc reports whether the object satisfies a condition,
+// along with a short description of its current state. obj is
+// nil if the object doesn't exist. An error means the condition
+// can never be met, e.g. because a Job failed.
+type CheckFunc func(obj *unstructured.Unstructured) (met bool, state string, err error)
+
+// Condition is a state that a single object is waited on to
+// reach
+type Condition struct {
+	Resource    schema.GroupVersionResource
+	Namespace   string
+	Name        string
+	Description string // What is waited for, e.g. "available"
+	Check       CheckFunc
+}
+
+func (c Condition) String() string {
+	name := c.Name
+	if c.Namespace != "" {
+		name = c.Namespace + "/" + name
+	}
+	return fmt.Sprintf("%s %s to be %s", c.Resource.Resource, name, c.Description)
+}
+
+// DeploymentAvailable is met once every replica of the
+// Deployment's current generation is updated and available.
+// Replicas of the previous generation remain available during
+// a rollout, so they alone don't count.
+func DeploymentAvailable(namespace string, name string) Condition {
+	return Condition{
+		Resource:    Deployments,
+		Namespace:   namespace,
+		Name:        name,
+		Description: "available",
+		Check: func(obj *unstructured.Unstructured) (bool, string, error) {
+			if obj == nil {
+				return false, "not found", nil
+			}
+			deployment := &appsv1.Deployment{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, deployment); err != nil {
+				return false, "", err
+			}
+			replicas := replicasOf(deployment.Spec.Replicas)
+			status := deployment.Status
+			state := fmt.Sprintf("%d/%d replicas updated, %d available", status.UpdatedReplicas, replicas, status.AvailableReplicas)
+			if status.ObservedGeneration < deployment.Generation {
+				return false, "rollout not observed yet", nil
+			}
+			return status.UpdatedReplicas >= replicas && status.AvailableReplicas >= replicas, state, nil
+		},
+	}
+}
+
+// StatefulSetReady is met once every replica of the
+// StatefulSet is ready
+func StatefulSetReady(namespace string, name string) Condition {
+	return Condition{
+		Resource:    StatefulSets,
+		Namespace:   namespace,
+		Name:        name,
+		Description: "ready",
+		Check: func(obj *unstructured.Unstructured) (bool, string, error) {
+			if obj == nil {
+				return false, "not found", nil
+			}
+			statefulSet := &appsv1.StatefulSet{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, statefulSet); err != nil {
+				return false, "", err
+			}
+			replicas := replicasOf(statefulSet.Spec.Replicas)
+			state := fmt.Sprintf("%d/%d replicas ready", statefulSet.Status.ReadyReplicas, replicas)
+			if statefulSet.Status.ObservedGeneration < statefulSet.Generation {
+				return false, "rollout not observed yet", nil
+			}
+			return statefulSet.Status.ReadyReplicas >= replicas, state, nil
+		},
+	}
+}
+
+// DaemonSetReady is met once the DaemonSet's pod is available
+// on every node it is scheduled to
+func DaemonSetReady(namespace string, name string) Condition {
+	return Condition{
+		Resource:    DaemonSets,
+		Namespace:   namespace,
+		Name:        name,
+		Description: "ready",
+		Check: func(obj *unstructured.Unstructured) (bool, string, error) {
+			if obj == nil {
+				return false, "not found", nil
+			}
+			daemonSet := &appsv1.DaemonSet{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, daemonSet); err != nil {
+				return false, "", err
+			}
+			status := daemonSet.Status
+			state := fmt.Sprintf("%d/%d pods available", status.NumberAvailable, status.DesiredNumberScheduled)
+			if status.ObservedGeneration < daemonSet.Generation {
+				return false, "rollout not observed yet", nil
+			}
+			return status.UpdatedNumberScheduled >= status.DesiredNumberScheduled &&
+				status.NumberAvailable >= status.DesiredNumberScheduled, state, nil
+		},
+	}
+}
+
+// JobComplete is met once the Job completed. It fails if the
+// Job failed, since it will not be retried.
+func JobComplete(namespace string, name string) Condition {
+	return Condition{
+		Resource:    Jobs,
+		Namespace:   namespace,
+		Name:        name,
+		Description: "complete",
+		Check: func(obj *unstructured.Unstructured) (bool, string, error) {
+			if obj == nil {
+				return false, "not found", nil
+			}
+			job := &batchv1.Job{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, job); err != nil {
+				return false, "", err
+			}
+			for _, cond := range job.Status.Conditions {
+				if cond.Status != corev1.ConditionTrue {
+					continue
+				}
+				switch cond.Type {
+				case batchv1.JobComplete:
+					return true, "complete", nil
+				case batchv1.JobFailed:
+					return false, "failed", fmt.Errorf("job %s/%s failed: %s", namespace, name, cond.Message)
+				}
+			}
+			return false, fmt.Sprintf("%d active, %d succeeded", job.Status.Active, job.Status.Succeeded), nil
+		},
+	}
+}
+
+// CRDEstablished is met once the CustomResourceDefinition is
+// being served, i.e. custom resources of it can be created
+func CRDEstablished(name string) Condition {
+	return Condition{
+		Resource:    CRDs,
+		Name:        name,
+		Description: "established",
+		Check: func(obj *unstructured.Unstructured) (bool, string, error) {
+			if obj == nil {
+				return false, "not found", nil
+			}
+			conditions, _, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+			if err != nil {
+				return false, "", err
+			}
+			for _, cond := range conditions {
+				cond, ok := cond.(map[string]interface{})
+				if ok && cond["type"] == "Established" && cond["status"] == "True" {
+					return true, "established", nil
+				}
+			}
+			return false, "not established", nil
+		},
+	}
+}
+
+// Gone is met once the object no longer exists, i.e. after
+// all of its finalizers have run
+func Gone(resource schema.GroupVersionResource, namespace string, name string) Condition {
+	return Condition{
+		Resource:    resource,
+		Namespace:   namespace,
+		Name:        name,
+		Description: "gone",
+		Check: func(obj *unstructured.Unstructured) (bool, string, error) {
+			if obj == nil {
+				return true, "gone", nil
+			}
+			if obj.GetDeletionTimestamp() != nil {
+				return false, fmt.Sprintf("terminating, finalizers %v", obj.GetFinalizers()), nil
+			}
+			return false, "exists", nil
+		},
+	}
+}
+
+// NamespaceGone is met once the namespace and everything in
+// it has been deleted
+func NamespaceGone(name string) Condition {
+	return Gone(Namespaces, "", name)
+}
+
+func replicasOf(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
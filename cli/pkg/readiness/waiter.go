@@ -0,0 +1,171 @@
+// Package readiness waits for Kubernetes resources to reach a
+// condition, such as a Deployment becoming available or a
+// namespace being gone. Resources are watched rather than
+// polled, and a TimeoutError names every condition that was
+// not met in time.
+package readiness
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// Unmet is a condition that was not met in time, along with
+// the last observed state of its object
+type Unmet struct {
+	Condition Condition
+	State     string
+}
+
+func (u *Unmet) String() string {
+	if u.State == "" {
+		return u.Condition.String()
+	}
+	return fmt.Sprintf("%s (%s)", u.Condition, u.State)
+}
+
+// TimeoutError is returned when the context ends before every
+// condition is met
+type TimeoutError struct {
+	Unmet []*Unmet
+	Err   error // The context's error
+}
+
+func (e *TimeoutError) Error() string {
+	descs := make([]string, len(e.Unmet))
+	for i, unmet := range e.Unmet {
+		descs[i] = unmet.String()
+	}
+	return fmt.Sprintf("timed out waiting for %s: %v", strings.Join(descs, ", "), e.Err)
+}
+
+// IsTimeout returns true if err is a *TimeoutError
+func IsTimeout(err error) bool {
+	_, ok := err.(*TimeoutError)
+	return ok
+}
+
+// Waiter waits on conditions by watching the resources
+type Waiter struct {
+	client dynamic.Interface
+}
+
+func NewWaiter(client dynamic.Interface) *Waiter {
+	return &Waiter{client: client}
+}
+
+// NewWaiterForConfig returns a waiter for the cluster
+func NewWaiterForConfig(config *restclient.Config) (*Waiter, error) {
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return NewWaiter(client), nil
+}
+
+// Wait blocks until every condition is met, waiting on them
+// concurrently. A *TimeoutError listing the unmet conditions
+// is returned if the context ends first. Any other error is
+// returned as soon as it occurs.
+func (w *Waiter) Wait(ctx context.Context, conditions ...Condition) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		unmet *Unmet
+		err   error
+	}
+	results := make(chan result, len(conditions))
+	for _, cond := range conditions {
+		go func(cond Condition) {
+			unmet, err := w.wait(ctx, cond)
+			results <- result{unmet, err}
+		}(cond)
+	}
+	timeout := &TimeoutError{}
+	var failure error
+	for range conditions {
+		result := <-results
+		if result.err != nil && failure == nil {
+			// Stop waiting on the others
+			failure = result.err
+			cancel()
+		} else if result.unmet != nil {
+			timeout.Unmet = append(timeout.Unmet, result.unmet)
+		}
+	}
+	if failure != nil {
+		return failure
+	}
+	if len(timeout.Unmet) > 0 {
+		sort.Slice(timeout.Unmet, func(i, j int) bool {
+			return timeout.Unmet[i].String() < timeout.Unmet[j].String()
+		})
+		timeout.Err = ctx.Err()
+		return timeout
+	}
+	return nil
+}
+
+// wait watches the condition's object until the condition is
+// met, returning the condition as unmet if the context ends
+func (w *Waiter) wait(ctx context.Context, cond Condition) (*Unmet, error) {
+	unmet := &Unmet{Condition: cond, State: "not observed yet"}
+	client := w.client.Resource(cond.Resource).Namespace(cond.Namespace)
+	selector := fields.OneTermEqualSelector("metadata.name", cond.Name).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return client.List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return client.Watch(options)
+		},
+	}
+	check := func(obj *unstructured.Unstructured) (bool, error) {
+		met, state, err := cond.Check(obj)
+		unmet.State = state
+		return met, err
+	}
+	_, err := watchtools.UntilWithSync(ctx, lw, &unstructured.Unstructured{}, func(store cache.Store) (bool, error) {
+		key := cond.Name
+		if cond.Namespace != "" {
+			key = cond.Namespace + "/" + cond.Name
+		}
+		obj, exists, err := store.GetByKey(key)
+		if err != nil {
+			return false, err
+		} else if !exists {
+			return check(nil)
+		}
+		return check(obj.(*unstructured.Unstructured))
+	}, func(event watch.Event) (bool, error) {
+		obj, ok := event.Object.(*unstructured.Unstructured)
+		if !ok || obj.GetName() != cond.Name {
+			return false, nil
+		}
+		if event.Type == watch.Deleted {
+			return check(nil)
+		}
+		return check(obj)
+	})
+	if err == wait.ErrWaitTimeout || (err != nil && ctx.Err() != nil) {
+		return unmet, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %v", cond, err)
+	}
+	return nil, nil
+}
@@ -0,0 +1,148 @@
+package readiness
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+func deployment(updated int32, available int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "foo"},
+		Status: appsv1.DeploymentStatus{
+			UpdatedReplicas:   updated,
+			AvailableReplicas: available,
+		},
+	}
+}
+
+// newFakeClient returns a dynamic client serving the objects,
+// which the fake client only lists as unstructured
+func newFakeClient(t *testing.T, objs ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	var converted []runtime.Object
+	for _, obj := range objs {
+		converted = append(converted, toUnstructured(t, obj))
+	}
+	return dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), converted...)
+}
+
+func toUnstructured(t *testing.T, obj runtime.Object) *unstructured.Unstructured {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return u
+	}
+	gvks, _, err := scheme.Scheme.ObjectKinds(obj)
+	require.NoError(t, err)
+	data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	require.NoError(t, err)
+	u := &unstructured.Unstructured{Object: data}
+	u.SetGroupVersionKind(gvks[0])
+	return u
+}
+
+func TestWaitAlreadyMet(t *testing.T) {
+	client := newFakeClient(t, deployment(1, 1))
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, NewWaiter(client).Wait(ctx, DeploymentAvailable("foo", "server")))
+}
+
+func TestWaitWatchesChanges(t *testing.T) {
+	client := newFakeClient(t, deployment(0, 0))
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		obj := toUnstructured(t, deployment(1, 1))
+		client.Resource(Deployments).Namespace("foo").UpdateStatus(obj, metav1.UpdateOptions{})
+	}()
+	assert.NoError(t, NewWaiter(client).Wait(ctx, DeploymentAvailable("foo", "server")))
+}
+
+func TestWaitTimeout(t *testing.T) {
+	client := newFakeClient(t,
+		deployment(0, 0),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "bar"}})
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	err := NewWaiter(client).Wait(ctx,
+		DeploymentAvailable("foo", "server"),
+		StatefulSetReady("foo", "db"),
+		NamespaceGone("bar"))
+	require.True(t, IsTimeout(err), "expected a timeout, got %v", err)
+	unmet := err.(*TimeoutError).Unmet
+	require.Len(t, unmet, 3)
+	assert.Equal(t, "deployments foo/server to be available (0/1 replicas updated, 0 available)", unmet[0].String())
+	assert.Equal(t, "namespaces bar to be gone (exists)", unmet[1].String())
+	assert.Equal(t, "statefulsets foo/db to be ready (not found)", unmet[2].String())
+	assert.Equal(t, context.DeadlineExceeded, err.(*TimeoutError).Err)
+}
+
+func TestWaitRollout(t *testing.T) {
+	// Only the previous generation's replica is available
+	client := newFakeClient(t, deployment(0, 1))
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	err := NewWaiter(client).Wait(ctx, DeploymentAvailable("foo", "server"))
+	require.True(t, IsTimeout(err), "expected a timeout, got %v", err)
+	assert.Equal(t, "0/1 replicas updated, 1 available", err.(*TimeoutError).Unmet[0].State)
+}
+
+func TestWaitNamespaceGone(t *testing.T) {
+	client := newFakeClient(t,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "bar"}})
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		client.Resource(Namespaces).Delete("bar", &metav1.DeleteOptions{})
+	}()
+	assert.NoError(t, NewWaiter(client).Wait(ctx, NamespaceGone("bar")))
+}
+
+func TestWaitJobFailed(t *testing.T) {
+	client := newFakeClient(t, &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "migrate", Namespace: "foo"},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{{
+				Type:    batchv1.JobFailed,
+				Status:  corev1.ConditionTrue,
+				Message: "Job has reached the specified backoff limit",
+			}},
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	err := NewWaiter(client).Wait(ctx, JobComplete("foo", "migrate"))
+	require.Error(t, err)
+	assert.False(t, IsTimeout(err), "a failed job must not be waited on")
+	assert.Contains(t, err.Error(), "Job has reached the specified backoff limit")
+}
+
+func TestWaitCRDEstablished(t *testing.T) {
+	crd := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata":   map[string]interface{}{"name": "models.app.foldy.dev"},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "NamesAccepted", "status": "True"},
+				map[string]interface{}{"type": "Established", "status": "True"},
+			},
+		},
+	}}
+	client := newFakeClient(t, crd)
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, NewWaiter(client).Wait(ctx, CRDEstablished("models.app.foldy.dev")))
+}
@@ -3,6 +3,7 @@ module github.com/foldy-project/foldy
 go 1.13
 
 require (
+	github.com/Masterminds/semver/v3 v3.0.3
 	github.com/go-logr/logr v0.1.0
 	github.com/hashicorp/go-multierror v1.0.0
 	github.com/operator-framework/operator-sdk v0.15.2
@@ -12,11 +13,13 @@ require (
 	github.com/spf13/viper v1.4.0
 	github.com/stretchr/testify v1.4.0
 	golang.org/x/crypto v0.0.0-20191028145041-f83a4685e152
+	helm.sh/helm/v3 v3.0.2
 	k8s.io/api v0.0.0
 	k8s.io/apimachinery v0.0.0
 	k8s.io/client-go v12.0.0+incompatible
 	k8s.io/helm v2.16.1+incompatible
 	sigs.k8s.io/controller-runtime v0.4.0
+	sigs.k8s.io/yaml v1.1.0
 )
 
 // Pinned to kubernetes-1.16.2